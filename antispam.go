@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/failoverbar/bot/antispam"
+	"github.com/failoverbar/bot/model"
+	"github.com/failoverbar/bot/wrap"
+	"log"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// floodReason is the reason of bans made by AntiSpam.
+const floodReason = "flood"
+
+// AntiSpam drops updates of users who exceed limits of guard in private chats before they reach the storage.
+// Users who keep flooding are banned for a while, the ban is stored in bans to outlive restarts
+// and is enforced by Banned afterwards. Longer bans made by admins are kept.
+// Staff are not limited, their role is looked up once they exceed the limits.
+func AntiSpam(guard *antispam.Guard, users model.UserStorage, bans model.BanStorage) tele.MiddlewareFunc {
+	return func(next tele.HandlerFunc) tele.HandlerFunc {
+		return func(c tele.Context) error {
+			if c.Sender() == nil || c.Chat() == nil || c.Chat().Type != tele.ChatPrivate {
+				return next(c)
+			}
+			verdict := guard.Check(c.Sender().ID)
+			if verdict == antispam.Allow {
+				return next(c)
+			}
+			ctx, cancel := requestContext(c)
+			defer cancel()
+			if _, ok := guard.Trusted(c.Sender().ID); !ok {
+				staff, err := hasRole(ctx, users, c, model.RoleStaff)
+				if err != nil {
+					return err
+				}
+				guard.Trust(c.Sender().ID, staff)
+				if staff {
+					return next(c)
+				}
+			}
+			if verdict == antispam.Drop {
+				return nil
+			}
+			userID := uint64(c.Sender().ID)
+			until := time.Now().Add(guard.BanDuration())
+			ban, err := bans.Get(ctx, userID)
+			if err != nil && !errors.Is(err, wrap.NotFoundError{}) {
+				return err
+			}
+			if err == nil && ban.Active(time.Now()) && (ban.Until == nil || ban.Until.After(until)) {
+				guard.Remember(c.Sender().ID, banEnd(ban))
+				return nil
+			}
+			if err := bans.Upsert(ctx, &model.Ban{UserID: userID, Reason: floodReason, Until: &until}); err != nil {
+				return err
+			}
+			log.Printf("user %d is banned for flood until %s", userID, until)
+			return c.Send("Слишком много сообщений. Я не буду отвечать тебе до " + formatTime(until) + ".")
+		}
+	}
+}
+
+// Banned drops updates from banned users. Bans are looked up in storage only when guard doesn't know them.
+func Banned(guard *antispam.Guard, bans model.BanStorage) tele.MiddlewareFunc {
+	return func(next tele.HandlerFunc) tele.HandlerFunc {
+		return func(c tele.Context) error {
+			if c.Sender() == nil {
+				return next(c)
+			}
+			banned, ok := guard.Banned(c.Sender().ID)
+			if !ok {
+				ctx, cancel := requestContext(c)
+				defer cancel()
+				var err error
+				if banned, err = lookupBan(ctx, guard, bans, c.Sender().ID); err != nil {
+					return err
+				}
+			}
+			if !banned {
+				return next(c)
+			}
+			log.Printf("drop update of banned user %d", c.Sender().ID)
+			return nil
+		}
+	}
+}
+
+// lookupBan tells whether the user is banned according to bans and remembers it in guard.
+func lookupBan(ctx context.Context, guard *antispam.Guard, bans model.BanStorage, userID int64) (bool, error) {
+	ban, err := bans.Get(ctx, uint64(userID))
+	if err != nil && !errors.Is(err, wrap.NotFoundError{}) {
+		return false, err
+	}
+	if err != nil || !ban.Active(time.Now()) {
+		guard.Remember(userID, time.Time{})
+		return false, nil
+	}
+	guard.Remember(userID, banEnd(ban))
+	return true, nil
+}
+
+// banEnd is the time the ban ends at, antispam.Forever for permanent bans.
+func banEnd(ban *model.Ban) time.Time {
+	if ban.Until == nil {
+		return antispam.Forever
+	}
+	return *ban.Until
+}
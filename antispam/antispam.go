@@ -0,0 +1,273 @@
+// Package antispam limits how often every user may send updates with token buckets
+// and tells when a user floods long enough to be banned:
+//
+//	g := &antispam.Guard{}
+//	switch g.Check(userID) {
+//	case antispam.Drop: // ignore the update
+//	case antispam.Ban: // ban the user for g.BanDuration()
+//	}
+//
+// Guard also caches bans found in storage, so that they are looked up once per DefaultBanTTL:
+//
+//	banned, ok := g.Banned(userID)
+//	if !ok {
+//		// look the ban up in storage
+//		g.Remember(userID, until) // zero until if there is no ban
+//	}
+//
+// Users such as staff may be exempted from limits with Trust.
+package antispam
+
+import (
+	"sync"
+	"time"
+)
+
+// Defaults of Guard limits. A user may send DefaultBurst updates at once and then one per
+// DefaultInterval. DefaultStrikes updates dropped within DefaultStrikeWindow ban the user for DefaultBanFor.
+const (
+	DefaultInterval     = time.Second
+	DefaultBurst        = 10
+	DefaultStrikes      = 20
+	DefaultStrikeWindow = time.Minute
+	DefaultBanFor       = time.Hour
+	DefaultBanTTL       = 10 * time.Minute
+	// sweepInterval is how often idle users are forgotten.
+	sweepInterval = 10 * time.Minute
+)
+
+// Forever is the end of permanent bans passed to Remember.
+var Forever = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Verdict is what to do with an update.
+type Verdict int
+
+const (
+	// Allow passes the update to handlers.
+	Allow Verdict = iota
+	// Drop ignores the update of the user who exceeded the limit or is banned.
+	Drop
+	// Ban ignores the update and reports that the user should be banned. It is returned once per ban.
+	Ban
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Allow:
+		return "allow"
+	case Drop:
+		return "drop"
+	case Ban:
+		return "ban"
+	}
+	return "unknown"
+}
+
+// Guard keeps a token bucket of every user. It is safe for concurrent use, zero Guard uses defaults.
+type Guard struct {
+	// Interval is the time a token is refilled in, DefaultInterval is used if it is zero.
+	Interval time.Duration
+	// Burst is the size of the bucket, DefaultBurst is used if it is zero.
+	Burst int
+	// Strikes is the number of dropped updates within StrikeWindow which bans the user,
+	// DefaultStrikes and DefaultStrikeWindow are used if they are zero.
+	Strikes      int
+	StrikeWindow time.Duration
+	// BanFor is the duration of bans, DefaultBanFor is used if it is zero.
+	BanFor time.Duration
+	// BanTTL is how long bans passed to Remember are trusted, DefaultBanTTL is used if it is zero.
+	BanTTL time.Duration
+	// Now returns the current time, time.Now is used if it is nil.
+	Now func() time.Time
+
+	mu        sync.Mutex
+	users     map[int64]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// strikes are counted since firstStrike.
+	strikes     int
+	firstStrike time.Time
+	// floodUntil is the end of the ban reported by Check, bannedUntil is the one passed to Remember.
+	floodUntil  time.Time
+	bannedUntil time.Time
+	// remembered is the time the ban was passed to Remember.
+	remembered time.Time
+	// trusted users are not limited, trustKnown tells whether Trust was called.
+	trusted    bool
+	trustKnown bool
+}
+
+// Check takes a token from the bucket of the user.
+func (g *Guard) Check(userID int64) Verdict {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	if now.Sub(g.lastSweep) >= sweepInterval {
+		g.sweep(now)
+	}
+	b := g.bucket(userID, now)
+	if now.Before(b.bannedUntil) || now.Before(b.floodUntil) {
+		return Drop
+	}
+	if b.trusted {
+		return Allow
+	}
+	b.tokens += float64(now.Sub(b.updated)) / float64(g.interval())
+	if b.tokens > float64(g.burst()) {
+		b.tokens = float64(g.burst())
+	}
+	b.updated = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return Allow
+	}
+
+	if now.Sub(b.firstStrike) > g.strikeWindow() {
+		b.strikes, b.firstStrike = 0, now
+	}
+	b.strikes++
+	if b.strikes < g.strikes() {
+		return Drop
+	}
+	b.strikes = 0
+	b.floodUntil = now.Add(g.BanDuration())
+	return Ban
+}
+
+// Trusted tells whether the user is exempted from limits. ok is false if Trust was not called for the user
+// since the guard forgot the user.
+func (g *Guard) Trusted(userID int64) (trusted, ok bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b, found := g.users[userID]
+	if !found {
+		return false, false
+	}
+	return b.trusted, b.trustKnown
+}
+
+// Trust exempts the user from limits or not. Exempting lifts the ban reported by Check,
+// but not the one passed to Remember.
+func (g *Guard) Trust(userID int64, trusted bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	b := g.bucket(userID, now)
+	b.trusted, b.trustKnown = trusted, true
+	if trusted {
+		b.tokens, b.strikes, b.floodUntil = float64(g.burst()), 0, time.Time{}
+	}
+}
+
+// Banned tells whether the user is banned by Check or by the ban passed to Remember.
+// ok is false if the ban of the user has to be looked up in storage.
+func (g *Guard) Banned(userID int64) (banned, ok bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	b, found := g.users[userID]
+	if !found {
+		return false, false
+	}
+	if now.Before(b.bannedUntil) || now.Before(b.floodUntil) {
+		return true, true
+	}
+	return false, now.Sub(b.remembered) < g.banTTL()
+}
+
+// Remember caches the ban of the user until the time, zero time means the user is not banned.
+// Check drops updates of the user while the ban lasts.
+func (g *Guard) Remember(userID int64, until time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	b := g.bucket(userID, now)
+	b.bannedUntil, b.remembered = until, now
+}
+
+// Forgive lifts the ban of the user kept by the guard, e.g. when an admin unbans the user.
+func (g *Guard) Forgive(userID int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.users, userID)
+}
+
+// BanDuration is the duration of bans reported by Check.
+func (g *Guard) BanDuration() time.Duration {
+	if g.BanFor > 0 {
+		return g.BanFor
+	}
+	return DefaultBanFor
+}
+
+// bucket returns the bucket of the user creating a full one if needed.
+func (g *Guard) bucket(userID int64, now time.Time) *bucket {
+	if g.users == nil {
+		g.users = map[int64]*bucket{}
+	}
+	b, ok := g.users[userID]
+	if !ok {
+		b = &bucket{tokens: float64(g.burst()), updated: now}
+		g.users[userID] = b
+	}
+	return b
+}
+
+// sweep forgets users whose buckets are full and who are not banned, they are indistinguishable from new ones
+// but for the ban cache, which is looked up again.
+func (g *Guard) sweep(now time.Time) {
+	refill := g.interval() * time.Duration(g.burst())
+	for id, b := range g.users {
+		if now.Sub(b.updated) >= refill && now.Sub(b.firstStrike) > g.strikeWindow() &&
+			!now.Before(b.bannedUntil) && !now.Before(b.floodUntil) {
+			delete(g.users, id)
+		}
+	}
+	g.lastSweep = now
+}
+
+func (g *Guard) now() time.Time {
+	if g.Now != nil {
+		return g.Now()
+	}
+	return time.Now()
+}
+
+func (g *Guard) interval() time.Duration {
+	if g.Interval > 0 {
+		return g.Interval
+	}
+	return DefaultInterval
+}
+
+func (g *Guard) burst() int {
+	if g.Burst > 0 {
+		return g.Burst
+	}
+	return DefaultBurst
+}
+
+func (g *Guard) strikes() int {
+	if g.Strikes > 0 {
+		return g.Strikes
+	}
+	return DefaultStrikes
+}
+
+func (g *Guard) banTTL() time.Duration {
+	if g.BanTTL > 0 {
+		return g.BanTTL
+	}
+	return DefaultBanTTL
+}
+
+func (g *Guard) strikeWindow() time.Duration {
+	if g.StrikeWindow > 0 {
+		return g.StrikeWindow
+	}
+	return DefaultStrikeWindow
+}
@@ -0,0 +1,124 @@
+package antispam
+
+import (
+	"testing"
+	"time"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func newGuard() (*Guard, *clock) {
+	c := &clock{now: time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)}
+	return &Guard{Interval: time.Second, Burst: 3, Strikes: 3, StrikeWindow: time.Minute, BanFor: time.Hour, Now: c.Now}, c
+}
+
+func check(t *testing.T, g *Guard, userID int64, want ...Verdict) {
+	t.Helper()
+	for i, w := range want {
+		if got := g.Check(userID); got != w {
+			t.Fatalf("check %d of %d: got %s, want %s", i+1, userID, got, w)
+		}
+	}
+}
+
+func TestBucket(t *testing.T) {
+	g, c := newGuard()
+	check(t, g, 1, Allow, Allow, Allow, Drop)
+	check(t, g, 2, Allow)
+
+	c.now = c.now.Add(time.Second)
+	check(t, g, 1, Allow, Drop)
+
+	c.now = c.now.Add(10 * time.Second)
+	check(t, g, 1, Allow, Allow, Allow)
+}
+
+func TestBan(t *testing.T) {
+	g, c := newGuard()
+	check(t, g, 1, Allow, Allow, Allow, Drop, Drop, Ban, Drop)
+
+	c.now = c.now.Add(30 * time.Minute)
+	check(t, g, 1, Drop)
+
+	c.now = c.now.Add(31 * time.Minute)
+	check(t, g, 1, Allow)
+
+	check(t, g, 1, Allow, Allow, Drop, Drop, Ban)
+	g.Forgive(1)
+	check(t, g, 1, Allow)
+}
+
+func TestStrikesExpire(t *testing.T) {
+	g, c := newGuard()
+	check(t, g, 1, Allow, Allow, Allow, Drop, Drop)
+	c.now = c.now.Add(2 * time.Minute)
+	check(t, g, 1, Allow, Allow, Allow, Drop, Drop)
+}
+
+func TestSweep(t *testing.T) {
+	g, c := newGuard()
+	check(t, g, 1, Allow, Allow, Allow, Drop, Drop, Ban)
+	check(t, g, 2, Allow)
+	c.now = c.now.Add(sweepInterval)
+	check(t, g, 3, Allow)
+	if _, ok := g.users[2]; ok {
+		t.Error("idle user is kept")
+	}
+	if _, ok := g.users[1]; !ok {
+		t.Error("banned user is forgotten")
+	}
+}
+
+func TestRemember(t *testing.T) {
+	g, c := newGuard()
+	if _, ok := g.Banned(1); ok {
+		t.Fatal("unknown user is cached")
+	}
+	g.Remember(1, time.Time{})
+	if banned, ok := g.Banned(1); banned || !ok {
+		t.Error("user without a ban is not cached", banned, ok)
+	}
+	c.now = c.now.Add(DefaultBanTTL)
+	if _, ok := g.Banned(1); ok {
+		t.Error("cache is not expired")
+	}
+
+	g.Remember(2, c.now.Add(time.Minute))
+	if banned, ok := g.Banned(2); !banned || !ok {
+		t.Error("ban is not cached", banned, ok)
+	}
+	check(t, g, 2, Drop)
+	c.now = c.now.Add(time.Minute)
+	check(t, g, 2, Allow)
+
+	g.Remember(3, Forever)
+	g.Forgive(3)
+	if _, ok := g.Banned(3); ok {
+		t.Error("forgiven ban is cached")
+	}
+}
+
+func TestTrust(t *testing.T) {
+	g, _ := newGuard()
+	if _, ok := g.Trusted(1); ok {
+		t.Fatal("trust of unknown user is known")
+	}
+	check(t, g, 1, Allow, Allow, Allow, Drop, Drop, Ban)
+	g.Trust(1, true)
+	if trusted, ok := g.Trusted(1); !trusted || !ok {
+		t.Error("user is not trusted", trusted, ok)
+	}
+	check(t, g, 1, Allow, Allow, Allow, Allow, Allow, Allow)
+
+	g.Remember(1, Forever)
+	check(t, g, 1, Drop)
+
+	g.Trust(2, false)
+	check(t, g, 2, Allow, Allow, Allow, Drop)
+}
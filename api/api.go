@@ -0,0 +1,598 @@
+// Package api serves the bot data over HTTP for internal tools, see openapi.yaml.
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/failoverbar/bot/model"
+	"github.com/failoverbar/bot/phone"
+	"github.com/failoverbar/bot/wrap"
+	"io"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed openapi.yaml
+var openAPI []byte
+
+// Actor makes changes through the API, see model.WithActor.
+const Actor = "api"
+
+// Page sizes of list endpoints.
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+// maxBody limits size of request bodies.
+const maxBody = 1 << 20
+
+// requestTimeout limits handling of a request like the bot limits handling of an update.
+const requestTimeout = 10 * time.Second
+
+// Server serves the API under /api/. Every request but the OpenAPI description
+// must have the "Authorization: Bearer <Token>" header.
+type Server struct {
+	Storage model.Storage
+	Token   string
+}
+
+// statusError is an error shown to the client as is.
+type statusError struct {
+	status int
+	msg    string
+}
+
+func (e statusError) Error() string {
+	return e.msg
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return statusError{status: http.StatusBadRequest, msg: fmt.Sprintf(format, args...)}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/api/") {
+		writeError(w, wrap.NotFoundError{})
+		return
+	}
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/"), "/"), "/")
+	if r.Method == http.MethodGet && len(path) == 1 && path[0] == "openapi.yaml" {
+		w.Header().Set("Content-Type", "application/yaml")
+		_, _ = w.Write(openAPI)
+		return
+	}
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, statusError{status: http.StatusUnauthorized, msg: "invalid token"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(model.WithActor(r.Context(), Actor), requestTimeout)
+	defer cancel()
+	v, err := s.route(ctx, r, path)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(auth, "Bearer ")
+	return s.Token != "" && token != auth && subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1
+}
+
+func (s *Server) route(ctx context.Context, r *http.Request, path []string) (interface{}, error) {
+	// route is the path with parameters replaced by their names, e.g. "GET users/{id}/profile".
+	route := make([]string, len(path))
+	copy(route, path)
+	var userID uint64
+	if path[0] == "users" && len(path) > 1 {
+		id, err := strconv.ParseUint(path[1], 10, 64)
+		if err != nil {
+			return nil, badRequest("invalid user id %q", path[1])
+		}
+		userID = id
+		route[1] = "{id}"
+		if len(path) == 4 && path[2] == "subscriptions" {
+			route[3] = "{topic}"
+		}
+	}
+	if path[0] == "topics" && len(path) == 3 {
+		route[1] = "{topic}"
+	}
+	switch r.Method + " " + strings.Join(route, "/") {
+	case "GET users":
+		return s.listUsers(ctx, r)
+	case "GET users/{id}":
+		return s.getUser(ctx, userID)
+	case "PATCH users/{id}":
+		return s.patchUser(ctx, r, userID)
+	case "GET users/{id}/profile":
+		return s.getProfile(ctx, userID)
+	case "PATCH users/{id}/profile":
+		return s.patchProfile(ctx, r, userID)
+	case "GET users/{id}/telegram_profile":
+		return s.getTelegramProfile(ctx, userID)
+	case "PATCH users/{id}/telegram_profile":
+		return s.patchTelegramProfile(ctx, r, userID)
+	case "GET users/{id}/subscriptions":
+		return s.getSubscriptions(ctx, userID)
+	case "PUT users/{id}/subscriptions/{topic}":
+		return s.putSubscription(ctx, r, userID, path[3])
+	case "GET topics/{topic}/subscribers":
+		return s.listSubscribers(ctx, r, path[1])
+	}
+	return nil, statusError{status: http.StatusNotFound, msg: "no such endpoint"}
+}
+
+// User is model.User without internal fields.
+type User struct {
+	ID          uint64     `json:"id"`
+	Role        string     `json:"role"`
+	State       string     `json:"state"`
+	BlockedAt   *time.Time `json:"blocked_at"`
+	BlockReason string     `json:"block_reason"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastAction  time.Time  `json:"last_action"`
+}
+
+func newUser(u *model.User) User {
+	return User{
+		ID:          u.UserID,
+		Role:        u.AccessRole().String(),
+		State:       u.State,
+		BlockedAt:   u.BlockedAt,
+		BlockReason: u.BlockReason,
+		CreatedAt:   u.CreatedAt,
+		LastAction:  u.LastAction,
+	}
+}
+
+type Profile struct {
+	Name          *string `json:"name"`
+	Phone         *string `json:"phone"`
+	PhoneSource   string  `json:"phone_source"`
+	Email         *string `json:"email"`
+	EmailVerified bool    `json:"email_verified"`
+	Source        string  `json:"source"`
+	InIT          *bool   `json:"in_it"`
+	ITRole        *string `json:"it_role"`
+}
+
+func newProfile(p *model.Profile) *Profile {
+	return &Profile{
+		Name:          p.Name,
+		Phone:         p.Phone,
+		PhoneSource:   p.PhoneSource,
+		Email:         p.Email,
+		EmailVerified: p.EmailVerified,
+		Source:        p.Source,
+		InIT:          p.InIT,
+		ITRole:        p.ITRole,
+	}
+}
+
+type TelegramProfile struct {
+	Username     string `json:"username"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	LanguageCode string `json:"language_code"`
+}
+
+func newTelegramProfile(p *model.TelegramProfile) *TelegramProfile {
+	return &TelegramProfile{
+		Username:     p.Username,
+		FirstName:    p.FirstName,
+		LastName:     p.LastName,
+		LanguageCode: p.LanguageCode,
+	}
+}
+
+type Subscription struct {
+	UserID     uint64    `json:"user_id"`
+	Topic      string    `json:"topic"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastAction time.Time `json:"last_action"`
+}
+
+func newSubscriptions(ss []*model.Subscription) []Subscription {
+	res := make([]Subscription, 0, len(ss))
+	for _, s := range ss {
+		res = append(res, Subscription{
+			UserID:     s.UserID,
+			Topic:      s.Topic,
+			Active:     s.Active,
+			CreatedAt:  s.CreatedAt,
+			LastAction: s.LastAction,
+		})
+	}
+	return res
+}
+
+// UserDetails is everything known about the user. Profiles are null until the user shares them.
+type UserDetails struct {
+	User
+	Profile         *Profile         `json:"profile"`
+	TelegramProfile *TelegramProfile `json:"telegram_profile"`
+	Subscriptions   []Subscription   `json:"subscriptions"`
+}
+
+// Page is a page of a list. Next is the cursor of the next page, it is empty on the last page.
+type Page[T any] struct {
+	Items []T    `json:"items"`
+	Next  string `json:"next"`
+}
+
+// pageParams parses cursor and limit query parameters. Cursors are ids of the last listed users.
+func pageParams(r *http.Request) (after uint64, limit int, err error) {
+	q := r.URL.Query()
+	limit = DefaultLimit
+	if v := q.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return 0, 0, badRequest("limit must be from 1 to %d", MaxLimit)
+		}
+	}
+	if v := q.Get("cursor"); v != "" {
+		after, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return 0, 0, badRequest("invalid cursor %q", v)
+		}
+	}
+	return after, limit, nil
+}
+
+// listUsers lists all users or, with the query parameter, users found by model.UserDirectory.
+// The query is @username, a phone number or a name fragment like in /find.
+func (s *Server) listUsers(ctx context.Context, r *http.Request) (interface{}, error) {
+	after, limit, err := pageParams(r)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint64
+	if text := strings.TrimSpace(r.URL.Query().Get("query")); text != "" {
+		q := model.UserQuery{Username: strings.TrimPrefix(text, "@"), After: after}
+		if number, err := phone.Normalize(text); err == nil {
+			q.Phone = number
+		}
+		if !strings.HasPrefix(text, "@") {
+			q.Name = text
+		}
+		ids, err = s.Storage.Directory.FindUsers(ctx, q, limit+1)
+	} else {
+		ids, err = s.Storage.Directory.ListUsers(ctx, after, limit+1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	page := Page[User]{Items: make([]User, 0, len(ids))}
+	if len(ids) > limit {
+		ids = ids[:limit]
+		page.Next = strconv.FormatUint(ids[limit-1], 10)
+	}
+	for _, id := range ids {
+		u, err := s.Storage.Users.Get(ctx, id)
+		if errors.Is(err, wrap.NotFoundError{}) {
+			// Found by a profile of the user who hasn't started the bot.
+			u, err = &model.User{UserID: id}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		page.Items = append(page.Items, newUser(u))
+	}
+	return page, nil
+}
+
+func (s *Server) getUser(ctx context.Context, userID uint64) (interface{}, error) {
+	u, err := s.Storage.Users.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	details := UserDetails{User: newUser(u)}
+	if p, err := s.Storage.Profiles.Get(ctx, userID); err == nil {
+		details.Profile = newProfile(p)
+	} else if !errors.Is(err, wrap.NotFoundError{}) {
+		return nil, err
+	}
+	if p, err := s.Storage.TelegramProfiles.Get(ctx, userID); err == nil {
+		details.TelegramProfile = newTelegramProfile(p)
+	} else if !errors.Is(err, wrap.NotFoundError{}) {
+		return nil, err
+	}
+	ss, err := s.Storage.Subscriptions.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	details.Subscriptions = newSubscriptions(ss)
+	return details, nil
+}
+
+// UserPatch changes the role or resets the dialog of the user with an empty state.
+type UserPatch struct {
+	Role  *string `json:"role"`
+	State *string `json:"state"`
+}
+
+func (s *Server) patchUser(ctx context.Context, r *http.Request, userID uint64) (interface{}, error) {
+	var patch UserPatch
+	if err := decode(r, &patch); err != nil {
+		return nil, err
+	}
+	var role model.Role
+	if patch.Role != nil {
+		var err error
+		if role, err = model.ParseRole(*patch.Role); err != nil {
+			return nil, badRequest("%s", err)
+		}
+	}
+	if patch.State != nil && *patch.State != "" {
+		return nil, badRequest("state can only be reset to empty")
+	}
+	u, err := s.Storage.Users.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if patch.Role != nil {
+		u.Role = uint8(role)
+	}
+	if patch.State != nil {
+		u.State = ""
+		u.ClearConversation()
+	}
+	if err := s.Storage.Users.Update(ctx, u); err != nil {
+		return nil, err
+	}
+	return newUser(u), nil
+}
+
+func (s *Server) getProfile(ctx context.Context, userID uint64) (interface{}, error) {
+	p, err := s.Storage.Profiles.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return newProfile(p), nil
+}
+
+// ProfilePatch changes the given fields, empty strings clear them.
+// A new email is not verified, a new phone is typed.
+type ProfilePatch struct {
+	Name   *string `json:"name"`
+	Phone  *string `json:"phone"`
+	Email  *string `json:"email"`
+	InIT   *bool   `json:"in_it"`
+	ITRole *string `json:"it_role"`
+}
+
+func (s *Server) patchProfile(ctx context.Context, r *http.Request, userID uint64) (interface{}, error) {
+	var patch ProfilePatch
+	if err := decode(r, &patch); err != nil {
+		return nil, err
+	}
+	if patch.Phone != nil && *patch.Phone != "" {
+		number, err := phone.Normalize(*patch.Phone)
+		if err != nil {
+			return nil, badRequest("%s", err)
+		}
+		patch.Phone = &number
+	}
+	if patch.Email != nil && *patch.Email != "" {
+		if _, err := mail.ParseAddress(*patch.Email); err != nil {
+			return nil, badRequest("invalid email: %s", err)
+		}
+	}
+	if patch.ITRole != nil && *patch.ITRole != "" && !validITRole(*patch.ITRole) {
+		return nil, badRequest("unknown it_role %q", *patch.ITRole)
+	}
+	if _, err := s.Storage.Users.Get(ctx, userID); err != nil {
+		return nil, err
+	}
+	p, err := s.Storage.Profiles.Get(ctx, userID)
+	if errors.Is(err, wrap.NotFoundError{}) {
+		p, err = &model.Profile{UserID: userID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if patch.Name != nil {
+		p.Name = optional(*patch.Name)
+	}
+	if patch.Phone != nil && !equal(p.Phone, *patch.Phone) {
+		p.Phone = optional(*patch.Phone)
+		p.PhoneSource = ""
+		if p.Phone != nil {
+			p.PhoneSource = model.PhoneTyped
+		}
+	}
+	if patch.Email != nil && !equal(p.Email, *patch.Email) {
+		p.Email = optional(*patch.Email)
+		p.EmailVerified = false
+	}
+	if patch.InIT != nil {
+		p.InIT = patch.InIT
+	}
+	if patch.ITRole != nil {
+		p.ITRole = optional(*patch.ITRole)
+	}
+	if err := s.Storage.Profiles.Upsert(ctx, p); err != nil {
+		return nil, err
+	}
+	return newProfile(p), nil
+}
+
+func (s *Server) getTelegramProfile(ctx context.Context, userID uint64) (interface{}, error) {
+	p, err := s.Storage.TelegramProfiles.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return newTelegramProfile(p), nil
+}
+
+// TelegramProfilePatch changes the given fields. The bot overwrites them when the user shares the contact again.
+type TelegramProfilePatch struct {
+	Username     *string `json:"username"`
+	FirstName    *string `json:"first_name"`
+	LastName     *string `json:"last_name"`
+	LanguageCode *string `json:"language_code"`
+}
+
+func (s *Server) patchTelegramProfile(ctx context.Context, r *http.Request, userID uint64) (interface{}, error) {
+	var patch TelegramProfilePatch
+	if err := decode(r, &patch); err != nil {
+		return nil, err
+	}
+	p, err := s.Storage.TelegramProfiles.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range []struct {
+		field *string
+		value *string
+	}{
+		{&p.Username, patch.Username},
+		{&p.FirstName, patch.FirstName},
+		{&p.LastName, patch.LastName},
+		{&p.LanguageCode, patch.LanguageCode},
+	} {
+		if f.value != nil {
+			*f.field = *f.value
+		}
+	}
+	if err := s.Storage.TelegramProfiles.Upsert(ctx, p); err != nil {
+		return nil, err
+	}
+	return newTelegramProfile(p), nil
+}
+
+func (s *Server) getSubscriptions(ctx context.Context, userID uint64) (interface{}, error) {
+	if _, err := s.Storage.Users.Get(ctx, userID); err != nil {
+		return nil, err
+	}
+	ss, err := s.Storage.Subscriptions.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return newSubscriptions(ss), nil
+}
+
+type SubscriptionPatch struct {
+	Active bool `json:"active"`
+}
+
+// putSubscription turns the subscription on or off. Only existing topics can be turned on.
+func (s *Server) putSubscription(ctx context.Context, r *http.Request, userID uint64, topic string) (interface{}, error) {
+	var patch SubscriptionPatch
+	if err := decode(r, &patch); err != nil {
+		return nil, err
+	}
+	if _, err := s.Storage.Users.Get(ctx, userID); err != nil {
+		return nil, err
+	}
+	var sub *model.Subscription
+	err := s.Storage.Tx.InTx(ctx, func(ctx context.Context) error {
+		var err error
+		sub, err = s.Storage.Subscriptions.Get(ctx, userID, topic)
+		if errors.Is(err, wrap.NotFoundError{}) {
+			sub, err = &model.Subscription{UserID: userID, Topic: topic}, nil
+		}
+		if err != nil {
+			return err
+		}
+		sub.Active = patch.Active
+		return s.Storage.Subscriptions.Upsert(ctx, sub)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newSubscriptions([]*model.Subscription{sub})[0], nil
+}
+
+// listSubscribers pages through active subscribers of the topic.
+func (s *Server) listSubscribers(ctx context.Context, r *http.Request, topic string) (interface{}, error) {
+	after, limit, err := pageParams(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.Storage.Topics.Get(ctx, topic); err != nil {
+		return nil, err
+	}
+	cursor := ""
+	if after != 0 {
+		cursor = strconv.FormatUint(after, 10)
+	}
+	ss, next, err := s.Storage.Subscriptions.ListActiveByTopic(ctx, topic, cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+	return Page[Subscription]{Items: newSubscriptions(ss), Next: next}, nil
+}
+
+// decode reads the JSON body rejecting unknown fields, so that typos are not silently ignored.
+func decode(r *http.Request, v interface{}) error {
+	d := json.NewDecoder(io.LimitReader(r.Body, maxBody))
+	d.DisallowUnknownFields()
+	if err := d.Decode(v); err != nil {
+		return badRequest("invalid body: %s", err)
+	}
+	return nil
+}
+
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func equal(p *string, s string) bool {
+	if p == nil {
+		return s == ""
+	}
+	return *p == s
+}
+
+func validITRole(role string) bool {
+	for _, r := range model.ITRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Print("can't write API response: ", err)
+	}
+}
+
+// writeError maps errors of the model package to HTTP statuses. Unexpected errors are logged and hidden.
+func writeError(w http.ResponseWriter, err error) {
+	var se statusError
+	switch {
+	case errors.As(err, &se):
+	case errors.Is(err, wrap.NotFoundError{}):
+		se = statusError{status: http.StatusNotFound, msg: "not found"}
+	case errors.Is(err, wrap.ConflictError{}):
+		se = statusError{status: http.StatusConflict, msg: "changed concurrently, retry"}
+	case errors.Is(err, wrap.UnknownReferenceError{}):
+		se = statusError{status: http.StatusUnprocessableEntity, msg: "unknown or archived topic"}
+	default:
+		log.Print("API error: ", err)
+		se = statusError{status: http.StatusInternalServerError, msg: "internal error"}
+	}
+	writeJSON(w, se.status, map[string]string{"error": se.msg})
+}
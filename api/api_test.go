@@ -0,0 +1,176 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/failoverbar/bot/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const token = "secret"
+
+func newTestServer(t *testing.T) (*httptest.Server, model.Storage) {
+	ctx := context.Background()
+	s := model.NewMemoryStorage()
+	if err := s.Topics.Upsert(ctx, &model.Topic{Slug: "events", Title: "События"}); err != nil {
+		t.Fatal(err)
+	}
+	for id := uint64(1); id <= 3; id++ {
+		if err := s.Users.Insert(ctx, &model.User{UserID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.TelegramProfiles.Insert(ctx, &model.TelegramProfile{UserID: 2, Username: "vasya", FirstName: "Вася"}); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(&Server{Storage: s, Token: token})
+	t.Cleanup(srv.Close)
+	return srv, s
+}
+
+// do sends the request and decodes the response into res unless it is nil.
+func do(t *testing.T, srv *httptest.Server, method, path, body string, res interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if res != nil {
+		if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAuth(t *testing.T) {
+	srv, _ := newTestServer(t)
+	for _, header := range []string{"", "Bearer wrong", token} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/users", nil)
+		req.Header.Set("Authorization", header)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%q: got %d", header, resp.StatusCode)
+		}
+	}
+	resp, err := http.Get(srv.URL + "/api/openapi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Error("OpenAPI description is not served", resp.StatusCode)
+	}
+	for _, path := range []string{"/users", "/apiusers", "/openapi.yaml"} {
+		if code := do(t, srv, http.MethodGet, path, "", nil); code != http.StatusNotFound {
+			t.Errorf("%s: got %d", path, code)
+		}
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	srv, _ := newTestServer(t)
+	var ids []uint64
+	path := "/api/users?limit=2"
+	for i := 0; i < 3; i++ {
+		var page Page[User]
+		if code := do(t, srv, http.MethodGet, path, "", &page); code != http.StatusOK {
+			t.Fatal(code)
+		}
+		for _, u := range page.Items {
+			ids = append(ids, u.ID)
+		}
+		if page.Next == "" {
+			break
+		}
+		path = "/api/users?limit=2&cursor=" + page.Next
+	}
+	if len(ids) != 3 || ids[0] != 1 || ids[2] != 3 {
+		t.Error("wrong users", ids)
+	}
+
+	var found Page[User]
+	do(t, srv, http.MethodGet, "/api/users?query=@vasya", "", &found)
+	if len(found.Items) != 1 || found.Items[0].ID != 2 || found.Items[0].Role != "guest" {
+		t.Errorf("wrong found users %+v", found)
+	}
+	if code := do(t, srv, http.MethodGet, "/api/users?limit=0", "", nil); code != http.StatusBadRequest {
+		t.Error("wrong limit is accepted", code)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	srv, s := newTestServer(t)
+	var p Profile
+	code := do(t, srv, http.MethodPatch, "/api/users/1/profile", `{"name":"Петя","phone":"8 916 123-45-67"}`, &p)
+	if code != http.StatusOK || *p.Name != "Петя" || *p.Phone != "+79161234567" || p.PhoneSource != model.PhoneTyped {
+		t.Errorf("wrong profile %d %+v", code, p)
+	}
+	if code := do(t, srv, http.MethodPatch, "/api/users/1/profile", `{"email":"nope"}`, nil); code != http.StatusBadRequest {
+		t.Error("invalid email is accepted", code)
+	}
+	if code := do(t, srv, http.MethodPatch, "/api/users/1/profile", `{"it_role":"wizard"}`, nil); code != http.StatusBadRequest {
+		t.Error("unknown it_role is accepted", code)
+	}
+	if code := do(t, srv, http.MethodPatch, "/api/users/1/profile", `{"it_role":"qa"}`, &p); code != http.StatusOK || *p.ITRole != "qa" {
+		t.Errorf("wrong profile %d %+v", code, p)
+	}
+	if code := do(t, srv, http.MethodPatch, "/api/users/1/profile", `{"nmae":"typo"}`, nil); code != http.StatusBadRequest {
+		t.Error("unknown field is accepted", code)
+	}
+	if code := do(t, srv, http.MethodPatch, "/api/users/9/profile", `{"name":"Никто"}`, nil); code != http.StatusNotFound {
+		t.Error("profile of unknown user is created", code)
+	}
+
+	var u User
+	if code := do(t, srv, http.MethodPatch, "/api/users/1", `{"role":"staff"}`, &u); code != http.StatusOK || u.Role != "staff" {
+		t.Errorf("wrong user %d %+v", code, u)
+	}
+	stored, err := s.Users.Get(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.AccessRole() != model.RoleStaff {
+		t.Error("role is not stored", stored.Role)
+	}
+
+	var details UserDetails
+	do(t, srv, http.MethodGet, "/api/users/1", "", &details)
+	if details.Profile == nil || details.TelegramProfile != nil || details.Role != "staff" {
+		t.Errorf("wrong details %+v", details)
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	srv, _ := newTestServer(t)
+	var sub Subscription
+	code := do(t, srv, http.MethodPut, "/api/users/2/subscriptions/events", `{"active":true}`, &sub)
+	if code != http.StatusOK || !sub.Active || sub.Topic != "events" {
+		t.Errorf("wrong subscription %d %+v", code, sub)
+	}
+	if code := do(t, srv, http.MethodPut, "/api/users/2/subscriptions/nope", `{"active":true}`, nil); code != http.StatusUnprocessableEntity {
+		t.Error("unknown topic is accepted", code)
+	}
+
+	var page Page[Subscription]
+	do(t, srv, http.MethodGet, "/api/topics/events/subscribers", "", &page)
+	if len(page.Items) != 1 || page.Items[0].UserID != 2 || page.Next != "" {
+		t.Errorf("wrong subscribers %+v", page)
+	}
+	if code := do(t, srv, http.MethodGet, "/api/topics/nope/subscribers", "", nil); code != http.StatusNotFound {
+		t.Error("unknown topic is listed", code)
+	}
+}
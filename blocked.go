@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/failoverbar/bot/delivery"
+	"github.com/failoverbar/bot/model"
+	"github.com/failoverbar/bot/wrap"
+	"log"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// sendTo sends a message the user didn't ask for, e.g. a broadcast or a notification.
+// Users who blocked the bot are flagged and their subscriptions are paused.
+func (h *handler) sendTo(ctx context.Context, userID uint64, what interface{}, opts ...interface{}) error {
+	_, err := h.bot.Send(&tele.User{ID: int64(userID)}, what, opts...)
+	if err == nil {
+		return nil
+	}
+	if reason := delivery.Classify(err); reason.Unreachable() {
+		if err := h.markUnreachable(ctx, userID, reason); err != nil {
+			log.Printf("can't flag unreachable user %d: %s", userID, err)
+		}
+	}
+	return err
+}
+
+// markUnreachable flags the user as blocked and pauses subscriptions, so that nothing is sent to the user until /start.
+func (h *handler) markUnreachable(ctx context.Context, userID uint64, reason delivery.Reason) error {
+	_, err := h.updateUser(ctx, userID, func(user *model.User) {
+		if !user.Blocked() {
+			now := time.Now()
+			user.BlockedAt = &now
+		}
+		user.BlockReason = string(reason)
+	})
+	if errors.Is(err, wrap.NotFoundError{}) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("user %d is unreachable: %s", userID, reason)
+	ss, err := h.subscriptionsRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, s := range ss {
+		if !s.Active {
+			continue
+		}
+		s.Active = false
+		if err := h.subscriptionsRepo.Upsert(ctx, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unblock clears the flag of the user who came back. Paused subscriptions are left for the user to turn on.
+func (h *handler) unblock(ctx context.Context, c tele.Context, user *model.User) error {
+	if !user.Blocked() {
+		return nil
+	}
+	_, err := h.updateUser(ctx, user.UserID, func(user *model.User) {
+		user.BlockedAt = nil
+		user.BlockReason = ""
+	})
+	if err != nil {
+		return err
+	}
+	return c.Send("С возвращением! Пока тебя не было, я приостановил подписки. Включить их можно в /subscriptions.")
+}
@@ -0,0 +1,269 @@
+// Package broadcast sends a message to active subscribers of a topic within Telegram limits.
+//
+// Broadcasts are stored with their progress, so sending continues after a restart
+// from the first subscriber who was not handled yet:
+//
+//	e := &broadcast.Engine{Broadcasts: s.Broadcasts, Subscriptions: s.Subscriptions, Send: send, Done: report}
+//	go e.Run(ctx)
+//	err := e.Start(ctx, &model.Broadcast{Topic: "events", Text: "Привет!"})
+package broadcast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/failoverbar/bot/model"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of Engine limits. Telegram allows about 30 messages per second to different chats
+// and one message per second to the same chat.
+const (
+	DefaultRate         = 25
+	DefaultChatInterval = time.Second
+	DefaultPageSize     = 100
+	// retryInterval is a pause before retrying broadcasts which failed for reasons other than delivery.
+	retryInterval = time.Minute
+	// sendAttempts limits retries of a message Telegram asked to send later.
+	sendAttempts = 3
+)
+
+// RetryError is returned by Engine.Send when Telegram asks to repeat the request after a pause.
+type RetryError struct {
+	After time.Duration
+}
+
+func (e RetryError) Error() string {
+	return fmt.Sprintf("retry after %s", e.After)
+}
+
+// Engine sends broadcasts one by one.
+type Engine struct {
+	Broadcasts    model.BroadcastStorage
+	Subscriptions model.SubscriptionStorage
+	// Send delivers the broadcast to the user.
+	Send func(ctx context.Context, userID uint64, b *model.Broadcast) error
+	// Done is called when the broadcast is sent to everyone, it may be nil.
+	Done func(ctx context.Context, b *model.Broadcast)
+
+	// Rate limits messages per second, DefaultRate is used if it is zero.
+	Rate int
+	// ChatInterval is the minimal interval between messages to the same user, DefaultChatInterval is used if it is zero.
+	ChatInterval time.Duration
+	// PageSize is the number of subscriptions fetched at once, DefaultPageSize is used if it is zero.
+	PageSize int
+
+	once     sync.Once
+	wake     chan struct{}
+	limiter  *limiter
+	lastSent map[uint64]time.Time
+}
+
+func (e *Engine) init() {
+	e.once.Do(func() {
+		e.wake = make(chan struct{}, 1)
+		rate := e.Rate
+		if rate <= 0 {
+			rate = DefaultRate
+		}
+		e.limiter = &limiter{interval: time.Second / time.Duration(rate)}
+		e.lastSent = map[uint64]time.Time{}
+	})
+}
+
+// Start stores the broadcast, it is sent by Run.
+func (e *Engine) Start(ctx context.Context, b *model.Broadcast) error {
+	e.init()
+	b.Status = model.BroadcastSending
+	b.Cursor, b.Delivered, b.Failed = "", 0, 0
+	if err := e.Broadcasts.Insert(ctx, b); err != nil {
+		return err
+	}
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run sends stored broadcasts until ctx is done, including ones interrupted by a restart.
+func (e *Engine) Run(ctx context.Context) error {
+	e.init()
+	for {
+		bs, err := e.Broadcasts.ListByStatus(ctx, model.BroadcastSending)
+		if err != nil {
+			log.Printf("can't list broadcasts: %s", err)
+		}
+		for _, b := range bs {
+			if err := e.send(ctx, b); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Printf("broadcast %d is interrupted: %s", b.ID, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-e.wake:
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// send continues the broadcast after the last handled subscriber. Progress is stored after every message,
+// so at most one message is repeated if the process stops.
+func (e *Engine) send(ctx context.Context, b *model.Broadcast) error {
+	pageSize := e.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	for {
+		ss, next, err := e.Subscriptions.ListActiveByTopic(ctx, b.Topic, b.Cursor, pageSize)
+		if err != nil {
+			return err
+		}
+		for _, s := range ss {
+			if err := e.deliver(ctx, s.UserID, b); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Printf("can't send broadcast %d to %d: %s", b.ID, s.UserID, err)
+				b.Failed++
+			} else {
+				b.Delivered++
+			}
+			b.Cursor = strconv.FormatUint(s.UserID, 10)
+			if err := e.Broadcasts.Upsert(ctx, b); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			break
+		}
+	}
+	b.Status = model.BroadcastDone
+	if err := e.Broadcasts.Upsert(ctx, b); err != nil {
+		return err
+	}
+	log.Printf("broadcast %d is sent: %d delivered, %d failed", b.ID, b.Delivered, b.Failed)
+	if e.Done != nil {
+		e.Done(ctx, b)
+	}
+	return nil
+}
+
+// deliver sends the message within limits and retries it when Telegram asks to.
+func (e *Engine) deliver(ctx context.Context, userID uint64, b *model.Broadcast) error {
+	var err error
+	for i := 0; i < sendAttempts; i++ {
+		if err = e.wait(ctx, userID); err != nil {
+			return err
+		}
+		err = e.Send(ctx, userID, b)
+		var retry RetryError
+		if !errors.As(err, &retry) {
+			return err
+		}
+		e.limiter.pause(retry.After)
+	}
+	return err
+}
+
+// wait blocks until the message to the user fits both the global and the per-chat limits.
+func (e *Engine) wait(ctx context.Context, userID uint64) error {
+	interval := e.ChatInterval
+	if interval <= 0 {
+		interval = DefaultChatInterval
+	}
+	if last, ok := e.lastSent[userID]; ok {
+		if err := sleep(ctx, time.Until(last.Add(interval))); err != nil {
+			return err
+		}
+	}
+	if err := e.limiter.wait(ctx); err != nil {
+		return err
+	}
+	now := time.Now()
+	e.lastSent[userID] = now
+	if len(e.lastSent) > 10*DefaultPageSize {
+		for id, t := range e.lastSent {
+			if now.Sub(t) > interval {
+				delete(e.lastSent, id)
+			}
+		}
+	}
+	return nil
+}
+
+// limiter spaces events by interval.
+type limiter struct {
+	interval time.Duration
+	next     time.Time
+}
+
+func (l *limiter) wait(ctx context.Context) error {
+	now := time.Now()
+	if err := sleep(ctx, l.next.Sub(now)); err != nil {
+		return err
+	}
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(l.interval)
+	return nil
+}
+
+// pause postpones the next event by d.
+func (l *limiter) pause(d time.Duration) {
+	if next := time.Now().Add(d); next.After(l.next) {
+		l.next = next
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Button is a link under the broadcast message.
+type Button struct {
+	Text string
+	URL  string
+}
+
+// ParseButtons parses lines "text | url" of model.Broadcast.Buttons.
+func ParseButtons(s string) ([]Button, error) {
+	var bs []Button
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		text, link, ok := strings.Cut(line, "|")
+		text, link = strings.TrimSpace(text), strings.TrimSpace(link)
+		if !ok || text == "" {
+			return nil, fmt.Errorf("button %q: expected \"text | url\"", line)
+		}
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("button %q: bad url %q", line, link)
+		}
+		bs = append(bs, Button{Text: text, URL: link})
+	}
+	return bs, nil
+}
@@ -0,0 +1,164 @@
+package broadcast
+
+import (
+	"context"
+	"errors"
+	"github.com/failoverbar/bot/model"
+	"sync"
+	"testing"
+	"time"
+)
+
+const topic = "events"
+
+// fixture is a memory storage with subscribers 1..n of topic, 3 of them paused.
+func fixture(t *testing.T, n uint64) model.Storage {
+	ctx := context.Background()
+	s := model.NewMemoryStorage()
+	if err := s.Topics.Insert(ctx, &model.Topic{Slug: topic, Title: "Events"}); err != nil {
+		t.Fatal(err)
+	}
+	for id := uint64(1); id <= n; id++ {
+		if err := s.Subscriptions.Upsert(ctx, &model.Subscription{UserID: id, Topic: topic, Active: id != 3}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+type recorder struct {
+	mu   sync.Mutex
+	sent []uint64
+	// fail returns an error for the attempt to send to the user.
+	fail func(userID uint64, attempt int) error
+	done chan *model.Broadcast
+}
+
+func (r *recorder) send(_ context.Context, userID uint64, _ *model.Broadcast) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	attempt := 0
+	for _, id := range r.sent {
+		if id == userID {
+			attempt++
+		}
+	}
+	r.sent = append(r.sent, userID)
+	if r.fail != nil {
+		return r.fail(userID, attempt)
+	}
+	return nil
+}
+
+func (r *recorder) run(t *testing.T, s model.Storage, start *model.Broadcast) *model.Broadcast {
+	r.done = make(chan *model.Broadcast, 1)
+	e := &Engine{
+		Broadcasts:    s.Broadcasts,
+		Subscriptions: s.Subscriptions,
+		Send:          r.send,
+		Done: func(_ context.Context, b *model.Broadcast) {
+			r.done <- b
+		},
+		Rate:         1000,
+		ChatInterval: time.Millisecond,
+		PageSize:     2,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if start != nil {
+		if err := e.Start(ctx, start); err != nil {
+			t.Fatal(err)
+		}
+	}
+	go func() {
+		_ = e.Run(ctx)
+	}()
+	select {
+	case b := <-r.done:
+		return b
+	case <-ctx.Done():
+		t.Fatal("broadcast is not done")
+		return nil
+	}
+}
+
+func TestEngine(t *testing.T) {
+	s := fixture(t, 5)
+	r := &recorder{fail: func(userID uint64, _ int) error {
+		if userID == 4 {
+			return errors.New("blocked")
+		}
+		return nil
+	}}
+	b := r.run(t, s, &model.Broadcast{Topic: topic, Text: "hello"})
+	if b.Delivered != 3 || b.Failed != 1 {
+		t.Errorf("got %d delivered and %d failed", b.Delivered, b.Failed)
+	}
+	if len(r.sent) != 4 {
+		t.Error("wrong recipients", r.sent)
+	}
+	stored, err := s.Broadcasts.Get(context.Background(), b.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != model.BroadcastDone || stored.Delivered != 3 {
+		t.Error("progress is not stored", stored)
+	}
+}
+
+func TestEngineResume(t *testing.T) {
+	s := fixture(t, 5)
+	ctx := context.Background()
+	// The process stopped after user 4.
+	b := &model.Broadcast{Topic: topic, Status: model.BroadcastSending, Cursor: "4", Delivered: 3}
+	if err := s.Broadcasts.Insert(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+	// Users who got the message may leave before the restart, e.g. block the bot,
+	// which shifts the rest of the page.
+	for _, id := range []uint64{2, 4} {
+		if err := s.Subscriptions.Upsert(ctx, &model.Subscription{UserID: id, Topic: topic, Active: false}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := &recorder{}
+	done := r.run(t, s, nil)
+	if len(r.sent) != 1 || r.sent[0] != 5 {
+		t.Error("wrong recipients after restart", r.sent)
+	}
+	if done.ID != b.ID || done.Delivered != 4 {
+		t.Error("wrong progress", done)
+	}
+}
+
+func TestEngineRetry(t *testing.T) {
+	s := fixture(t, 2)
+	r := &recorder{fail: func(userID uint64, attempt int) error {
+		if attempt == 0 {
+			return RetryError{After: 10 * time.Millisecond}
+		}
+		return nil
+	}}
+	b := r.run(t, s, &model.Broadcast{Topic: topic, Text: "hello"})
+	if b.Delivered != 2 || b.Failed != 0 {
+		t.Errorf("got %d delivered and %d failed", b.Delivered, b.Failed)
+	}
+	if len(r.sent) != 4 {
+		t.Error("not retried", r.sent)
+	}
+}
+
+func TestParseButtons(t *testing.T) {
+	bs, err := ParseButtons("Регистрация | https://example.com/reg\n\n Сайт|http://example.com ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bs) != 2 || bs[0] != (Button{"Регистрация", "https://example.com/reg"}) || bs[1].Text != "Сайт" {
+		t.Error("wrong buttons", bs)
+	}
+	for _, bad := range []string{"no url", "| https://example.com", "Сайт | example.com", "Сайт | javascript:alert(1)"} {
+		if _, err := ParseButtons(bad); err == nil {
+			t.Error("accepted", bad)
+		}
+	}
+}
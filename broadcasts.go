@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/failoverbar/bot/broadcast"
+	"github.com/failoverbar/bot/delivery"
+	"github.com/failoverbar/bot/fsm"
+	"github.com/failoverbar/bot/model"
+	"github.com/failoverbar/bot/wrap"
+	"log"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// States of composing a broadcast.
+const (
+	stateBroadcastMessage = "broadcast.message"
+	stateBroadcastButtons = "broadcast.buttons"
+	stateBroadcastConfirm = "broadcast.confirm"
+)
+
+const (
+	broadcastButton = "broadcast"
+	// broadcastDraftKey keeps model.Broadcast being composed in the conversation.
+	broadcastDraftKey = "broadcast"
+)
+
+func (h *handler) broadcastStates() []fsm.State {
+	return []fsm.State{
+		{
+			Name:       stateBroadcastMessage,
+			Enter:      h.askBroadcastMessage,
+			OnText:     h.adminOnly(h.onBroadcastMessage),
+			OnCallback: h.adminOnly(h.onBroadcastButton),
+			Next:       []string{stateBroadcastButtons, fsm.Idle},
+		},
+		{
+			Name:       stateBroadcastButtons,
+			Enter:      h.askBroadcastButtons,
+			OnText:     h.adminOnly(h.onBroadcastButtons),
+			OnCallback: h.adminOnly(h.onBroadcastButton),
+			Next:       []string{stateBroadcastConfirm, fsm.Idle},
+		},
+		{
+			Name:       stateBroadcastConfirm,
+			Enter:      h.askBroadcastConfirm,
+			OnCallback: h.adminOnly(h.onBroadcastButton),
+			Fallback:   h.askBroadcastConfirm,
+			Next:       []string{fsm.Idle},
+		},
+	}
+}
+
+// adminOnly guards steps of the dialog from users who lost the role in the middle of it.
+func (h *handler) adminOnly(next fsm.HandlerFunc) fsm.HandlerFunc {
+	return requireRoleState(h.userRepo, model.RoleAdmin, next)
+}
+
+// newBroadcastEngine sends broadcasts through the bot and reports results to their authors.
+func (h *handler) newBroadcastEngine() *broadcast.Engine {
+	return &broadcast.Engine{
+		Broadcasts:    h.storage.Broadcasts,
+		Subscriptions: h.subscriptionsRepo,
+		Send:          h.sendBroadcast,
+		Done:          h.reportBroadcast,
+	}
+}
+
+// onBroadcast starts composing a broadcast to subscribers of the topic given as the argument.
+func (h *handler) onBroadcast(ctx context.Context, c tele.Context) error {
+	slug := strings.TrimSpace(c.Message().Payload)
+	if slug == "" {
+		return c.Send("Формат: /broadcast slug, список тем: /topics")
+	}
+	topic, err := h.topicRepo.Get(ctx, slug)
+	if errors.Is(err, wrap.NotFoundError{}) {
+		return c.Send("Темы " + slug + " нет, список тем: /topics")
+	}
+	if err != nil {
+		return err
+	}
+	conv, err := h.conversation(ctx, c)
+	if err != nil {
+		return err
+	}
+	err = model.SetConversationValue(conv, broadcastDraftKey, model.Broadcast{
+		AuthorID: uint64(c.Sender().ID),
+		Topic:    topic.Slug,
+	})
+	if err != nil {
+		return err
+	}
+	err = h.fsm.Transition(ctx, c, stateBroadcastMessage)
+	if errors.Is(err, fsm.ErrNoState) {
+		return c.Send("Сначала давай познакомимся: отправь /start.")
+	}
+	if errors.Is(err, fsm.ErrTransition) {
+		return c.Send("Давай сначала закончим текущий разговор.")
+	}
+	return err
+}
+
+func (h *handler) askBroadcastMessage(_ context.Context, c tele.Context) error {
+	return c.Send("Пришли текст рассылки или фото с подписью.", h.broadcastCancelMarkup())
+}
+
+func (h *handler) onBroadcastMessage(ctx context.Context, c tele.Context) error {
+	msg := c.Message()
+	photoID := ""
+	if msg.Photo != nil {
+		photoID = msg.Photo.FileID
+	}
+	if photoID == "" && strings.TrimSpace(c.Text()) == "" {
+		return h.askBroadcastMessage(ctx, c)
+	}
+	return h.updateBroadcastDraft(ctx, c, stateBroadcastButtons, func(b *model.Broadcast) {
+		b.Text = c.Text()
+		b.PhotoID = photoID
+	})
+}
+
+func (h *handler) askBroadcastButtons(_ context.Context, c tele.Context) error {
+	m := h.bot.NewMarkup()
+	m.Inline(m.Row(m.Data("Без кнопок", broadcastButton, "no_buttons"), m.Data("Отмена", broadcastButton, "cancel")))
+	return c.Send("Пришли кнопки-ссылки, каждую с новой строки в виде «Текст | https://…», или нажми «Без кнопок».", m)
+}
+
+func (h *handler) onBroadcastButtons(ctx context.Context, c tele.Context) error {
+	if _, err := broadcast.ParseButtons(c.Text()); err != nil {
+		return c.Send("Не получилось разобрать кнопки: " + err.Error())
+	}
+	return h.updateBroadcastDraft(ctx, c, stateBroadcastConfirm, func(b *model.Broadcast) {
+		b.Buttons = c.Text()
+	})
+}
+
+// askBroadcastConfirm shows the broadcast as subscribers will see it.
+func (h *handler) askBroadcastConfirm(ctx context.Context, c tele.Context) error {
+	b, err := h.broadcastDraft(ctx, c)
+	if err != nil {
+		return err
+	}
+	what, opts, err := broadcastMessage(&b)
+	if err != nil {
+		return err
+	}
+	if err := c.Send(what, opts...); err != nil {
+		return err
+	}
+	count, err := h.subscriptionsRepo.CountActive(ctx, b.Topic)
+	if err != nil {
+		return err
+	}
+	m := h.bot.NewMarkup()
+	m.Inline(m.Row(m.Data("Отправить", broadcastButton, "send"), m.Data("Отмена", broadcastButton, "cancel")))
+	return c.Send(fmt.Sprintf("Отправить это сообщение подписчикам темы %s (%d)?", b.Topic, count), m)
+}
+
+func (h *handler) onBroadcastButton(ctx context.Context, c tele.Context) error {
+	unique, action := fsm.CallbackData(c)
+	if unique != broadcastButton {
+		return nil
+	}
+	switch action {
+	case "no_buttons":
+		return h.updateBroadcastDraft(ctx, c, stateBroadcastConfirm, func(b *model.Broadcast) {
+			b.Buttons = ""
+		})
+	case "send":
+		b, ok, err := h.takeBroadcastDraft(ctx, uint64(c.Sender().ID))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return c.Respond(&tele.CallbackResponse{Text: "Рассылка уже отправлена"})
+		}
+		if err := h.broadcasts.Start(ctx, &b); err != nil {
+			return err
+		}
+		return c.Edit(fmt.Sprintf("Рассылка #%d началась, пришлю отчёт, когда она закончится.", b.ID))
+	case "cancel":
+		if err := h.fsm.Transition(ctx, c, fsm.Idle); err != nil {
+			return err
+		}
+		return c.Edit("Рассылка отменена.")
+	}
+	return nil
+}
+
+func (h *handler) broadcastCancelMarkup() *tele.ReplyMarkup {
+	m := h.bot.NewMarkup()
+	m.Inline(m.Row(m.Data("Отмена", broadcastButton, "cancel")))
+	return m
+}
+
+func (h *handler) broadcastDraft(ctx context.Context, c tele.Context) (model.Broadcast, error) {
+	conv, err := h.conversation(ctx, c)
+	if err != nil {
+		return model.Broadcast{}, err
+	}
+	b, _, err := model.ConversationValue[model.Broadcast](conv, broadcastDraftKey)
+	return b, err
+}
+
+// takeBroadcastDraft moves the user from the confirmation to fsm.Idle and returns the draft.
+// The state is checked along with the change, so ok is false for the second of two taps on "send".
+func (h *handler) takeBroadcastDraft(ctx context.Context, userID uint64) (b model.Broadcast, ok bool, err error) {
+	var decodeErr error
+	_, err = h.updateUser(ctx, userID, func(user *model.User) {
+		if ok = user.State == stateBroadcastConfirm; !ok {
+			return
+		}
+		conv, err := user.Conversation()
+		if err == nil {
+			b, _, err = model.ConversationValue[model.Broadcast](conv, broadcastDraftKey)
+		}
+		if decodeErr = err; err != nil {
+			return
+		}
+		user.State = fsm.Idle
+		user.ClearConversation()
+	})
+	if err == nil {
+		err = decodeErr
+	}
+	if err != nil {
+		return model.Broadcast{}, false, err
+	}
+	return b, ok, nil
+}
+
+// updateBroadcastDraft applies change to the draft and moves to the next step.
+func (h *handler) updateBroadcastDraft(ctx context.Context, c tele.Context, next string, change func(b *model.Broadcast)) error {
+	b, err := h.broadcastDraft(ctx, c)
+	if err != nil {
+		return err
+	}
+	change(&b)
+	conv, err := h.conversation(ctx, c)
+	if err != nil {
+		return err
+	}
+	if err = model.SetConversationValue(conv, broadcastDraftKey, b); err != nil {
+		return err
+	}
+	return h.fsm.Transition(ctx, c, next)
+}
+
+// broadcastMessage returns arguments of tele.Bot.Send for the broadcast.
+func broadcastMessage(b *model.Broadcast) (interface{}, []interface{}, error) {
+	buttons, err := broadcast.ParseButtons(b.Buttons)
+	if err != nil {
+		return nil, nil, err
+	}
+	var opts []interface{}
+	if len(buttons) > 0 {
+		m := &tele.ReplyMarkup{}
+		rows := make([]tele.Row, 0, len(buttons))
+		for _, btn := range buttons {
+			rows = append(rows, m.Row(m.URL(btn.Text, btn.URL)))
+		}
+		m.Inline(rows...)
+		opts = append(opts, m)
+	}
+	if b.PhotoID != "" {
+		return &tele.Photo{File: tele.File{FileID: b.PhotoID}, Caption: b.Text}, opts, nil
+	}
+	return b.Text, opts, nil
+}
+
+func (h *handler) sendBroadcast(ctx context.Context, userID uint64, b *model.Broadcast) error {
+	what, opts, err := broadcastMessage(b)
+	if err != nil {
+		return err
+	}
+	err = h.sendTo(ctx, userID, what, opts...)
+	if after, ok := delivery.RetryAfter(err); ok {
+		return broadcast.RetryError{After: after}
+	}
+	return err
+}
+
+func (h *handler) reportBroadcast(ctx context.Context, b *model.Broadcast) {
+	text := fmt.Sprintf("Рассылка #%d по теме %s закончилась: доставлено %d, не доставлено %d.", b.ID, b.Topic, b.Delivered, b.Failed)
+	if err := h.sendTo(ctx, b.AuthorID, text); err != nil {
+		log.Printf("can't report broadcast %d to %d: %s", b.ID, b.AuthorID, err)
+	}
+}
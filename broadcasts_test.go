@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/failoverbar/bot/broadcast"
+	"github.com/failoverbar/bot/fsm"
+	"github.com/failoverbar/bot/model"
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestBroadcastSendTwice(t *testing.T) {
+	ctx := context.Background()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":1,"chat":{"id":1}}}`))
+	}))
+	defer api.Close()
+	bot, err := tele.NewBot(tele.Settings{URL: api.URL, Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := model.NewMemoryStorage()
+	h := &handler{
+		bot:      bot,
+		userRepo: s.Users,
+		storage:  s,
+		tx:       s.Tx,
+		broadcasts: &broadcast.Engine{
+			Broadcasts:    s.Broadcasts,
+			Subscriptions: s.Subscriptions,
+		},
+	}
+	user := &model.User{UserID: 1, State: stateBroadcastConfirm}
+	conv, _ := user.Conversation()
+	if err := model.SetConversationValue(conv, broadcastDraftKey, model.Broadcast{Topic: "news", Text: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.SetConversation(conv); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Users.Insert(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		c := bot.NewContext(tele.Update{Callback: &tele.Callback{
+			ID:      "1",
+			Sender:  &tele.User{ID: 1},
+			Message: &tele.Message{ID: 1, Chat: &tele.Chat{ID: 1}},
+			Data:    "\f" + broadcastButton + "|send",
+		}})
+		if err := h.onBroadcastButton(ctx, c); err != nil {
+			t.Fatalf("tap %d: %v", i+1, err)
+		}
+	}
+
+	sent, err := s.Broadcasts.ListByStatus(ctx, model.BroadcastSending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 {
+		t.Errorf("got %d broadcasts, want 1", len(sent))
+	}
+	got, err := s.Users.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != fsm.Idle {
+		t.Errorf("got state %q, want idle", got.State)
+	}
+}
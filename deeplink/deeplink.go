@@ -0,0 +1,88 @@
+// Package deeplink parses payloads of t.me/<bot>?start=<payload> links and routes them to actions.
+package deeplink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+type Kind string
+
+// Kinds of payloads. Payloads without a known prefix are campaign codes.
+const (
+	Campaign  Kind = "campaign"
+	Event     Kind = "event"
+	Referral  Kind = "ref"
+	Subscribe Kind = "sub"
+)
+
+// ErrInvalid is returned for payloads Telegram wouldn't pass to the bot or with malformed values.
+var ErrInvalid = errors.New("invalid deep link payload")
+
+// payloadRx matches payloads allowed by Telegram.
+var payloadRx = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+type Link struct {
+	Payload string
+	Kind    Kind
+	// Value is the part after the prefix, e.g. user id of Referral, or the whole payload of Campaign.
+	Value string
+}
+
+// Parse parses payload of /start.
+func Parse(payload string) (Link, error) {
+	if !payloadRx.MatchString(payload) {
+		return Link{}, fmt.Errorf("%w %q", ErrInvalid, payload)
+	}
+	l := Link{Payload: payload, Kind: Campaign, Value: payload}
+	for _, kind := range []Kind{Event, Referral, Subscribe} {
+		if prefix := string(kind) + "_"; strings.HasPrefix(payload, prefix) {
+			l.Kind, l.Value = kind, strings.TrimPrefix(payload, prefix)
+			break
+		}
+	}
+	if l.Value == "" {
+		return Link{}, fmt.Errorf("%w %q: empty %s", ErrInvalid, payload, l.Kind)
+	}
+	if l.Kind == Referral {
+		if _, err := l.UserID(); err != nil {
+			return Link{}, fmt.Errorf("%w %q: %v", ErrInvalid, payload, err)
+		}
+	}
+	return l, nil
+}
+
+// UserID returns the referrer of Referral links.
+func (l Link) UserID() (uint64, error) {
+	return strconv.ParseUint(l.Value, 10, 64)
+}
+
+// Handler performs the action of a link. newUser is set when the user came for the first time.
+type Handler func(ctx context.Context, c tele.Context, l Link, newUser bool) error
+
+// Router dispatches links to handlers of their kinds.
+type Router struct {
+	handlers map[Kind]Handler
+}
+
+func (r *Router) Handle(kind Kind, h Handler) {
+	if r.handlers == nil {
+		r.handlers = map[Kind]Handler{}
+	}
+	r.handlers[kind] = h
+}
+
+// Dispatch runs handler of the link kind. Links without a handler are ignored.
+func (r *Router) Dispatch(ctx context.Context, c tele.Context, l Link, newUser bool) error {
+	h, ok := r.handlers[l.Kind]
+	if !ok {
+		return nil
+	}
+	return h(ctx, c, l, newUser)
+}
@@ -0,0 +1,51 @@
+package deeplink
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestParse(t *testing.T) {
+	cases := map[string]Link{
+		"spring2023":   {Payload: "spring2023", Kind: Campaign, Value: "spring2023"},
+		"event_42":     {Payload: "event_42", Kind: Event, Value: "42"},
+		"ref_123":      {Payload: "ref_123", Kind: Referral, Value: "123"},
+		"sub_events":   {Payload: "sub_events", Kind: Subscribe, Value: "events"},
+		"sub_it-meets": {Payload: "sub_it-meets", Kind: Subscribe, Value: "it-meets"},
+	}
+	for payload, want := range cases {
+		got, err := Parse(payload)
+		if err != nil {
+			t.Error(payload, err)
+		}
+		if got != want {
+			t.Errorf("%s: got %+v, want %+v", payload, got, want)
+		}
+	}
+
+	for _, payload := range []string{"", "sub_", "ref_abc", "ref_-1", "with space", "привет"} {
+		if _, err := Parse(payload); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%q: not invalid error %v", payload, err)
+		}
+	}
+}
+
+func TestRouter(t *testing.T) {
+	var r Router
+	var got Link
+	r.Handle(Subscribe, func(_ context.Context, _ tele.Context, l Link, _ bool) error {
+		got = l
+		return nil
+	})
+	sub, _ := Parse("sub_events")
+	if err := r.Dispatch(context.Background(), nil, sub, false); err != nil || got != sub {
+		t.Error("link is not dispatched", got, err)
+	}
+	campaign, _ := Parse("spring")
+	if err := r.Dispatch(context.Background(), nil, campaign, true); err != nil || got != sub {
+		t.Error("link without handler is dispatched", got, err)
+	}
+}
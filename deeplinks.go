@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/failoverbar/bot/deeplink"
+	"github.com/failoverbar/bot/model"
+	"github.com/failoverbar/bot/wrap"
+	"log"
+	"strconv"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// eventsTopic is the topic guests coming with event links are subscribed to.
+const eventsTopic = "events"
+
+func (h *handler) linkRouter() *deeplink.Router {
+	r := &deeplink.Router{}
+	r.Handle(deeplink.Event, h.onEventLink)
+	r.Handle(deeplink.Referral, h.onReferralLink)
+	r.Handle(deeplink.Subscribe, h.onSubscribeLink)
+	return r
+}
+
+// processPayload records the deep link the user came with to /start and performs its action.
+func (h *handler) processPayload(ctx context.Context, c tele.Context, newUser bool) error {
+	payload := c.Message().Payload
+	if payload == "" {
+		return nil
+	}
+	l, err := deeplink.Parse(payload)
+	if err != nil {
+		log.Printf("got bad payload from %d: %s", c.Sender().ID, err)
+		return nil
+	}
+	err = h.attributionRepo.Insert(ctx, &model.Attribution{
+		UserID:  uint64(c.Sender().ID),
+		Payload: l.Payload,
+		Kind:    string(l.Kind),
+		Value:   l.Value,
+		NewUser: newUser,
+	})
+	if err != nil {
+		return err
+	}
+	return h.links.Dispatch(ctx, c, l, newUser)
+}
+
+// onEventLink subscribes the user to the topic of the event if the catalog has one, or to eventsTopic otherwise.
+func (h *handler) onEventLink(ctx context.Context, c tele.Context, l deeplink.Link, _ bool) error {
+	slug, text := eventsTopic, "Рады, что тебе интересно наше мероприятие! Буду присылать анонсы мероприятий бара."
+	topic, err := h.topicRepo.Get(ctx, l.Value)
+	if err == nil {
+		slug, text = topic.Slug, "Рады, что тебе интересно наше мероприятие! Подписал тебя на «"+topic.Title+"»."
+	} else if !errors.Is(err, wrap.NotFoundError{}) {
+		return err
+	}
+	err = h.subscribe(ctx, uint64(c.Sender().ID), slug)
+	if errors.Is(err, wrap.UnknownReferenceError{}) {
+		log.Printf("topic %s is not in the catalog, event link %s of %d is only recorded", slug, l.Value, c.Sender().ID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return c.Send(text)
+}
+
+// onReferralLink thanks the referrer for a new guest.
+func (h *handler) onReferralLink(ctx context.Context, c tele.Context, l deeplink.Link, newUser bool) error {
+	referrerID, err := l.UserID()
+	if err != nil {
+		return err
+	}
+	if !newUser || referrerID == uint64(c.Sender().ID) {
+		return nil
+	}
+	if _, err := h.userRepo.Get(ctx, referrerID); errors.Is(err, wrap.NotFoundError{}) {
+		log.Printf("got referral of unknown user %d from %d", referrerID, c.Sender().ID)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := h.sendTo(ctx, referrerID, "По твоей ссылке к нам пришёл новый гость. Спасибо!"); err != nil {
+		log.Printf("can't notify referrer %d: %s", referrerID, err)
+	}
+	return nil
+}
+
+func (h *handler) onSubscribeLink(ctx context.Context, c tele.Context, l deeplink.Link, _ bool) error {
+	topic, err := h.topicRepo.Get(ctx, l.Value)
+	if errors.Is(err, wrap.NotFoundError{}) {
+		log.Printf("got subscription to unknown topic %s from %d", l.Value, c.Sender().ID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = h.subscribe(ctx, uint64(c.Sender().ID), topic.Slug)
+	if errors.Is(err, wrap.UnknownReferenceError{}) {
+		log.Printf("got subscription to archived topic %s from %d", l.Value, c.Sender().ID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return c.Send("Подписал тебя на «" + topic.Title + "».")
+}
+
+// subscribe activates the subscription of the user keeping the stored one if there is any.
+func (h *handler) subscribe(ctx context.Context, userID uint64, topic string) error {
+	return h.tx.InTx(ctx, func(ctx context.Context) error {
+		s, err := h.subscriptionsRepo.Get(ctx, userID, topic)
+		if errors.Is(err, wrap.NotFoundError{}) {
+			s, err = &model.Subscription{UserID: userID, Topic: topic}, nil
+		}
+		if err != nil {
+			return err
+		}
+		s.Active = true
+		return h.subscriptionsRepo.Upsert(ctx, s)
+	})
+}
+
+// onInvite sends the user their referral link.
+func (h *handler) onInvite(c tele.Context) error {
+	payload := string(deeplink.Referral) + "_" + strconv.FormatInt(c.Sender().ID, 10)
+	return c.Send("Приглашай друзей по этой ссылке: https://t.me/" + h.bot.Me.Username + "?start=" + payload)
+}
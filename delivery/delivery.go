@@ -0,0 +1,56 @@
+// Package delivery classifies errors of sending messages to Telegram users.
+package delivery
+
+import (
+	"errors"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Reason of a failed send.
+type Reason string
+
+const (
+	// Blocked users stopped the bot or never started it.
+	Blocked Reason = "blocked"
+	// ChatNotFound means Telegram doesn't know the chat, e.g. the account is deleted.
+	ChatNotFound Reason = "chat_not_found"
+	// Deactivated users have their account deactivated.
+	Deactivated Reason = "deactivated"
+	// RateLimited sends may be repeated after RetryAfter.
+	RateLimited Reason = "rate_limited"
+	// Other errors are not specific to the user, e.g. network ones.
+	Other Reason = "other"
+)
+
+// Classify returns reason of the failed send, err must not be nil.
+func Classify(err error) Reason {
+	var flood tele.FloodError
+	switch {
+	case errors.As(err, &flood):
+		return RateLimited
+	case errors.Is(err, tele.ErrBlockedByUser), errors.Is(err, tele.ErrNotStartedByUser):
+		return Blocked
+	case errors.Is(err, tele.ErrChatNotFound):
+		return ChatNotFound
+	case errors.Is(err, tele.ErrUserIsDeactivated):
+		return Deactivated
+	default:
+		return Other
+	}
+}
+
+// Unreachable tells that sends to the user fail until the user comes back.
+func (r Reason) Unreachable() bool {
+	return r == Blocked || r == ChatNotFound || r == Deactivated
+}
+
+// RetryAfter returns the pause Telegram asked for before repeating a rate limited send.
+func RetryAfter(err error) (time.Duration, bool) {
+	var flood tele.FloodError
+	if !errors.As(err, &flood) {
+		return 0, false
+	}
+	return time.Duration(flood.RetryAfter) * time.Second, true
+}
@@ -0,0 +1,42 @@
+package delivery
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err  error
+		want Reason
+	}{
+		{tele.ErrBlockedByUser, Blocked},
+		{fmt.Errorf("send: %w", tele.ErrBlockedByUser), Blocked},
+		{tele.ErrNotStartedByUser, Blocked},
+		{tele.ErrChatNotFound, ChatNotFound},
+		{tele.ErrUserIsDeactivated, Deactivated},
+		{tele.FloodError{RetryAfter: 3}, RateLimited},
+		{errors.New("connection reset"), Other},
+	}
+	for _, tt := range tests {
+		if got := Classify(tt.err); got != tt.want {
+			t.Errorf("Classify(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+	if !Blocked.Unreachable() || RateLimited.Unreachable() || Other.Unreachable() {
+		t.Error("wrong unreachable reasons")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if d, ok := RetryAfter(tele.FloodError{RetryAfter: 3}); !ok || d != 3*time.Second {
+		t.Error("wrong retry after", d, ok)
+	}
+	if _, ok := RetryAfter(tele.ErrBlockedByUser); ok {
+		t.Error("retry after for blocked user")
+	}
+}
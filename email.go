@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/failoverbar/bot/fsm"
+	"github.com/failoverbar/bot/mail"
+	"github.com/failoverbar/bot/model"
+	"math/big"
+	netmail "net/mail"
+	"os"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// States of email verification.
+const (
+	stateEmailAddress = "email.address"
+	stateEmailCode    = "email.code"
+)
+
+const (
+	emailButton = "email"
+	// emailVerificationKey keeps emailVerification in the conversation.
+	emailVerificationKey = "email"
+	emailCodeTTL         = 10 * time.Minute
+	emailCodeAttempts    = 5
+)
+
+type emailVerification struct {
+	Email    string
+	Code     string
+	Expires  time.Time
+	Attempts int
+}
+
+func (h *handler) emailStates() []fsm.State {
+	return []fsm.State{
+		{
+			Name:       stateEmailAddress,
+			Enter:      h.askEmail,
+			OnText:     h.onEmailAddress,
+			OnCallback: h.onEmailButton,
+			Next:       []string{stateEmailCode, fsm.Idle},
+		},
+		{
+			Name:       stateEmailCode,
+			Enter:      h.askEmailCode,
+			OnText:     h.onEmailCode,
+			OnCallback: h.onEmailButton,
+			Next:       []string{stateEmailAddress, fsm.Idle},
+		},
+	}
+}
+
+// newMailer sends emails through SMTP_ADDR or writes them to the log if it is not set.
+func newMailer() mail.Mailer {
+	addr, ok := os.LookupEnv("SMTP_ADDR")
+	if !ok {
+		return mail.LogMailer{}
+	}
+	return &mail.SMTPMailer{
+		Addr:     addr,
+		From:     os.Getenv("SMTP_FROM"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+	}
+}
+
+// onEmail starts verification of a new email outside of registration.
+func (h *handler) onEmail(ctx context.Context, c tele.Context) error {
+	err := h.fsm.Transition(ctx, c, stateEmailAddress)
+	if errors.Is(err, fsm.ErrNoState) {
+		return c.Send("Сначала давай познакомимся: отправь /start.")
+	}
+	if errors.Is(err, fsm.ErrTransition) {
+		return c.Send("Давай сначала закончим текущий разговор.")
+	}
+	return err
+}
+
+func (h *handler) askEmail(_ context.Context, c tele.Context) error {
+	m := h.bot.NewMarkup()
+	m.Inline(m.Row(m.Data("Пропустить", emailButton, "skip")))
+	return c.Send("Оставь email, чтобы получать новости бара. Я пришлю на него код для подтверждения.", m)
+}
+
+func (h *handler) onEmailAddress(ctx context.Context, c tele.Context) error {
+	text := strings.TrimSpace(c.Text())
+	addr, err := netmail.ParseAddress(text)
+	if err != nil || addr.Address != text {
+		return c.Send("Это не похоже на email. Попробуй ещё раз.")
+	}
+	code, err := newEmailCode()
+	if err != nil {
+		return err
+	}
+	conv, err := h.conversation(ctx, c)
+	if err != nil {
+		return err
+	}
+	err = model.SetConversationValue(conv, emailVerificationKey, emailVerification{
+		Email:   addr.Address,
+		Code:    code,
+		Expires: time.Now().Add(emailCodeTTL),
+	})
+	if err != nil {
+		return err
+	}
+	err = h.mailer.Send(ctx, addr.Address, "Код подтверждения Фейловер Бара",
+		"Твой код: "+code+"\n\nЕсли ты не оставлял этот адрес боту Фейловер Бара, просто проигнорируй письмо.")
+	if err != nil {
+		return err
+	}
+	// The profile keeps the previous address until the new one is confirmed in onEmailCode.
+	return h.fsm.Transition(ctx, c, stateEmailCode)
+}
+
+func (h *handler) askEmailCode(ctx context.Context, c tele.Context) error {
+	v, _, err := h.emailVerification(ctx, c)
+	if err != nil {
+		return err
+	}
+	m := h.bot.NewMarkup()
+	m.Inline(m.Row(m.Data("Другой адрес", emailButton, "change"), m.Data("Пропустить", emailButton, "skip")))
+	return c.Send("Отправил код на "+v.Email+". Пришли его сюда.", m)
+}
+
+func (h *handler) onEmailCode(ctx context.Context, c tele.Context) error {
+	v, ok, err := h.emailVerification(ctx, c)
+	if err != nil {
+		return err
+	}
+	if !ok || time.Now().After(v.Expires) || v.Attempts >= emailCodeAttempts {
+		if err := c.Send("Код больше не действует, давай отправлю новый."); err != nil {
+			return err
+		}
+		return h.fsm.Transition(ctx, c, stateEmailAddress)
+	}
+	if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(c.Text())), []byte(v.Code)) != 1 {
+		v.Attempts++
+		conv, err := h.conversation(ctx, c)
+		if err != nil {
+			return err
+		}
+		if err = model.SetConversationValue(conv, emailVerificationKey, v); err != nil {
+			return err
+		}
+		if err = h.saveConversation(ctx, c); err != nil {
+			return err
+		}
+		return c.Send(fmt.Sprintf("Неверный код, осталось попыток: %d.", emailCodeAttempts-v.Attempts))
+	}
+
+	err = h.tx.InTx(ctx, func(ctx context.Context) error {
+		err := h.updateProfile(ctx, c, func(profile *model.Profile) {
+			profile.Email = &v.Email
+			profile.EmailVerified = true
+		})
+		if err != nil {
+			return err
+		}
+		return h.fsm.Transition(ctx, c, fsm.Idle)
+	})
+	if err != nil {
+		return err
+	}
+	return c.Send("Почта подтверждена, спасибо!")
+}
+
+func (h *handler) onEmailButton(ctx context.Context, c tele.Context) error {
+	unique, action := fsm.CallbackData(c)
+	if unique != emailButton {
+		return c.Send("Пришли email или нажми «Пропустить».")
+	}
+	switch action {
+	case "change":
+		if err := h.fsm.Transition(ctx, c, stateEmailAddress); err != nil {
+			return err
+		}
+		return c.Edit("Хорошо, давай другой адрес.")
+	case "skip":
+		if err := h.fsm.Transition(ctx, c, fsm.Idle); err != nil {
+			return err
+		}
+		return c.Edit("Хорошо, обойдёмся без почты. Указать её можно позже командой /email.")
+	default:
+		return c.Send("Пришли email или нажми «Пропустить».")
+	}
+}
+
+func (h *handler) emailVerification(ctx context.Context, c tele.Context) (emailVerification, bool, error) {
+	conv, err := h.conversation(ctx, c)
+	if err != nil {
+		return emailVerification{}, false, err
+	}
+	return model.ConversationValue[emailVerification](conv, emailVerificationKey)
+}
+
+// newEmailCode returns random 6 digits.
+func newEmailCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
@@ -0,0 +1,244 @@
+// Package fsm routes updates of a user to handlers of the conversation state the user is in.
+//
+// States are registered declaratively with their handlers, entry prompts and allowed transitions:
+//
+//	m := &fsm.Machine{Store: store}
+//	m.Add(fsm.State{
+//		Name:   "register.name",
+//		Enter:  askName,
+//		OnText: saveName,
+//		Next:   []string{"register.phone"},
+//	})
+//	b.Handle(tele.OnText, m.Handle)
+//
+// Handlers move the user to another state with Transition, and the entry prompt of that state
+// is sent after the handler succeeds.
+package fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Idle is the state of users who are not in a dialog.
+const Idle = ""
+
+var (
+	// ErrNoState is returned by Store for users without state, i.e. unknown ones.
+	ErrNoState = errors.New("no state")
+	// ErrUnknownState is reported for users in states which are not registered.
+	ErrUnknownState = errors.New("unknown state")
+	// ErrTransition is returned by Transition to states not listed in State.Next.
+	ErrTransition = errors.New("transition is not allowed")
+)
+
+// Store persists states of users. The update is passed to let the store keep
+// data of the dialog along with the state.
+type Store interface {
+	// State returns state of the sender or ErrNoState.
+	State(ctx context.Context, c tele.Context) (string, error)
+	SetState(ctx context.Context, c tele.Context, state string) error
+}
+
+// HandlerFunc handles an update. ctx is limited in time and ends when the update is handled.
+type HandlerFunc func(ctx context.Context, c tele.Context) error
+
+type State struct {
+	Name string
+	// Enter sends the prompt of the state when the user enters it.
+	Enter HandlerFunc
+	// OnText, OnContact and OnCallback handle corresponding updates, nil ones fall back to Fallback.
+	OnText     HandlerFunc
+	OnContact  HandlerFunc
+	OnCallback HandlerFunc
+	// Fallback handles updates the state has no handler for, Machine.Fallback is used if it is nil.
+	Fallback HandlerFunc
+	// Next lists states Transition may move the user to.
+	Next []string
+}
+
+// Machine dispatches updates to registered states.
+type Machine struct {
+	Store Store
+	// Context creates context for handling of an update, it is limited by DefaultTimeout if nil.
+	Context func(c tele.Context) (context.Context, context.CancelFunc)
+	// Fallback handles updates which the current state doesn't handle. They are ignored if it is nil.
+	Fallback HandlerFunc
+	// NoState handles updates of users for whom Store returns ErrNoState.
+	NoState HandlerFunc
+	// Unknown handles updates of users in unregistered states. ErrUnknownState is returned if it is nil.
+	Unknown func(ctx context.Context, c tele.Context, state string) error
+
+	states map[string]*State
+}
+
+// DefaultTimeout limits handling of an update when Machine.Context is nil.
+const DefaultTimeout = 5 * time.Second
+
+// context keys of tele.Context.
+const (
+	stateKey   = "fsm.state"
+	enteredKey = "fsm.entered"
+)
+
+// Add registers states. It panics on duplicates since it is a programming error.
+func (m *Machine) Add(states ...State) {
+	if m.states == nil {
+		m.states = map[string]*State{}
+	}
+	for i := range states {
+		s := states[i]
+		if _, ok := m.states[s.Name]; ok {
+			panic(fmt.Sprintf("fsm: state %q is added twice", s.Name))
+		}
+		m.states[s.Name] = &s
+	}
+}
+
+// Validate reports a transition to an unregistered state.
+func (m *Machine) Validate() error {
+	for _, s := range m.states {
+		for _, next := range s.Next {
+			if _, ok := m.states[next]; !ok {
+				return fmt.Errorf("%w %q in transitions of %q", ErrUnknownState, next, s.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// Handle dispatches the update to the handler of the sender's state.
+func (m *Machine) Handle(c tele.Context) error {
+	return m.Wrap(m.dispatch)(c)
+}
+
+// Wrap adapts h to telebot and sends the entry prompt after h moved the user to another state.
+// Commands, which are handled outside of states, use it to start dialogs.
+func (m *Machine) Wrap(h HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		ctx, cancel := m.context(c)
+		defer cancel()
+		if err := h(ctx, c); err != nil {
+			return err
+		}
+		name, ok := c.Get(enteredKey).(string)
+		if !ok {
+			return nil
+		}
+		if s, ok := m.states[name]; ok && s.Enter != nil {
+			return s.Enter(ctx, c)
+		}
+		return nil
+	}
+}
+
+func (m *Machine) dispatch(ctx context.Context, c tele.Context) error {
+	name, err := m.Store.State(ctx, c)
+	if errors.Is(err, ErrNoState) && m.NoState != nil {
+		return m.NoState(ctx, c)
+	}
+	if err != nil {
+		return err
+	}
+	c.Set(stateKey, name)
+	s, ok := m.states[name]
+	if !ok {
+		if m.Unknown != nil {
+			return m.Unknown(ctx, c, name)
+		}
+		return fmt.Errorf("%w %q of user %d", ErrUnknownState, name, c.Sender().ID)
+	}
+	var h HandlerFunc
+	switch {
+	case c.Callback() != nil:
+		h = s.OnCallback
+	case c.Message() != nil && c.Message().Contact != nil:
+		h = s.OnContact
+	case c.Message() != nil:
+		h = s.OnText
+	}
+	if h == nil {
+		h = s.Fallback
+	}
+	if h == nil {
+		h = m.Fallback
+	}
+	if h == nil {
+		return nil
+	}
+	return h(ctx, c)
+}
+
+// Transition moves the user to the state listed in Next of the current one.
+// It may be called inside of a transaction bound to ctx, the entry prompt is sent after the handler succeeds.
+func (m *Machine) Transition(ctx context.Context, c tele.Context, to string) error {
+	from, err := m.current(ctx, c)
+	if err != nil {
+		return err
+	}
+	if !m.allowed(from, to) {
+		return fmt.Errorf("%w: %q -> %q", ErrTransition, from, to)
+	}
+	return m.Reset(ctx, c, to)
+}
+
+// Reset moves the user to the state regardless of the current one, e.g. on /start.
+func (m *Machine) Reset(ctx context.Context, c tele.Context, to string) error {
+	if _, ok := m.states[to]; !ok {
+		return fmt.Errorf("%w %q", ErrUnknownState, to)
+	}
+	if err := m.Store.SetState(ctx, c, to); err != nil {
+		return err
+	}
+	m.Entered(c, to)
+	return nil
+}
+
+// Entered schedules the entry prompt of the state which was stored by the caller, e.g. with a new user.
+func (m *Machine) Entered(c tele.Context, state string) {
+	c.Set(stateKey, state)
+	c.Set(enteredKey, state)
+}
+
+// CallbackData splits data of an inline button created with tele.ReplyMarkup.Data into its unique and data.
+// Telebot passes raw data to OnCallback for buttons without their own handler, states get it this way.
+func CallbackData(c tele.Context) (unique, data string) {
+	raw := c.Callback().Data
+	if !strings.HasPrefix(raw, "\f") {
+		return "", raw
+	}
+	unique, data, _ = strings.Cut(raw[1:], "|")
+	return unique, data
+}
+
+func (m *Machine) current(ctx context.Context, c tele.Context) (string, error) {
+	if name, ok := c.Get(stateKey).(string); ok {
+		return name, nil
+	}
+	return m.Store.State(ctx, c)
+}
+
+func (m *Machine) allowed(from, to string) bool {
+	s, ok := m.states[from]
+	if !ok {
+		return false
+	}
+	for _, next := range s.Next {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Machine) context(c tele.Context) (context.Context, context.CancelFunc) {
+	if m.Context != nil {
+		return m.Context(c)
+	}
+	return context.WithTimeout(context.Background(), DefaultTimeout)
+}
@@ -0,0 +1,135 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+type memoryStore map[int64]string
+
+func (s memoryStore) State(_ context.Context, c tele.Context) (string, error) {
+	state, ok := s[c.Sender().ID]
+	if !ok {
+		return "", ErrNoState
+	}
+	return state, nil
+}
+
+func (s memoryStore) SetState(_ context.Context, c tele.Context, state string) error {
+	s[c.Sender().ID] = state
+	return nil
+}
+
+const userID = 1
+
+func newContext(t *testing.T, m *tele.Message) tele.Context {
+	b, err := tele.NewBot(tele.Settings{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Sender = &tele.User{ID: userID}
+	return b.NewContext(tele.Update{Message: m})
+}
+
+func TestMachine(t *testing.T) {
+	store := memoryStore{}
+	var calls []string
+	record := func(name string) HandlerFunc {
+		return func(context.Context, tele.Context) error {
+			calls = append(calls, name)
+			return nil
+		}
+	}
+	m := &Machine{
+		Store:    store,
+		Fallback: record("fallback"),
+		NoState:  record("nostate"),
+	}
+	m.Add(
+		State{
+			Name:   Idle,
+			OnText: record("idle.text"),
+		},
+		State{
+			Name:  "ask",
+			Enter: record("ask.enter"),
+			OnText: func(ctx context.Context, c tele.Context) error {
+				calls = append(calls, "ask.text")
+				return m.Transition(ctx, c, Idle)
+			},
+			Next: []string{Idle},
+		},
+	)
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	text := func() tele.Context {
+		return newContext(t, &tele.Message{Text: "text"})
+	}
+	handle := func(c tele.Context) {
+		if err := m.Handle(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handle(text())
+	store[userID] = Idle
+	handle(text())
+	handle(newContext(t, &tele.Message{Contact: &tele.Contact{}}))
+
+	c := text()
+	if err := m.Transition(context.Background(), c, "ask"); !errors.Is(err, ErrTransition) {
+		t.Error("transition is not checked", err)
+	}
+	if err := m.Wrap(func(ctx context.Context, c tele.Context) error {
+		return m.Reset(ctx, c, "ask")
+	})(c); err != nil {
+		t.Fatal(err)
+	}
+	handle(text())
+
+	want := []string{"nostate", "idle.text", "fallback", "ask.enter", "ask.text"}
+	if len(calls) != len(want) {
+		t.Fatalf("got calls %v, want %v", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatalf("got calls %v, want %v", calls, want)
+		}
+	}
+	if store[userID] != Idle {
+		t.Errorf("got state %q after transition", store[userID])
+	}
+
+	store[userID] = "removed"
+	if err := m.Handle(text()); !errors.Is(err, ErrUnknownState) {
+		t.Error("unknown state is not reported", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	m := &Machine{}
+	m.Add(State{Name: Idle, Next: []string{"missing"}})
+	if err := m.Validate(); !errors.Is(err, ErrUnknownState) {
+		t.Error("transition to unknown state is not reported", err)
+	}
+}
+
+func TestCallbackData(t *testing.T) {
+	b, err := tele.NewBot(tele.Settings{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := b.NewContext(tele.Update{Callback: &tele.Callback{Data: "\fit|yes"}})
+	if unique, data := CallbackData(c); unique != "it" || data != "yes" {
+		t.Error("wrong callback data", unique, data)
+	}
+	c = b.NewContext(tele.Update{Callback: &tele.Callback{Data: "raw"}})
+	if unique, data := CallbackData(c); unique != "" || data != "raw" {
+		t.Error("wrong raw callback data", unique, data)
+	}
+}
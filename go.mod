@@ -1,26 +1,35 @@
 module github.com/failoverbar/bot
 
-		go 1.18
+go 1.18
 
-		require (
-		github.com/AlekSi/pointer v1.2.0
-		github.com/ydb-platform/ydb-go-sdk-auth-environ v0.1.2
-		github.com/ydb-platform/ydb-go-sdk/v3 v3.26.10
-		gopkg.in/telebot.v3 v3.0.0
-		)
+require (
+	github.com/AlekSi/pointer v1.2.0
+	github.com/jackc/pgx/v4 v4.18.1
+	github.com/ydb-platform/ydb-go-genproto v0.0.0-20220531094121-36ca6bddb9f7
+	github.com/ydb-platform/ydb-go-sdk-auth-environ v0.1.2
+	github.com/ydb-platform/ydb-go-sdk/v3 v3.26.10
+	gopkg.in/telebot.v3 v3.0.0
+)
 
-		require (
-		github.com/golang-jwt/jwt v3.2.2+incompatible // indirect
-		github.com/golang/protobuf v1.5.2 // indirect
-		github.com/jonboulle/clockwork v0.3.0 // indirect
-		github.com/yandex-cloud/go-genproto v0.0.0-20220530090903-45450a2d0150 // indirect
-		github.com/ydb-platform/ydb-go-genproto v0.0.0-20220531094121-36ca6bddb9f7 // indirect
-		github.com/ydb-platform/ydb-go-yc v0.9.0 // indirect
-		github.com/ydb-platform/ydb-go-yc-metadata v0.5.3 // indirect
-		golang.org/x/net v0.0.0-20220607020251-c690dde0001d // indirect
-		golang.org/x/sys v0.0.0-20220610221304-9f5ed59c137d // indirect
-		golang.org/x/text v0.3.7 // indirect
-		google.golang.org/genproto v0.0.0-20220608133413-ed9918b62aac // indirect
-		google.golang.org/grpc v1.47.0 // indirect
-		google.golang.org/protobuf v1.28.0 // indirect
-		)
+require (
+	github.com/golang-jwt/jwt v3.2.2+incompatible // indirect
+	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/jackc/chunkreader/v2 v2.0.1 // indirect
+	github.com/jackc/pgconn v1.14.0 // indirect
+	github.com/jackc/pgio v1.0.0 // indirect
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgproto3/v2 v2.3.2 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
+	github.com/jackc/pgtype v1.14.0 // indirect
+	github.com/jonboulle/clockwork v0.3.0 // indirect
+	github.com/yandex-cloud/go-genproto v0.0.0-20220530090903-45450a2d0150 // indirect
+	github.com/ydb-platform/ydb-go-yc v0.9.0 // indirect
+	github.com/ydb-platform/ydb-go-yc-metadata v0.5.3 // indirect
+	golang.org/x/crypto v0.6.0 // indirect
+	golang.org/x/net v0.6.0 // indirect
+	golang.org/x/sys v0.5.0 // indirect
+	golang.org/x/text v0.7.0 // indirect
+	google.golang.org/genproto v0.0.0-20220608133413-ed9918b62aac // indirect
+	google.golang.org/grpc v1.47.0 // indirect
+	google.golang.org/protobuf v1.28.0 // indirect
+)
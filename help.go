@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"github.com/failoverbar/bot/model"
+	"github.com/failoverbar/bot/wrap"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// command is a bot command available to users having at least Role.
+type command struct {
+	Text        string
+	Description string
+	Role        model.Role
+	Handler     tele.HandlerFunc
+}
+
+// commands are registered in the bot and listed by /help in this order.
+func (h *handler) commands() []command {
+	return []command{
+		{"/start", "начать сначала", model.RoleGuest, privateChat(h.fsm.Wrap(h.onStart))},
+		{"/help", "список команд", model.RoleGuest, h.onHelp},
+		{"/subscriptions", "подписки на новости", model.RoleGuest, h.onSubscriptions},
+		{"/email", "указать почту", model.RoleGuest, privateChat(h.fsm.Wrap(h.onEmail))},
+		{"/invite", "пригласить друга", model.RoleGuest, h.onInvite},
+		{"/mydata", "что бот знает обо мне", model.RoleGuest, h.onMyData},
+		{"/forget", "удалить мои данные", model.RoleGuest, h.onForget},
+
+		{"/topics", "каталог тем", model.RoleAdmin, h.onTopics},
+		{"/topic_add", "добавить тему", model.RoleAdmin, h.onTopicAdd},
+		{"/topic_edit", "изменить тему", model.RoleAdmin, h.onTopicEdit},
+		{"/topic_hide", "скрыть тему", model.RoleAdmin, h.onTopicHide},
+		{"/topic_show", "показать тему", model.RoleAdmin, h.onTopicShow},
+		{"/topic_archive", "убрать тему в архив", model.RoleAdmin, h.onTopicArchive},
+		{"/topic_restore", "вернуть тему из архива", model.RoleAdmin, h.onTopicRestore},
+		{"/topic_default", "выбор темы по умолчанию", model.RoleAdmin, h.onTopicDefault},
+		{"/topic_order", "порядок темы", model.RoleAdmin, h.onTopicOrder},
+		{"/broadcast", "рассылка подписчикам темы", model.RoleAdmin, privateChat(h.fsm.Wrap(h.onBroadcast))},
+		{"/find", "найти гостя по @username, телефону или имени", model.RoleAdmin, h.onFind},
+		{"/user", "карточка гостя", model.RoleAdmin, h.onUser},
+		{"/setrole", "поменять роль", model.RoleAdmin, h.onSetRole},
+		{"/resetstate", "сбросить диалог гостя", model.RoleAdmin, h.onResetState},
+		{"/ban", "заблокировать", model.RoleAdmin, h.onBan},
+		{"/unban", "разблокировать", model.RoleAdmin, h.onUnban},
+	}
+}
+
+// handleCommands registers commands guarded by their roles.
+func (h *handler) handleCommands(b *tele.Bot) {
+	for _, cmd := range h.commands() {
+		if cmd.Role == model.RoleGuest {
+			b.Handle(cmd.Text, cmd.Handler)
+			continue
+		}
+		b.Handle(cmd.Text, cmd.Handler, RequireRole(h.userRepo, cmd.Role))
+	}
+}
+
+// onHelp lists commands available to the sender.
+func (h *handler) onHelp(c tele.Context) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	role := model.RoleGuest
+	user, err := h.userRepo.Get(ctx, uint64(c.Sender().ID))
+	if err != nil && !errors.Is(err, wrap.NotFoundError{}) {
+		return err
+	}
+	if err == nil {
+		role = user.AccessRole()
+	}
+	return c.Send(helpText(h.commands(), role))
+}
+
+func helpText(commands []command, role model.Role) string {
+	lines := []string{"Что я умею:"}
+	for _, cmd := range commands {
+		if cmd.Role <= role {
+			lines = append(lines, cmd.Text+" — "+cmd.Description)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
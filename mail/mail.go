@@ -0,0 +1,25 @@
+// Package mail sends emails to guests, e.g. codes confirming their addresses.
+package mail
+
+import (
+	"context"
+	"log"
+)
+
+// Mailer sends plain text emails.
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+var (
+	_ Mailer = (*SMTPMailer)(nil)
+	_ Mailer = LogMailer{}
+)
+
+// LogMailer writes emails to the log instead of sending them. It is used for local runs without SMTP.
+type LogMailer struct{}
+
+func (LogMailer) Send(_ context.Context, to, subject, body string) error {
+	log.Printf("email to %s: %s\n%s", to, subject, body)
+	return nil
+}
@@ -0,0 +1,127 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"mime/quotedprintable"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP accepts a single session and records the envelope and the message.
+type fakeSMTP struct {
+	ln   net.Listener
+	from string
+	to   []string
+	auth string
+	data string
+	done chan struct{}
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{ln: ln, done: make(chan struct{})}
+	go s.serve()
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	return s
+}
+
+func (s *fakeSMTP) serve() {
+	defer close(s.done)
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	tc := textproto.NewConn(conn)
+	reply := func(line string) {
+		_ = tc.PrintfLine("%s", line)
+	}
+	reply("220 localhost fake SMTP")
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO", "HELO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			s.auth = arg
+			reply("235 Authentication successful")
+		case "MAIL":
+			s.from = arg
+			reply("250 OK")
+		case "RCPT":
+			s.to = append(s.to, arg)
+			reply("250 OK")
+		case "DATA":
+			reply("354 Go ahead")
+			data, err := tc.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.data = string(data)
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Not implemented")
+		}
+	}
+}
+
+func TestSMTPMailer(t *testing.T) {
+	s := newFakeSMTP(t)
+	_, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	m := &SMTPMailer{
+		Addr:     net.JoinHostPort("localhost", port),
+		From:     "bot@failover.bar",
+		Username: "bot",
+		Password: "secret",
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Send(ctx, "guest@example.com", "Код подтверждения", "Твой код: 123456"); err != nil {
+		t.Fatal(err)
+	}
+	<-s.done
+
+	if s.from != "FROM:<bot@failover.bar>" {
+		t.Error("wrong sender", s.from)
+	}
+	if len(s.to) != 1 || s.to[0] != "TO:<guest@example.com>" {
+		t.Error("wrong recipients", s.to)
+	}
+	if !strings.HasPrefix(s.auth, "PLAIN ") {
+		t.Error("not authenticated", s.auth)
+	}
+	header, body, ok := strings.Cut(s.data, "\n\n")
+	if !ok {
+		t.Fatal("no body", s.data)
+	}
+	if !strings.Contains(header, "Subject: =?utf-8?q?") {
+		t.Error("subject is not encoded", header)
+	}
+	decoded, err := io.ReadAll(quotedprintable.NewReader(bufio.NewReader(strings.NewReader(body))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(decoded)) != "Твой код: 123456" {
+		t.Error("wrong body", string(decoded))
+	}
+}
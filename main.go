@@ -2,12 +2,23 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
+	"github.com/failoverbar/bot/antispam"
+	"github.com/failoverbar/bot/api"
+	"github.com/failoverbar/bot/broadcast"
+	"github.com/failoverbar/bot/deeplink"
+	"github.com/failoverbar/bot/fsm"
+	"github.com/failoverbar/bot/mail"
+	"github.com/failoverbar/bot/migrations"
 	"github.com/failoverbar/bot/model"
 	"github.com/failoverbar/bot/wrap"
 	ydbEnviron "github.com/ydb-platform/ydb-go-sdk-auth-environ"
 	"log"
+	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/ydb-platform/ydb-go-sdk/v3"
@@ -16,13 +27,43 @@ import (
 
 func main() {
 	ctx := context.Background()
-	dsn, ok := os.LookupEnv("YDB_DSN")
-	if !ok {
-		log.Fatal("Set env YDB_DSN and any cred from ydb-go-sdk-auth-environ")
+	storage, migrator, err := openStorage(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if migrator == nil {
+			log.Fatal("Set env YDB_DSN or POSTGRES_DSN to run migrations")
+		}
+		if err := runMigrate(ctx, migrator, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if migrator != nil {
+		if err := checkSchema(ctx, migrator); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := seedTopics(ctx, storage.Topics); err != nil {
+		log.Fatal(err)
 	}
-	db, err := ydb.Open(ctx, dsn, ydbEnviron.WithEnvironCredentials(ctx))
+	ownerID, err := ownerFromEnv()
 	if err != nil {
-		log.Fatal("can't connect to DB", err)
+		log.Fatal(err)
+	}
+	if err := bootstrapOwner(ctx, storage.Users, ownerID); err != nil {
+		log.Fatal(err)
+	}
+	staffChatID, err := chatFromEnv("STAFF_CHAT_ID")
+	if err != nil {
+		log.Fatal(err)
+	}
+	supportChatID, err := chatFromEnv("SUPPORT_CHAT_ID")
+	if err != nil {
+		log.Fatal(err)
 	}
 	settings := tele.Settings{
 		Token:  os.Getenv("TELEGRAM_TOKEN"),
@@ -32,165 +73,225 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	b.Use(Logger(), AutoResponder)
+	guard := &antispam.Guard{}
+	b.Use(Logger(), AutoResponder, AntiSpam(guard, storage.Users, storage.Bans), Banned(guard, storage.Bans))
 
 	h := handler{
 		bot:                 b,
-		userRepo:            &model.UserRepo{DB: db},
-		profileRepo:         &model.ProfileRepo{DB: db},
-		telegramProfileRepo: &model.TelegramProfileRepo{DB: db},
-		subscriptionsRepo:   &model.SubscriptionRepo{DB: db},
+		userRepo:            storage.Users,
+		profileRepo:         storage.Profiles,
+		telegramProfileRepo: storage.TelegramProfiles,
+		subscriptionsRepo:   storage.Subscriptions,
+		attributionRepo:     storage.Attributions,
+		topicRepo:           storage.Topics,
+		tx:                  storage.Tx,
+		storage:             storage,
+		mailer:              newMailer(),
+		ownerID:             ownerID,
+		staffChatID:         staffChatID,
+		supportChatID:       supportChatID,
+		guard:               guard,
+	}
+	h.fsm = &fsm.Machine{
+		Store:    stateStore{h: &h},
+		Context:  requestContext,
+		Fallback: h.onFallback,
+		NoState:  h.onStart,
+		Unknown:  h.onUnknownState,
+	}
+	h.links = h.linkRouter()
+	h.broadcasts = h.newBroadcastEngine()
+	h.fsm.Add(h.registerStates()...)
+	h.fsm.Add(h.emailStates()...)
+	h.fsm.Add(h.broadcastStates()...)
+	if err := h.fsm.Validate(); err != nil {
+		log.Fatal(err)
 	}
 
-	b.Handle("/start", h.onStart)
+	h.handleCommands(b)
 
-	b.Handle(tele.OnText, h.onText)
+	b.Handle(&subscriptionToggleButton, h.onSubscriptionToggle)
+	b.Handle(&subscriptionsOffButton, h.onSubscriptionsOff)
+	b.Handle(&forgetConfirmButton, h.onForgetConfirm)
+	b.Handle(&forgetCancelButton, h.onForgetCancel)
+	b.Handle(&perkButton, h.onPerk, RequireRole(h.userRepo, model.RoleStaff))
+	b.Handle(&ticketCloseButton, h.onTicketClose, RequireRole(h.userRepo, model.RoleStaff))
 
-	b.Handle(tele.OnContact, h.onContact)
+	b.Handle(tele.OnText, h.withSupportChat(privateChat(h.fsm.Handle)))
+	b.Handle(tele.OnContact, privateChat(h.fsm.Handle))
+	b.Handle(tele.OnPhoto, h.withSupportChat(privateChat(h.fsm.Handle)))
+	b.Handle(tele.OnCallback, privateChat(h.fsm.Handle))
 
-	// Сценарий регистрации
-	// как зовут? Ты из айти? Кто ты в айти?
-	// уведомления об интересных мероприятиях?
+	if err := startAPI(storage); err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		if err := h.broadcasts.Run(ctx); err != nil {
+			log.Print("broadcasts are stopped: ", err)
+		}
+	}()
 	b.Start()
 }
 
-type handler struct {
-	bot *tele.Bot
-
-	userRepo            *model.UserRepo
-	profileRepo         *model.ProfileRepo
-	telegramProfileRepo *model.TelegramProfileRepo
-	subscriptionsRepo   *model.SubscriptionRepo
-}
-
-func (h *handler) onContact(c tele.Context) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if c.Message().Contact.UserID != c.Sender().ID {
-		return c.Send("Получил контакт. Не знаю, что мне с ним делать, но очень интересно.")
+// startAPI serves package api on API_ADDR if it is set. Requests must have API_TOKEN.
+func startAPI(storage model.Storage) error {
+	addr, ok := os.LookupEnv("API_ADDR")
+	if !ok || addr == "" {
+		return nil
 	}
-	userID := uint64(c.Sender().ID)
-	profile, err := h.profileRepo.Get(ctx, userID)
-	if err != nil {
-		return err
+	token := os.Getenv("API_TOKEN")
+	if token == "" {
+		return errors.New("set env API_TOKEN to serve API on API_ADDR")
 	}
-	profile.Phone = &c.Message().Contact.PhoneNumber
-	if err := h.profileRepo.Upsert(ctx, profile); err != nil {
-		return err
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           &api.Server{Storage: storage, Token: token},
+		ReadHeaderTimeout: 10 * time.Second,
 	}
+	go func() {
+		log.Print("API is served on ", addr)
+		if err := srv.ListenAndServe(); err != nil {
+			log.Print("API is stopped: ", err)
+		}
+	}()
+	return nil
+}
 
-	user, err := h.userRepo.Get(ctx, userID)
-	if err != nil {
-		return err
+// openStorage connects to YDB if YDB_DSN is set, to PostgreSQL if POSTGRES_DSN is set
+// and falls back to in-memory storage otherwise. Migrator is nil for in-memory storage.
+func openStorage(ctx context.Context) (model.Storage, *migrations.Migrator, error) {
+	if dsn, ok := os.LookupEnv("YDB_DSN"); ok {
+		db, err := ydb.Open(ctx, dsn, ydbEnviron.WithEnvironCredentials(ctx))
+		if err != nil {
+			return model.Storage{}, nil, fmt.Errorf("can't connect to YDB: %w", err)
+		}
+		m, err := migrations.New(db)
+		return model.NewYDBStorage(db), m, err
 	}
-	user.State = ""
-	if err := h.userRepo.Upsert(ctx, user); err != nil {
-		return err
+	if dsn, ok := os.LookupEnv("POSTGRES_DSN"); ok {
+		db, err := sql.Open("pgx", dsn)
+		if err != nil {
+			return model.Storage{}, nil, fmt.Errorf("can't connect to PostgreSQL (is the bot built with -tags postgres?): %w", err)
+		}
+		m, err := migrations.NewPostgres(db)
+		return model.NewPostgresStorage(db), m, err
 	}
-
-	m := h.bot.NewMarkup()
-	m.Reply()
-	m.RemoveKeyboard = true
-
-	return c.Send("Благодарю. Позднее я попрошу тебя рассказать, какие ивенты тебе интересны.", m)
+	log.Print("Neither YDB_DSN nor POSTGRES_DSN is set, using in-memory storage: all data is lost on restart")
+	return model.NewMemoryStorage(), nil, nil
 }
 
-func (h *handler) onText(c tele.Context) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+type handler struct {
+	bot *tele.Bot
 
-	userID := uint64(c.Message().Sender.ID)
-	user, err := h.userRepo.Get(ctx, userID)
-	if err != nil && !errors.Is(err, wrap.NotFoundError{}) {
-		return err
-	}
-	if err != nil {
-		log.Printf("got text from not-existent user %d: %s", c.Message().Sender.ID, c.Message().Text)
-		return h.onStart(c)
-	}
-
-	switch user.State {
-	case "":
-		log.Printf("got text with empty context %d: %s", c.Message().Sender.ID, c.Message().Text)
-		return c.Send("Ничего не понятно, но очень интересно")
-	case "register.name":
-		return h.onTextRegisterName(c, ctx, user, c.Message().Text)
-	case "register.phone":
-		return h.onTextRegisterPhone(c, c.Message().Text)
-	default:
-		log.Printf("got unknown context %s from %d: %s", user.Context, c.Message().Sender.ID, c.Message().Text)
-		return c.Send("А вы интересный человек")
-	}
-}
+	userRepo            model.UserStorage
+	profileRepo         model.ProfileStorage
+	telegramProfileRepo model.TelegramProfileStorage
+	subscriptionsRepo   model.SubscriptionStorage
+	attributionRepo     model.AttributionStorage
+	topicRepo           model.TopicStorage
 
-func (h *handler) onTextRegisterName(c tele.Context, ctx context.Context, user *model.User, msg string) error {
-	profile, err := h.profileRepo.Get(ctx, user.UserID)
-	if err != nil {
-		return err
-	}
-	profile.Name = &msg
-	if err := h.profileRepo.Upsert(ctx, profile); err != nil {
-		return err
-	}
+	tx model.Transactor
+	// storage is used for operations spanning all repositories.
+	storage model.Storage
 
-	user.State = "register.phone"
-	if err := h.userRepo.Upsert(ctx, user); err != nil {
-		return err
-	}
+	fsm        *fsm.Machine
+	mailer     mail.Mailer
+	links      *deeplink.Router
+	broadcasts *broadcast.Engine
+	// ownerID gets model.RoleOwner, see bootstrapOwner.
+	ownerID uint64
+	// staffChatID gets registration cards if it is set, see notifyRegistration.
+	staffChatID int64
+	// supportChatID gets messages users write outside dialogs, see relayToSupport.
+	supportChatID int64
+	// supportMu serializes relayToSupport, so that quick messages of a user don't post two cards of one ticket.
+	supportMu sync.Mutex
+	// guard is the rate limiter of AntiSpam and the ban cache of Banned, /ban and /unban update it.
+	guard *antispam.Guard
+}
 
-	return h.onTextRegisterPhone(c, *profile.Name)
+// requestContext limits handling of the update in time and makes the sender an actor of changes.
+func requestContext(c tele.Context) (context.Context, context.CancelFunc) {
+	ctx := model.WithActor(context.Background(), model.UserActor(uint64(c.Sender().ID)))
+	return context.WithTimeout(ctx, 5*time.Second)
 }
 
-func (h *handler) onTextRegisterPhone(c tele.Context, msg string) error {
-	m := h.bot.NewMarkup()
-	m.RemoveKeyboard = true
-	m.Reply(m.Row(m.Contact("Отправить номер")))
-	return c.Send("Очень приятно, "+msg+". Для доступа к WiFi и программе лояльности бара мне нужен твой телефон.\n\n"+
-		"Обещаю никому его не раскрывать.", m)
+// updateRetries limits attempts to apply a change to a concurrently modified user.
+const updateRetries = 3
+
+// updateUser applies change to the freshly read user and stores it with optimistic locking.
+// The change is reapplied to a new copy if another update wins the race.
+func (h *handler) updateUser(ctx context.Context, userID uint64, change func(user *model.User)) (user *model.User, err error) {
+	for i := 0; i < updateRetries; i++ {
+		user, err = h.userRepo.Get(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		change(user)
+		err = h.userRepo.Update(ctx, user)
+		if !errors.Is(err, wrap.ConflictError{}) {
+			break
+		}
+		log.Printf("user %d changed concurrently, retry %d", userID, i+1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
 }
 
-func (h *handler) onStart(c tele.Context) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	userID := uint64(c.Message().Sender.ID)
+func (h *handler) onStart(ctx context.Context, c tele.Context) error {
+	userID := uint64(c.Sender().ID)
 	user, err := h.userRepo.Get(ctx, userID)
 	if err != nil && !errors.Is(err, wrap.NotFoundError{}) {
 		return err
 	}
-	if err == nil && user.State != "register" { // Reset state
-		// TODO process payload
-		user.State = ""
-		if err := h.userRepo.Upsert(ctx, user); err != nil {
+	if err == nil { // Reset state
+		if err := h.unblock(ctx, c, user); err != nil {
+			return err
+		}
+		if err := h.fsm.Reset(ctx, c, fsm.Idle); err != nil {
+			return err
+		}
+		if err := h.processPayload(ctx, c, false); err != nil {
 			return err
 		}
 		return c.Send("Бот переинициализирован")
 	}
-	user = &model.User{
-		UserID: userID,
-		State:  "register.name",
-	}
-	if err := h.userRepo.Insert(ctx, user); err != nil {
-		return err
-	}
+	err = h.tx.InTx(ctx, func(ctx context.Context) error {
+		user := &model.User{
+			UserID: userID,
+			Role:   uint8(h.newUserRole(userID)),
+			State:  stateRegisterName,
+		}
+		if err := h.userRepo.Insert(ctx, user); err != nil {
+			return err
+		}
 
-	profile := &model.Profile{
-		UserID: userID,
-		Source: c.Message().Payload,
-	}
-	if err := h.profileRepo.Upsert(ctx, profile); err != nil {
+		profile := &model.Profile{
+			UserID: userID,
+			Source: c.Message().Payload,
+		}
+		if err := h.profileRepo.Upsert(ctx, profile); err != nil {
+			return err
+		}
+
+		tgProfile := &model.TelegramProfile{
+			UserID:       userID,
+			Username:     c.Sender().Username,
+			FirstName:    c.Sender().FirstName,
+			LastName:     c.Sender().LastName,
+			LanguageCode: c.Sender().LanguageCode,
+		}
+		return h.telegramProfileRepo.Upsert(ctx, tgProfile)
+	})
+	if err != nil {
 		return err
 	}
 
-	tgProfile := &model.TelegramProfile{
-		UserID:       userID,
-		Username:     c.Sender().Username,
-		FirstName:    c.Sender().FirstName,
-		LastName:     c.Sender().LastName,
-		LanguageCode: c.Sender().LanguageCode,
-	}
-	if err := h.telegramProfileRepo.Upsert(ctx, tgProfile); err != nil {
+	if err := h.processPayload(ctx, c, true); err != nil {
 		return err
 	}
-
-	return c.Send("Тебя приветствует *бот Фейловер Бара*. 🤗 Давай знакомиться!\n\n*Как тебя зовут?*")
+	h.fsm.Entered(c, stateRegisterName)
+	return nil
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/failoverbar/bot/migrations"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const migrateUsage = "usage: bot migrate status|up|down|baseline <version>"
+
+// runMigrate implements "migrate" subcommand.
+func runMigrate(ctx context.Context, m *migrations.Migrator, args []string) error {
+	if len(args) == 2 && args[0] == "baseline" {
+		version, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			return errors.New(migrateUsage)
+		}
+		done, err := m.Baseline(ctx, uint32(version))
+		for _, mig := range done {
+			fmt.Printf("%s\tmarked as applied\n", mig.Name)
+		}
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+	switch args[0] {
+	case "status":
+		ss, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range ss {
+			if s.Applied {
+				fmt.Printf("%s\tapplied at %s\n", s.Name, s.AppliedAt.Format(time.RFC3339))
+			} else {
+				fmt.Printf("%s\tpending\n", s.Name)
+			}
+		}
+		return nil
+	case "up":
+		done, err := m.Up(ctx)
+		for _, mig := range done {
+			fmt.Printf("%s\tapplied\n", mig.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return err
+	case "down":
+		mig, err := m.Down(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\treverted\n", mig.Name)
+		return nil
+	default:
+		return errors.New(migrateUsage)
+	}
+}
+
+// checkSchema fails if some migrations are not applied. It doesn't change the database.
+func checkSchema(ctx context.Context, m *migrations.Migrator) error {
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(pending))
+	for _, mig := range pending {
+		names = append(names, mig.Name)
+	}
+	return fmt.Errorf("schema is behind, run \"bot migrate up\" to apply: %s", strings.Join(names, ", "))
+}
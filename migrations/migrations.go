@@ -0,0 +1,127 @@
+// Package migrations applies versioned schema changes stored in *.yql files for YDB
+// and in postgres/*.sql files for PostgreSQL.
+//
+// File name starts with a version number: 00_init.yql, 01_some_change.yql.
+// Statements after "-- +migrate up" line are applied by Up and statements after
+// "-- +migrate down" line revert them. Migrations changing data rather than schema start with
+// "-- +migrate data" line, YDB runs them as data queries in a transaction.
+package migrations
+
+import (
+	"bufio"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed *.yql postgres/*.sql
+var files embed.FS
+
+const (
+	upMarker   = "-- +migrate up"
+	downMarker = "-- +migrate down"
+	dataMarker = "-- +migrate data"
+)
+
+type Migration struct {
+	Version uint32
+	Name    string
+	Up      string
+	Down    string
+	// Data is true for migrations of data, see dataMarker.
+	Data bool
+}
+
+// Load reads YDB migrations embedded into the binary.
+func Load() ([]Migration, error) {
+	return LoadFS(files, "*.yql")
+}
+
+// LoadPostgres reads PostgreSQL migrations embedded into the binary.
+func LoadPostgres() ([]Migration, error) {
+	return LoadFS(files, "postgres/*.sql")
+}
+
+// LoadFS reads migrations matching pattern from fsys ordered by version.
+func LoadFS(fsys fs.FS, pattern string) (ms []Migration, err error) {
+	names, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[uint32]string{}
+	for _, name := range names {
+		m, err := parse(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", name, err)
+		}
+		if prev, ok := seen[m.Version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version %d", prev, name, m.Version)
+		}
+		seen[m.Version] = name
+		ms = append(ms, m)
+	}
+	sort.Slice(ms, func(i, j int) bool {
+		return ms[i].Version < ms[j].Version
+	})
+	return ms, nil
+}
+
+func parse(fsys fs.FS, name string) (m Migration, err error) {
+	base := path.Base(name)
+	m.Name = strings.TrimSuffix(base, path.Ext(base))
+	prefix, _, _ := strings.Cut(m.Name, "_")
+	version, err := strconv.ParseUint(prefix, 10, 32)
+	if err != nil {
+		return m, fmt.Errorf("name must start with version number: %w", err)
+	}
+	m.Version = uint32(version)
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		return m, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	var up, down strings.Builder
+	var cur *strings.Builder
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case upMarker:
+			cur = &up
+			continue
+		case downMarker:
+			cur = &down
+			continue
+		case dataMarker:
+			if cur != nil {
+				return m, fmt.Errorf("%q after %q", dataMarker, upMarker)
+			}
+			m.Data = true
+			continue
+		}
+		if cur == nil {
+			if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "--") {
+				continue
+			}
+			return m, fmt.Errorf("statement before %q", upMarker)
+		}
+		cur.WriteString(line)
+		cur.WriteString("\n")
+	}
+	if err = scanner.Err(); err != nil {
+		return m, err
+	}
+	m.Up = strings.TrimSpace(up.String())
+	m.Down = strings.TrimSpace(down.String())
+	if m.Up == "" {
+		return m, fmt.Errorf("no statements after %q", upMarker)
+	}
+	return m, nil
+}
@@ -0,0 +1,86 @@
+package migrations
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	ms, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pms, err := LoadPostgres()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) == 0 {
+		t.Fatal("no embedded migrations")
+	}
+	if len(pms) != len(ms) {
+		t.Error("YDB and PostgreSQL migrations differ", len(ms), len(pms))
+	}
+	for _, set := range [][]Migration{ms, pms} {
+		for i, m := range set {
+			if i > 0 && set[i-1].Version >= m.Version {
+				t.Error("migrations are not ordered", set[i-1].Name, m.Name)
+			}
+			if m.Down == "" {
+				t.Error("migration has no down section", m.Name)
+			}
+		}
+	}
+}
+
+func TestLoadFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"10_second.yql": {Data: []byte("-- +migrate up\nCREATE TABLE b (id Uint64, PRIMARY KEY (id));\n")},
+		"02_first.yql": {Data: []byte("-- comment\n-- +migrate up\nCREATE TABLE a (id Uint64, PRIMARY KEY (id));\n" +
+			"-- +migrate down\nDROP TABLE a;\n")},
+	}
+	ms, err := LoadFS(fsys, "*.yql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 2 || ms[0].Version != 2 || ms[1].Version != 10 {
+		t.Fatal("wrong migrations", ms)
+	}
+	if ms[0].Name != "02_first" || !strings.HasPrefix(ms[0].Up, "CREATE TABLE a") || ms[0].Down != "DROP TABLE a;" {
+		t.Error("wrong parsed migration", ms[0])
+	}
+	if ms[1].Down != "" {
+		t.Error("unexpected down section", ms[1])
+	}
+	if ms[0].Data || ms[1].Data {
+		t.Error("schema migration is parsed as data one")
+	}
+
+	ms, err = LoadFS(fstest.MapFS{
+		"03_backfill.yql": {Data: []byte("-- +migrate data\n-- +migrate up\nUPDATE a SET b = 1;\n")},
+	}, "*.yql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ms[0].Data || ms[0].Up != "UPDATE a SET b = 1;" {
+		t.Error("wrong data migration", ms[0])
+	}
+}
+
+func TestLoadFSErrors(t *testing.T) {
+	cases := map[string]fstest.MapFS{
+		"no version": {"init.yql": {Data: []byte("-- +migrate up\nSELECT 1;\n")}},
+		"no up":      {"01_a.yql": {Data: []byte("-- +migrate down\nSELECT 1;\n")}},
+		"no marker":  {"01_a.yql": {Data: []byte("SELECT 1;\n")}},
+		"late data":  {"01_a.yql": {Data: []byte("-- +migrate up\n-- +migrate data\nSELECT 1;\n")}},
+		"same version": {
+			"01_a.yql": {Data: []byte("-- +migrate up\nSELECT 1;\n")},
+			"1_b.yql":  {Data: []byte("-- +migrate up\nSELECT 1;\n")},
+		},
+	}
+	for name, fsys := range cases {
+		if _, err := LoadFS(fsys, "*.yql"); err == nil {
+			t.Error("error expected:", name)
+		}
+	}
+}
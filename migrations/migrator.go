@@ -0,0 +1,148 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/failoverbar/bot/wrap"
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"time"
+)
+
+// historyTable keeps versions of applied migrations.
+const historyTable = "schema_migrations"
+
+// ErrNoMigrations is returned by Down when there is nothing to revert.
+var ErrNoMigrations = errors.New("no applied migrations")
+
+// Driver applies migrations to a particular database and keeps track of applied versions.
+type Driver interface {
+	// Applied returns applied versions with time of application. It doesn't change the database,
+	// so nothing is applied if the table of versions doesn't exist yet.
+	Applied(ctx context.Context) (map[uint32]time.Time, error)
+	Apply(ctx context.Context, m Migration) error
+	Revert(ctx context.Context, m Migration) error
+	// Mark records the migration as applied without running it.
+	Mark(ctx context.Context, m Migration) error
+}
+
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type Migrator struct {
+	Driver     Driver
+	Migrations []Migration
+}
+
+// New creates migrator of YDB for migrations embedded into the binary.
+func New(db ydb.Connection) (*Migrator, error) {
+	ms, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{Driver: &YDBDriver{DB: db}, Migrations: ms}, nil
+}
+
+// NewPostgres creates migrator of PostgreSQL for migrations embedded into the binary.
+func NewPostgres(db *sql.DB) (*Migrator, error) {
+	ms, err := LoadPostgres()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{Driver: &PostgresDriver{DB: db}, Migrations: ms}, nil
+}
+
+// Status lists known migrations and whether they are applied.
+func (m *Migrator) Status(ctx context.Context) (ss []Status, err error) {
+	defer wrap.Err("migrations status", &err)
+	applied, err := m.Driver.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, mig := range m.Migrations {
+		at, ok := applied[mig.Version]
+		ss = append(ss, Status{Migration: mig, Applied: ok, AppliedAt: at})
+	}
+	return ss, nil
+}
+
+// Pending returns migrations which are not applied yet.
+func (m *Migrator) Pending(ctx context.Context) (ms []Migration, err error) {
+	ss, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range ss {
+		if !s.Applied {
+			ms = append(ms, s.Migration)
+		}
+	}
+	return ms, nil
+}
+
+// Up applies all pending migrations in order.
+func (m *Migrator) Up(ctx context.Context) (done []Migration, err error) {
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, mig := range pending {
+		if err = m.Driver.Apply(ctx, mig); err != nil {
+			return done, fmt.Errorf("apply migration %s: %w", mig.Name, err)
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// Baseline marks pending migrations up to version as applied without running them.
+// It adopts a database whose schema was created before migrations were tracked.
+func (m *Migrator) Baseline(ctx context.Context, version uint32) (done []Migration, err error) {
+	known := false
+	for _, mig := range m.Migrations {
+		known = known || mig.Version == version
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown migration version %d", version)
+	}
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, mig := range pending {
+		if mig.Version > version {
+			break
+		}
+		if err = m.Driver.Mark(ctx, mig); err != nil {
+			return done, fmt.Errorf("mark migration %s: %w", mig.Name, err)
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// Down reverts the last applied migration.
+func (m *Migrator) Down(ctx context.Context) (mig Migration, err error) {
+	ss, err := m.Status(ctx)
+	if err != nil {
+		return mig, err
+	}
+	for i := len(ss) - 1; i >= 0; i-- {
+		if !ss[i].Applied {
+			continue
+		}
+		mig = ss[i].Migration
+		if mig.Down == "" {
+			return mig, fmt.Errorf("migration %s is irreversible", mig.Name)
+		}
+		if err = m.Driver.Revert(ctx, mig); err != nil {
+			return mig, fmt.Errorf("revert migration %s: %w", mig.Name, err)
+		}
+		return mig, nil
+	}
+	return mig, ErrNoMigrations
+}
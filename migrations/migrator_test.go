@@ -0,0 +1,65 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// memoryDriver records versions without running migrations.
+type memoryDriver struct {
+	applied map[uint32]time.Time
+	ran     []string
+}
+
+func (d *memoryDriver) Applied(context.Context) (map[uint32]time.Time, error) {
+	applied := map[uint32]time.Time{}
+	for v, at := range d.applied {
+		applied[v] = at
+	}
+	return applied, nil
+}
+
+func (d *memoryDriver) Apply(ctx context.Context, m Migration) error {
+	d.ran = append(d.ran, m.Name)
+	return d.Mark(ctx, m)
+}
+
+func (d *memoryDriver) Revert(_ context.Context, m Migration) error {
+	delete(d.applied, m.Version)
+	return nil
+}
+
+func (d *memoryDriver) Mark(_ context.Context, m Migration) error {
+	if d.applied == nil {
+		d.applied = map[uint32]time.Time{}
+	}
+	d.applied[m.Version] = time.Now()
+	return nil
+}
+
+func TestBaseline(t *testing.T) {
+	ctx := context.Background()
+	d := &memoryDriver{}
+	m := &Migrator{Driver: d, Migrations: []Migration{
+		{Version: 0, Name: "00_init"},
+		{Version: 1, Name: "01_index"},
+		{Version: 2, Name: "02_history"},
+	}}
+	if _, err := m.Baseline(ctx, 5); err == nil {
+		t.Error("unknown version is accepted")
+	}
+	done, err := m.Baseline(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 2 || len(d.ran) != 0 {
+		t.Error("wrong baseline", done, d.ran)
+	}
+	if _, err = m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.ran) != 1 || d.ran[0] != "02_history" {
+		t.Error("wrong migrations applied after baseline", d.ran)
+	}
+}
@@ -0,0 +1,98 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"github.com/failoverbar/bot/wrap"
+	"time"
+)
+
+// PostgresDriver applies every migration together with its bookkeeping in one transaction.
+type PostgresDriver struct {
+	DB *sql.DB
+}
+
+func (d *PostgresDriver) Apply(ctx context.Context, mig Migration) error {
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, mig.Up); err != nil {
+			return err
+		}
+		return d.mark(ctx, tx, mig)
+	})
+}
+
+func (d *PostgresDriver) Mark(ctx context.Context, mig Migration) error {
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		return d.mark(ctx, tx, mig)
+	})
+}
+
+func (d *PostgresDriver) mark(ctx context.Context, tx *sql.Tx, mig Migration) error {
+	if err := d.createHistoryTable(ctx, tx); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO `+historyTable+` (version, name, applied_at) VALUES ($1, $2, $3)`,
+		int64(mig.Version), mig.Name, time.Now(),
+	)
+	return err
+}
+
+func (d *PostgresDriver) Revert(ctx context.Context, mig Migration) error {
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, mig.Down); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM `+historyTable+` WHERE version = $1`, int64(mig.Version))
+		return err
+	})
+}
+
+func (d *PostgresDriver) Applied(ctx context.Context) (applied map[uint32]time.Time, err error) {
+	var exists bool
+	err = d.DB.QueryRowContext(ctx, `SELECT EXISTS (
+		SELECT 1 FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1
+	)`, historyTable).Scan(&exists)
+	if err != nil || !exists {
+		return map[uint32]time.Time{}, err
+	}
+	rows, err := d.DB.QueryContext(ctx, `SELECT version, applied_at FROM `+historyTable)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	applied = map[uint32]time.Time{}
+	for rows.Next() {
+		var version uint32
+		var at time.Time
+		if err = rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+func (d *PostgresDriver) createHistoryTable(ctx context.Context, tx *sql.Tx) (err error) {
+	defer wrap.Err("create migrations table", &err)
+	_, err = tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+historyTable+` (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL
+	)`)
+	return err
+}
+
+func (d *PostgresDriver) inTx(ctx context.Context, f func(tx *sql.Tx) error) error {
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = f(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,48 @@
+-- +migrate up
+CREATE TABLE users (
+    user_id BIGINT PRIMARY KEY,
+    role SMALLINT NOT NULL DEFAULT 0,
+
+    state TEXT NOT NULL DEFAULT '',
+    context TEXT NOT NULL DEFAULT '',
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_action TIMESTAMPTZ NOT NULL DEFAULT now(),
+    version BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE telegram_profiles (
+    user_id BIGINT PRIMARY KEY,
+
+    username TEXT NOT NULL DEFAULT '',
+    first_name TEXT NOT NULL DEFAULT '',
+    last_name TEXT NOT NULL DEFAULT '',
+    language_code TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE profiles (
+    user_id BIGINT PRIMARY KEY,
+
+    name TEXT,
+    phone TEXT,
+    email TEXT,
+    source TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE subscriptions (
+    user_id BIGINT NOT NULL,
+    topic TEXT NOT NULL,
+
+    active BOOLEAN NOT NULL DEFAULT FALSE,
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_action TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY (user_id, topic)
+);
+
+-- +migrate down
+DROP TABLE subscriptions;
+DROP TABLE profiles;
+DROP TABLE telegram_profiles;
+DROP TABLE users;
@@ -0,0 +1,5 @@
+-- +migrate up
+CREATE INDEX subscriptions_topic_active ON subscriptions (topic, active, user_id);
+
+-- +migrate down
+DROP INDEX subscriptions_topic_active;
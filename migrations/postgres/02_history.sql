@@ -0,0 +1,18 @@
+-- +migrate up
+CREATE TABLE history (
+    user_id BIGINT NOT NULL,
+    id BIGINT NOT NULL,
+
+    entity TEXT NOT NULL DEFAULT '',
+    field TEXT NOT NULL DEFAULT '',
+    old_value TEXT,
+    new_value TEXT,
+
+    actor TEXT NOT NULL DEFAULT '',
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY (user_id, id)
+);
+
+-- +migrate down
+DROP TABLE history;
@@ -0,0 +1,5 @@
+-- +migrate up
+ALTER TABLE profiles ADD COLUMN in_it BOOLEAN, ADD COLUMN it_role TEXT;
+
+-- +migrate down
+ALTER TABLE profiles DROP COLUMN in_it, DROP COLUMN it_role;
@@ -0,0 +1,5 @@
+-- +migrate up
+ALTER TABLE profiles ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +migrate down
+ALTER TABLE profiles DROP COLUMN email_verified;
@@ -0,0 +1,17 @@
+-- +migrate up
+CREATE TABLE attributions (
+    user_id BIGINT NOT NULL,
+    id BIGINT NOT NULL,
+
+    payload TEXT NOT NULL DEFAULT '',
+    kind TEXT NOT NULL DEFAULT '',
+    value TEXT NOT NULL DEFAULT '',
+    new_user BOOLEAN NOT NULL DEFAULT FALSE,
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY (user_id, id)
+);
+
+-- +migrate down
+DROP TABLE attributions;
@@ -0,0 +1,5 @@
+-- +migrate up
+ALTER TABLE profiles ADD COLUMN phone_source TEXT NOT NULL DEFAULT '';
+
+-- +migrate down
+ALTER TABLE profiles DROP COLUMN phone_source;
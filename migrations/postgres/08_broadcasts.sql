@@ -0,0 +1,23 @@
+-- +migrate up
+CREATE TABLE broadcasts (
+    id BIGINT NOT NULL,
+
+    author_id BIGINT NOT NULL DEFAULT 0,
+    topic TEXT NOT NULL DEFAULT '',
+    text TEXT NOT NULL DEFAULT '',
+    photo_id TEXT NOT NULL DEFAULT '',
+    buttons TEXT NOT NULL DEFAULT '',
+
+    status TEXT NOT NULL DEFAULT '',
+    page_cursor TEXT NOT NULL DEFAULT '',
+    delivered BIGINT NOT NULL DEFAULT 0,
+    failed BIGINT NOT NULL DEFAULT 0,
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY (id)
+);
+
+-- +migrate down
+DROP TABLE broadcasts;
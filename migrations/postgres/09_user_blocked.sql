@@ -0,0 +1,5 @@
+-- +migrate up
+ALTER TABLE users ADD COLUMN blocked_at TIMESTAMPTZ, ADD COLUMN block_reason TEXT NOT NULL DEFAULT '';
+
+-- +migrate down
+ALTER TABLE users DROP COLUMN blocked_at, DROP COLUMN block_reason;
@@ -0,0 +1,15 @@
+-- +migrate up
+CREATE TABLE bans (
+    user_id BIGINT NOT NULL,
+
+    reason TEXT NOT NULL DEFAULT '',
+    banned_until TIMESTAMPTZ,
+    banned_by BIGINT NOT NULL DEFAULT 0,
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY (user_id)
+);
+
+-- +migrate down
+DROP TABLE bans;
@@ -0,0 +1,13 @@
+-- +migrate up
+CREATE TABLE perks (
+    user_id BIGINT NOT NULL,
+    kind TEXT NOT NULL,
+
+    issued_by BIGINT NOT NULL DEFAULT 0,
+    issued_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY (user_id, kind)
+);
+
+-- +migrate down
+DROP TABLE perks;
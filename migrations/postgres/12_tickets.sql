@@ -0,0 +1,27 @@
+-- +migrate up
+CREATE TABLE tickets (
+    user_id BIGINT NOT NULL,
+    id BIGINT NOT NULL,
+
+    status TEXT NOT NULL DEFAULT '',
+    thread_id BIGINT NOT NULL DEFAULT 0,
+    closed_by BIGINT NOT NULL DEFAULT 0,
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY (user_id, id)
+);
+
+CREATE TABLE ticket_messages (
+    message_id BIGINT NOT NULL,
+
+    user_id BIGINT NOT NULL,
+    ticket_id BIGINT NOT NULL,
+
+    PRIMARY KEY (message_id)
+);
+
+-- +migrate down
+DROP TABLE ticket_messages;
+DROP TABLE tickets;
@@ -0,0 +1,12 @@
+-- +migrate data
+-- Users who finished registration before roles appeared are still guests, make them regulars.
+-- Version is bumped, so that a running bot doesn't overwrite the role with a stale copy.
+-- +migrate up
+UPDATE users SET role = 1, version = users.version + 1
+FROM profiles AS p
+WHERE users.user_id = p.user_id AND users.role = 0 AND users.state NOT LIKE 'register.%'
+    AND p.name IS NOT NULL AND p.phone IS NOT NULL;
+
+-- +migrate down
+-- Regulars made by the migration can't be told from the others, so roles are kept.
+SELECT 1;
@@ -0,0 +1,149 @@
+package migrations
+
+import (
+	"context"
+	"github.com/failoverbar/bot/wrap"
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/options"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/result"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/result/named"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/types"
+	"path"
+	"time"
+)
+
+var writeTx = table.TxControl(
+	table.BeginTx(
+		table.WithSerializableReadWrite(),
+	),
+	table.CommitTx(),
+)
+
+// YDBDriver applies migrations with scheme queries, or data queries if Migration.Data is set.
+// YDB schema changes are not transactional, so the version is recorded after the migration succeeds.
+type YDBDriver struct {
+	DB ydb.Connection
+}
+
+func (d *YDBDriver) Apply(ctx context.Context, mig Migration) (err error) {
+	if err = d.exec(ctx, mig, mig.Up); err != nil {
+		return err
+	}
+	return d.Mark(ctx, mig)
+}
+
+func (d *YDBDriver) Mark(ctx context.Context, mig Migration) (err error) {
+	if err = d.createHistoryTable(ctx); err != nil {
+		return err
+	}
+	query := `
+		DECLARE $Version AS Uint32;
+		DECLARE $Name AS Utf8;
+		DECLARE $AppliedAt AS Datetime;
+		UPSERT INTO ` + historyTable + ` (version, name, applied_at) VALUES ($Version, $Name, $AppliedAt);
+`
+	return d.execData(ctx, query, table.NewQueryParameters(
+		table.ValueParam("$Version", types.Uint32Value(mig.Version)),
+		table.ValueParam("$Name", types.UTF8Value(mig.Name)),
+		table.ValueParam("$AppliedAt", types.DatetimeValueFromTime(time.Now())),
+	))
+}
+
+func (d *YDBDriver) Revert(ctx context.Context, mig Migration) (err error) {
+	if err = d.exec(ctx, mig, mig.Down); err != nil {
+		return err
+	}
+	query := `
+		DECLARE $Version AS Uint32;
+		DELETE FROM ` + historyTable + ` WHERE version = $Version;
+`
+	return d.execData(ctx, query, table.NewQueryParameters(
+		table.ValueParam("$Version", types.Uint32Value(mig.Version)),
+	))
+}
+
+func (d *YDBDriver) Applied(ctx context.Context) (applied map[uint32]time.Time, err error) {
+	exists, err := d.historyTableExists(ctx)
+	if err != nil || !exists {
+		return map[uint32]time.Time{}, err
+	}
+	query := `SELECT version, applied_at FROM ` + historyTable
+	var res result.Result
+	err = d.DB.Table().Do(ctx, func(ctx context.Context, s table.Session) (err error) {
+		_, res, err = s.Execute(ctx, table.DefaultTxControl(), query, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = res.Close()
+	}()
+	applied = map[uint32]time.Time{}
+	for res.NextResultSet(ctx) {
+		for res.NextRow() {
+			var version uint32
+			var at time.Time
+			err = res.ScanNamed(
+				named.OptionalWithDefault("version", &version),
+				named.OptionalWithDefault("applied_at", &at),
+			)
+			if err != nil {
+				return nil, err
+			}
+			applied[version] = at
+		}
+	}
+	return applied, res.Err()
+}
+
+func (d *YDBDriver) historyTableExists(ctx context.Context) (exists bool, err error) {
+	defer wrap.Err("describe migrations table", &err)
+	err = d.DB.Table().Do(ctx, func(ctx context.Context, s table.Session) (err error) {
+		_, err = s.DescribeTable(ctx, path.Join(d.DB.Name(), historyTable))
+		if ydb.IsOperationErrorSchemeError(err) {
+			return nil
+		}
+		exists = err == nil
+		return err
+	})
+	return exists, err
+}
+
+func (d *YDBDriver) createHistoryTable(ctx context.Context) (err error) {
+	exists, err := d.historyTableExists(ctx)
+	if err != nil || exists {
+		return err
+	}
+	defer wrap.Err("create migrations table", &err)
+	return d.DB.Table().Do(ctx, func(ctx context.Context, s table.Session) (err error) {
+		return s.CreateTable(ctx, path.Join(d.DB.Name(), historyTable),
+			options.WithColumn("version", types.Optional(types.TypeUint32)),
+			options.WithColumn("name", types.Optional(types.TypeUTF8)),
+			options.WithColumn("applied_at", types.Optional(types.TypeDatetime)),
+			options.WithPrimaryKeyColumn("version"),
+		)
+	})
+}
+
+// exec runs statements of the migration as scheme or data queries.
+func (d *YDBDriver) exec(ctx context.Context, mig Migration, query string) error {
+	if mig.Data {
+		return d.execData(ctx, query, nil)
+	}
+	return d.execScheme(ctx, query)
+}
+
+func (d *YDBDriver) execScheme(ctx context.Context, query string) error {
+	return d.DB.Table().Do(ctx, func(ctx context.Context, s table.Session) error {
+		return s.ExecuteSchemeQuery(ctx, query)
+	})
+}
+
+func (d *YDBDriver) execData(ctx context.Context, query string, params *table.QueryParameters) error {
+	return d.DB.Table().Do(ctx, func(ctx context.Context, s table.Session) (err error) {
+		_, _, err = s.Execute(ctx, writeTx, query, params)
+		return err
+	})
+}
@@ -0,0 +1,126 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"github.com/failoverbar/bot/wrap"
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"time"
+)
+
+// Attribution records a deep link the user came with to /start. Every touch is kept.
+type Attribution struct {
+	UserID uint64 `ydb:"user_id,primary"`
+	ID     uint64 `ydb:"id,primary"`
+
+	Payload string `ydb:"payload"`
+	// Kind and Value are the parsed payload, see package deeplink.
+	Kind  string `ydb:"kind"`
+	Value string `ydb:"value"`
+	// NewUser is set for the touch which created the user.
+	NewUser bool `ydb:"new_user"`
+
+	CreatedAt time.Time `ydb:"created_at"`
+}
+
+func (a *Attribution) BeforeInsert() {
+	if a.ID == 0 {
+		a.ID = nextID()
+	}
+	a.CreatedAt = time.Now()
+}
+
+type AttributionStorage interface {
+	Insert(ctx context.Context, a *Attribution) error
+	GetByUserID(ctx context.Context, userID uint64) ([]*Attribution, error)
+	DeleteByUserID(ctx context.Context, userID uint64) error
+}
+
+var (
+	_ AttributionStorage = (*AttributionRepo)(nil)
+	_ AttributionStorage = (*MemoryAttributionRepo)(nil)
+	_ AttributionStorage = (*PgAttributionRepo)(nil)
+)
+
+type AttributionRepo struct {
+	DB ydb.Connection
+}
+
+func (ur *AttributionRepo) table() *YDBTable[Attribution] {
+	return NewYDBTable[Attribution](ur.DB, "attributions")
+}
+
+func (ur *AttributionRepo) Insert(ctx context.Context, a *Attribution) (err error) {
+	defer wrap.Errf("insert attribution of %d", &err, a.UserID)
+	return ur.table().Insert(ctx, a)
+}
+
+func (ur *AttributionRepo) GetByUserID(ctx context.Context, userID uint64) (as []*Attribution, err error) {
+	defer wrap.Errf("get attributions by userID %d", &err, userID)
+	return ur.table().Select(ctx, userID)
+}
+
+func (ur *AttributionRepo) DeleteByUserID(ctx context.Context, userID uint64) (err error) {
+	defer wrap.Errf("delete attributions by userID %d", &err, userID)
+	return ur.table().Delete(ctx, userID)
+}
+
+func (ur *AttributionRepo) CreateTable(ctx context.Context) (err error) {
+	return ur.table().CreateTable(ctx)
+}
+
+type MemoryAttributionRepo struct {
+	DB *MemoryDB
+}
+
+func (ur *MemoryAttributionRepo) Insert(ctx context.Context, a *Attribution) error {
+	a.BeforeInsert()
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	stored := *a
+	stored.CreatedAt = datetime(a.CreatedAt)
+	ur.DB.attributions[a.UserID] = append(ur.DB.attributions[a.UserID], stored)
+	return nil
+}
+
+func (ur *MemoryAttributionRepo) GetByUserID(_ context.Context, userID uint64) ([]*Attribution, error) {
+	ur.DB.mu.RLock()
+	defer ur.DB.mu.RUnlock()
+	stored := ur.DB.attributions[userID]
+	as := make([]*Attribution, 0, len(stored))
+	for i := range stored {
+		a := stored[i]
+		as = append(as, &a)
+	}
+	return as, nil
+}
+
+func (ur *MemoryAttributionRepo) DeleteByUserID(ctx context.Context, userID uint64) error {
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	delete(ur.DB.attributions, userID)
+	return nil
+}
+
+type PgAttributionRepo struct {
+	DB *sql.DB
+}
+
+func (ur *PgAttributionRepo) table() *PgTable[Attribution] {
+	return NewPgTable[Attribution](ur.DB, "attributions")
+}
+
+func (ur *PgAttributionRepo) Insert(ctx context.Context, a *Attribution) (err error) {
+	defer wrap.Errf("insert attribution of %d", &err, a.UserID)
+	return ur.table().Insert(ctx, a)
+}
+
+func (ur *PgAttributionRepo) GetByUserID(ctx context.Context, userID uint64) (as []*Attribution, err error) {
+	defer wrap.Errf("get attributions by userID %d", &err, userID)
+	return ur.table().Select(ctx, userID)
+}
+
+func (ur *PgAttributionRepo) DeleteByUserID(ctx context.Context, userID uint64) (err error) {
+	defer wrap.Errf("delete attributions by userID %d", &err, userID)
+	return ur.table().Delete(ctx, userID)
+}
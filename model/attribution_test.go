@@ -0,0 +1,51 @@
+package model
+
+import (
+	"context"
+	"testing"
+)
+
+const attributionUserID = userID + 4
+
+func TestAttribution(t *testing.T) {
+	for name, s := range storages() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := createTable(ctx, s.Attributions); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Attributions.DeleteByUserID(ctx, attributionUserID); err != nil {
+				t.Fatal(err)
+			}
+
+			touches := []*Attribution{
+				{UserID: attributionUserID, Payload: "spring", Kind: "campaign", Value: "spring", NewUser: true},
+				{UserID: attributionUserID, Payload: "sub_events", Kind: "sub", Value: "events"},
+			}
+			for _, a := range touches {
+				if err := s.Attributions.Insert(ctx, a); err != nil {
+					t.Fatal(err)
+				}
+			}
+			as, err := s.Attributions.GetByUserID(ctx, attributionUserID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(as) != len(touches) {
+				t.Fatalf("got %d attributions, want %d", len(as), len(touches))
+			}
+			for i, a := range as {
+				if a.Payload != touches[i].Payload || a.NewUser != touches[i].NewUser || a.CreatedAt.IsZero() {
+					t.Errorf("attribution %d: got %+v, want %+v", i, a, touches[i])
+				}
+			}
+
+			if err = s.Attributions.DeleteByUserID(ctx, attributionUserID); err != nil {
+				t.Fatal(err)
+			}
+			if as, err = s.Attributions.GetByUserID(ctx, attributionUserID); err != nil || len(as) != 0 {
+				t.Error("attributions are not deleted", as, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"github.com/failoverbar/bot/wrap"
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"time"
+)
+
+// Ban stops handling of updates from the user. Bans are kept when the user asks to forget them.
+type Ban struct {
+	UserID uint64 `ydb:"user_id,primary"`
+
+	Reason string `ydb:"reason"`
+	// Until is the end of a temporary ban, permanent bans have it nil.
+	Until *time.Time `ydb:"banned_until"`
+	// BannedBy is the admin who banned the user, zero for bans made by the bot.
+	BannedBy uint64 `ydb:"banned_by"`
+
+	CreatedAt time.Time `ydb:"created_at"`
+}
+
+func (b *Ban) BeforeInsert() {
+	b.BeforeUpdate()
+}
+
+// BeforeUpdate makes CreatedAt the time of the last ban, since a ban may be extended.
+func (b *Ban) BeforeUpdate() {
+	b.CreatedAt = time.Now()
+}
+
+// Active tells whether the ban is in force at now.
+func (b *Ban) Active(now time.Time) bool {
+	return b.Until == nil || now.Before(*b.Until)
+}
+
+type BanStorage interface {
+	Get(ctx context.Context, userID uint64) (*Ban, error)
+	Upsert(ctx context.Context, b *Ban) error
+	Delete(ctx context.Context, userID uint64) error
+}
+
+var (
+	_ BanStorage = (*BanRepo)(nil)
+	_ BanStorage = (*MemoryBanRepo)(nil)
+	_ BanStorage = (*PgBanRepo)(nil)
+)
+
+type BanRepo struct {
+	DB ydb.Connection
+}
+
+func (ur *BanRepo) table() *YDBTable[Ban] {
+	return NewYDBTable[Ban](ur.DB, "bans")
+}
+
+func (ur *BanRepo) Get(ctx context.Context, userID uint64) (b *Ban, err error) {
+	defer wrap.Errf("get ban of %d", &err, userID)
+	return ur.table().Get(ctx, userID)
+}
+
+func (ur *BanRepo) Upsert(ctx context.Context, b *Ban) (err error) {
+	defer wrap.Errf("upsert ban of %d", &err, b.UserID)
+	return ur.table().Upsert(ctx, b)
+}
+
+func (ur *BanRepo) Delete(ctx context.Context, userID uint64) (err error) {
+	defer wrap.Errf("delete ban of %d", &err, userID)
+	return ur.table().Delete(ctx, userID)
+}
+
+func (ur *BanRepo) CreateTable(ctx context.Context) (err error) {
+	return ur.table().CreateTable(ctx)
+}
+
+type MemoryBanRepo struct {
+	DB *MemoryDB
+}
+
+func (ur *MemoryBanRepo) Get(_ context.Context, userID uint64) (b *Ban, err error) {
+	defer wrap.Errf("get ban of %d", &err, userID)
+	ur.DB.mu.RLock()
+	defer ur.DB.mu.RUnlock()
+	stored, ok := ur.DB.bans[userID]
+	if !ok {
+		return nil, wrap.NotFoundError{}
+	}
+	stored.Until = copyTime(stored.Until)
+	return &stored, nil
+}
+
+func (ur *MemoryBanRepo) Upsert(ctx context.Context, b *Ban) error {
+	b.BeforeUpdate()
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	stored := *b
+	stored.Until = copyTime(b.Until)
+	stored.CreatedAt = datetime(b.CreatedAt)
+	ur.DB.bans[b.UserID] = stored
+	return nil
+}
+
+func (ur *MemoryBanRepo) Delete(ctx context.Context, userID uint64) error {
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	delete(ur.DB.bans, userID)
+	return nil
+}
+
+type PgBanRepo struct {
+	DB *sql.DB
+}
+
+func (ur *PgBanRepo) table() *PgTable[Ban] {
+	return NewPgTable[Ban](ur.DB, "bans")
+}
+
+func (ur *PgBanRepo) Get(ctx context.Context, userID uint64) (b *Ban, err error) {
+	defer wrap.Errf("get ban of %d", &err, userID)
+	return ur.table().Get(ctx, userID)
+}
+
+func (ur *PgBanRepo) Upsert(ctx context.Context, b *Ban) (err error) {
+	defer wrap.Errf("upsert ban of %d", &err, b.UserID)
+	return ur.table().Upsert(ctx, b)
+}
+
+func (ur *PgBanRepo) Delete(ctx context.Context, userID uint64) (err error) {
+	defer wrap.Errf("delete ban of %d", &err, userID)
+	return ur.table().Delete(ctx, userID)
+}
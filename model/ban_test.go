@@ -0,0 +1,51 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"github.com/failoverbar/bot/wrap"
+	"testing"
+	"time"
+)
+
+const banUserID = userID + 6
+
+func TestBan(t *testing.T) {
+	for name, s := range storages() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := createTable(ctx, s.Bans); err != nil {
+				t.Fatal(err)
+			}
+			until := time.Now().Add(time.Hour)
+			if err := s.Bans.Upsert(ctx, &Ban{UserID: banUserID, Reason: "spam", Until: &until, BannedBy: userID}); err != nil {
+				t.Fatal(err)
+			}
+			b, err := s.Bans.Get(ctx, banUserID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.Reason != "spam" || b.Until == nil || b.Until.Unix() != until.Unix() || b.BannedBy != userID || b.CreatedAt.IsZero() {
+				t.Error("wrong ban", b)
+			}
+			if !b.Active(time.Now()) || b.Active(until.Add(time.Second)) {
+				t.Error("temporary ban is active at wrong time", b)
+			}
+
+			b.Until = nil
+			if err = s.Bans.Upsert(ctx, b); err != nil {
+				t.Fatal(err)
+			}
+			if b, err = s.Bans.Get(ctx, banUserID); err != nil || b.Until != nil || !b.Active(until.Add(time.Hour)) {
+				t.Error("ban is not permanent", b, err)
+			}
+
+			if err = s.Bans.Delete(ctx, banUserID); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = s.Bans.Get(ctx, banUserID); !errors.Is(err, wrap.NotFoundError{}) {
+				t.Error("not not_found error", err)
+			}
+		})
+	}
+}
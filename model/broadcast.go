@@ -0,0 +1,193 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"github.com/failoverbar/bot/wrap"
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"sort"
+	"time"
+)
+
+// Statuses of Broadcast.
+const (
+	BroadcastSending = "sending"
+	BroadcastDone    = "done"
+)
+
+// Broadcast is a message sent to active subscribers of a topic, see package broadcast.
+type Broadcast struct {
+	ID uint64 `ydb:"id,primary"`
+
+	AuthorID uint64 `ydb:"author_id"`
+	Topic    string `ydb:"topic"`
+	Text     string `ydb:"text"`
+	// PhotoID is Telegram file id of the photo, Text is its caption then.
+	PhotoID string `ydb:"photo_id"`
+	// Buttons are lines "title | url", see broadcast.ParseButtons.
+	Buttons string `ydb:"buttons"`
+
+	Status string `ydb:"status"`
+	// Cursor is the SubscriptionStorage.ListActiveByTopic cursor after the last handled subscriber.
+	Cursor    string `ydb:"page_cursor"`
+	Delivered uint64 `ydb:"delivered"`
+	Failed    uint64 `ydb:"failed"`
+
+	CreatedAt time.Time `ydb:"created_at"`
+	UpdatedAt time.Time `ydb:"updated_at"`
+}
+
+func (b *Broadcast) BeforeInsert() {
+	if b.ID == 0 {
+		b.ID = nextID()
+	}
+	b.CreatedAt = time.Now()
+	b.BeforeUpdate()
+}
+
+func (b *Broadcast) BeforeUpdate() {
+	b.UpdatedAt = time.Now()
+}
+
+type BroadcastStorage interface {
+	Get(ctx context.Context, id uint64) (*Broadcast, error)
+	Insert(ctx context.Context, b *Broadcast) error
+	Upsert(ctx context.Context, b *Broadcast) error
+	// ListByStatus returns broadcasts in status ordered by ID.
+	ListByStatus(ctx context.Context, status string) ([]*Broadcast, error)
+}
+
+var (
+	_ BroadcastStorage = (*BroadcastRepo)(nil)
+	_ BroadcastStorage = (*MemoryBroadcastRepo)(nil)
+	_ BroadcastStorage = (*PgBroadcastRepo)(nil)
+)
+
+// filterBroadcasts is used by ListByStatus, there are few broadcasts and they are scanned whole.
+func filterBroadcasts(bs []*Broadcast, status string) []*Broadcast {
+	res := bs[:0]
+	for _, b := range bs {
+		if b.Status == status {
+			res = append(res, b)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
+
+type BroadcastRepo struct {
+	DB ydb.Connection
+}
+
+func (ur *BroadcastRepo) table() *YDBTable[Broadcast] {
+	return NewYDBTable[Broadcast](ur.DB, "broadcasts")
+}
+
+func (ur *BroadcastRepo) Get(ctx context.Context, id uint64) (b *Broadcast, err error) {
+	defer wrap.Errf("get broadcast %d", &err, id)
+	return ur.table().Get(ctx, id)
+}
+
+func (ur *BroadcastRepo) Insert(ctx context.Context, b *Broadcast) (err error) {
+	defer wrap.Errf("insert broadcast %d", &err, b.ID)
+	return ur.table().Insert(ctx, b)
+}
+
+func (ur *BroadcastRepo) Upsert(ctx context.Context, b *Broadcast) (err error) {
+	defer wrap.Errf("upsert broadcast %d", &err, b.ID)
+	return ur.table().Upsert(ctx, b)
+}
+
+func (ur *BroadcastRepo) ListByStatus(ctx context.Context, status string) (bs []*Broadcast, err error) {
+	defer wrap.Errf("list %s broadcasts", &err, status)
+	bs, err = ur.table().Select(ctx)
+	return filterBroadcasts(bs, status), err
+}
+
+func (ur *BroadcastRepo) CreateTable(ctx context.Context) (err error) {
+	return ur.table().CreateTable(ctx)
+}
+
+type MemoryBroadcastRepo struct {
+	DB *MemoryDB
+}
+
+func (ur *MemoryBroadcastRepo) Get(_ context.Context, id uint64) (b *Broadcast, err error) {
+	defer wrap.Errf("get broadcast %d", &err, id)
+	ur.DB.mu.RLock()
+	defer ur.DB.mu.RUnlock()
+	stored, ok := ur.DB.broadcasts[id]
+	if !ok {
+		return nil, wrap.NotFoundError{}
+	}
+	return &stored, nil
+}
+
+func (ur *MemoryBroadcastRepo) Insert(ctx context.Context, b *Broadcast) (err error) {
+	defer wrap.Errf("insert broadcast %d", &err, b.ID)
+	b.BeforeInsert()
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	if _, ok := ur.DB.broadcasts[b.ID]; ok {
+		return wrap.AlreadyExistsError{}
+	}
+	ur.DB.broadcasts[b.ID] = ur.stored(b)
+	return nil
+}
+
+func (ur *MemoryBroadcastRepo) Upsert(ctx context.Context, b *Broadcast) error {
+	b.BeforeUpdate()
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	ur.DB.broadcasts[b.ID] = ur.stored(b)
+	return nil
+}
+
+func (ur *MemoryBroadcastRepo) ListByStatus(_ context.Context, status string) ([]*Broadcast, error) {
+	ur.DB.mu.RLock()
+	defer ur.DB.mu.RUnlock()
+	bs := make([]*Broadcast, 0, len(ur.DB.broadcasts))
+	for _, stored := range ur.DB.broadcasts {
+		b := stored
+		bs = append(bs, &b)
+	}
+	return filterBroadcasts(bs, status), nil
+}
+
+func (ur *MemoryBroadcastRepo) stored(b *Broadcast) Broadcast {
+	res := *b
+	res.CreatedAt = datetime(b.CreatedAt)
+	res.UpdatedAt = datetime(b.UpdatedAt)
+	return res
+}
+
+type PgBroadcastRepo struct {
+	DB *sql.DB
+}
+
+func (ur *PgBroadcastRepo) table() *PgTable[Broadcast] {
+	return NewPgTable[Broadcast](ur.DB, "broadcasts")
+}
+
+func (ur *PgBroadcastRepo) Get(ctx context.Context, id uint64) (b *Broadcast, err error) {
+	defer wrap.Errf("get broadcast %d", &err, id)
+	return ur.table().Get(ctx, id)
+}
+
+func (ur *PgBroadcastRepo) Insert(ctx context.Context, b *Broadcast) (err error) {
+	defer wrap.Errf("insert broadcast %d", &err, b.ID)
+	return ur.table().Insert(ctx, b)
+}
+
+func (ur *PgBroadcastRepo) Upsert(ctx context.Context, b *Broadcast) (err error) {
+	defer wrap.Errf("upsert broadcast %d", &err, b.ID)
+	return ur.table().Upsert(ctx, b)
+}
+
+func (ur *PgBroadcastRepo) ListByStatus(ctx context.Context, status string) (bs []*Broadcast, err error) {
+	defer wrap.Errf("list %s broadcasts", &err, status)
+	bs, err = ur.table().Select(ctx)
+	return filterBroadcasts(bs, status), err
+}
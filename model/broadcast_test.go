@@ -0,0 +1,65 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"github.com/failoverbar/bot/wrap"
+	"testing"
+)
+
+func TestBroadcast(t *testing.T) {
+	for name, s := range storages() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := createTable(ctx, s.Broadcasts); err != nil {
+				t.Fatal(err)
+			}
+			b := &Broadcast{AuthorID: userID, Topic: topic, Text: "hello", Status: BroadcastSending}
+			if err := s.Broadcasts.Insert(ctx, b); err != nil {
+				t.Fatal(err)
+			}
+			if b.ID == 0 || b.CreatedAt.IsZero() {
+				t.Error("onInsert failed", b)
+			}
+			if err := s.Broadcasts.Insert(ctx, b); !errors.Is(err, wrap.AlreadyExistsError{}) {
+				t.Error("duplicate broadcast is inserted", err)
+			}
+
+			sending, err := s.Broadcasts.ListByStatus(ctx, BroadcastSending)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !containsBroadcast(sending, b.ID) {
+				t.Error("sending broadcast is not listed", sending)
+			}
+
+			b.Cursor, b.Delivered, b.Failed = "42", 10, 1
+			b.Status = BroadcastDone
+			if err = s.Broadcasts.Upsert(ctx, b); err != nil {
+				t.Fatal(err)
+			}
+			got, err := s.Broadcasts.Get(ctx, b.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Cursor != "42" || got.Delivered != 10 || got.Failed != 1 || got.Text != "hello" {
+				t.Error("progress is not stored", got)
+			}
+			if sending, err = s.Broadcasts.ListByStatus(ctx, BroadcastSending); err != nil || containsBroadcast(sending, b.ID) {
+				t.Error("done broadcast is listed as sending", sending, err)
+			}
+			if _, err = s.Broadcasts.Get(ctx, b.ID+1); !errors.Is(err, wrap.NotFoundError{}) {
+				t.Error("not not_found error", err)
+			}
+		})
+	}
+}
+
+func containsBroadcast(bs []*Broadcast, id uint64) bool {
+	for _, b := range bs {
+		if b.ID == id {
+			return true
+		}
+	}
+	return false
+}
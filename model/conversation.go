@@ -0,0 +1,101 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// conversationVersion is the version of Conversation written to User.Context.
+// Bump it and add an upgrade to conversationUpgrades when the layout changes.
+const conversationVersion = 1
+
+// conversationUpgrades[v] converts raw payload of version v into version v+1.
+var conversationUpgrades = []func(raw []byte) ([]byte, error){
+	// Version 0 is a flat object of values without a version.
+	0: func(raw []byte) ([]byte, error) {
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &values); err != nil {
+			return nil, err
+		}
+		return json.Marshal(Conversation{Version: 1, Values: values})
+	},
+}
+
+// Conversation is scratch data of the dialog the user is in, e.g. answers to previous questions.
+// It is stored in User.Context as JSON and accessed with ConversationValue and SetConversationValue.
+type Conversation struct {
+	Version int                        `json:"version"`
+	Values  map[string]json.RawMessage `json:"values,omitempty"`
+}
+
+// Conversation decodes User.Context upgrading payloads written by previous versions.
+func (u *User) Conversation() (*Conversation, error) {
+	if u.Context == "" {
+		return &Conversation{Version: conversationVersion}, nil
+	}
+	raw := []byte(u.Context)
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil, fmt.Errorf("decode conversation of user %d: %w", u.UserID, err)
+	}
+	if header.Version > conversationVersion {
+		return nil, fmt.Errorf("conversation of user %d has unknown version %d", u.UserID, header.Version)
+	}
+	for v := header.Version; v < conversationVersion; v++ {
+		var err error
+		if raw, err = conversationUpgrades[v](raw); err != nil {
+			return nil, fmt.Errorf("upgrade conversation of user %d from version %d: %w", u.UserID, v, err)
+		}
+	}
+	c := &Conversation{}
+	if err := json.Unmarshal(raw, c); err != nil {
+		return nil, fmt.Errorf("decode conversation of user %d: %w", u.UserID, err)
+	}
+	return c, nil
+}
+
+// SetConversation encodes c into User.Context.
+func (u *User) SetConversation(c *Conversation) error {
+	c.Version = conversationVersion
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("encode conversation of user %d: %w", u.UserID, err)
+	}
+	u.Context = string(raw)
+	return nil
+}
+
+// ClearConversation drops scratch data when the dialog is over.
+func (u *User) ClearConversation() {
+	u.Context = ""
+}
+
+// ConversationValue decodes value of the key into T. ok is false if there is no such key.
+func ConversationValue[T any](c *Conversation, key string) (v T, ok bool, err error) {
+	raw, ok := c.Values[key]
+	if !ok {
+		return v, false, nil
+	}
+	if err = json.Unmarshal(raw, &v); err != nil {
+		return v, false, fmt.Errorf("decode conversation value %s: %w", key, err)
+	}
+	return v, true, nil
+}
+
+func SetConversationValue[T any](c *Conversation, key string, v T) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode conversation value %s: %w", key, err)
+	}
+	if c.Values == nil {
+		c.Values = map[string]json.RawMessage{}
+	}
+	c.Values[key] = raw
+	return nil
+}
+
+func (c *Conversation) Delete(key string) {
+	delete(c.Values, key)
+}
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"testing"
+)
+
+type conversationAnswer struct {
+	Role  string
+	Years int
+}
+
+func TestConversation(t *testing.T) {
+	u := &User{UserID: userID}
+	c, err := u.Conversation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = SetConversationValue(c, "answer", conversationAnswer{Role: "dev", Years: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.SetConversation(c); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = u.Conversation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, ok, err := ConversationValue[conversationAnswer](c, "answer")
+	if err != nil || !ok || a.Role != "dev" || a.Years != 3 {
+		t.Error("wrong value", a, ok, err)
+	}
+	if _, ok, err = ConversationValue[string](c, "missing"); ok || err != nil {
+		t.Error("missing value is found", err)
+	}
+	if _, _, err = ConversationValue[int](c, "answer"); err == nil {
+		t.Error("value of another type is decoded")
+	}
+
+	u.ClearConversation()
+	if c, err = u.Conversation(); err != nil || len(c.Values) != 0 {
+		t.Error("conversation is not cleared", c, err)
+	}
+}
+
+func TestConversationUpgrade(t *testing.T) {
+	u := &User{UserID: userID, Context: `{"name":"test"}`}
+	c, err := u.Conversation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Version != conversationVersion {
+		t.Error("version is not upgraded", c.Version)
+	}
+	if name, ok, err := ConversationValue[string](c, "name"); name != "test" || !ok || err != nil {
+		t.Error("value of version 0 is lost", name, ok, err)
+	}
+
+	u.Context = `{"version":1000}`
+	if _, err = u.Conversation(); err == nil {
+		t.Error("unknown version is decoded")
+	}
+}
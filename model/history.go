@@ -0,0 +1,524 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/failoverbar/bot/wrap"
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/types"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Change is an append-only record about a column changed through repositories.
+// Nil OldValue means the entity was created and nil NewValue means it was deleted.
+type Change struct {
+	UserID uint64 `ydb:"user_id,primary"`
+	// ID grows with time, so changes of a user are ordered chronologically by the primary key.
+	ID uint64 `ydb:"id,primary"`
+
+	Entity   string  `ydb:"entity"`
+	Field    string  `ydb:"field"`
+	OldValue *string `ydb:"old_value"`
+	NewValue *string `ydb:"new_value"`
+
+	Actor     string    `ydb:"actor"`
+	ChangedAt time.Time `ydb:"changed_at"`
+}
+
+type HistoryStorage interface {
+	Append(ctx context.Context, cs ...*Change) error
+	// Timeline returns up to limit latest changes of the user in chronological order.
+	Timeline(ctx context.Context, userID uint64, limit int) ([]*Change, error)
+	DeleteByUserID(ctx context.Context, userID uint64) error
+}
+
+var (
+	_ HistoryStorage = (*HistoryRepo)(nil)
+	_ HistoryStorage = (*MemoryHistoryRepo)(nil)
+	_ HistoryStorage = (*PgHistoryRepo)(nil)
+)
+
+// Entities of changes.
+const (
+	UserEntity            = "user"
+	ProfileEntity         = "profile"
+	TelegramProfileEntity = "telegram_profile"
+	// SubscriptionEntity is followed by the topic: "subscription:events".
+	SubscriptionEntity = "subscription"
+)
+
+// SystemActor makes changes when no actor is bound to the context.
+const SystemActor = "system"
+
+type actorKey struct{}
+
+// WithActor binds to ctx who makes changes, e.g. UserActor(userID).
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns actor bound to ctx or SystemActor.
+func ActorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok {
+		return actor
+	}
+	return SystemActor
+}
+
+func UserActor(userID uint64) string {
+	return "user:" + strconv.FormatUint(userID, 10)
+}
+
+var lastID uint64
+
+// nextID returns unique within the process id growing with time.
+func nextID() uint64 {
+	for {
+		last := atomic.LoadUint64(&lastID)
+		id := uint64(time.Now().UnixNano())
+		if id <= last {
+			id = last + 1
+		}
+		if atomic.CompareAndSwapUint64(&lastID, last, id) {
+			return id
+		}
+	}
+}
+
+// diff returns changes of columns between old and new versions of an entity. Any of them may be nil.
+func diff[T any](ctx context.Context, userID uint64, entity string, old, new *T) []*Change {
+	s := schemaOf[T]()
+	actor := ActorFrom(ctx)
+	now := time.Now()
+	var cs []*Change
+	for _, c := range s.columns {
+		if c.primary || c.noHistory {
+			continue
+		}
+		o, n := c.format(old), c.format(new)
+		if o == nil && n == nil || o != nil && n != nil && *o == *n {
+			continue
+		}
+		cs = append(cs, &Change{
+			UserID:    userID,
+			ID:        nextID(),
+			Entity:    entity,
+			Field:     c.name,
+			OldValue:  o,
+			NewValue:  n,
+			Actor:     actor,
+			ChangedAt: now,
+		})
+	}
+	return cs
+}
+
+// format returns value of the column in v as a string, nil for absent v and NULL values.
+func (c column) format(v interface{}) *string {
+	rv := reflect.ValueOf(v)
+	if rv.IsNil() {
+		return nil
+	}
+	f := rv.Elem().Field(c.field)
+	if c.nullable {
+		if f.IsNil() {
+			return nil
+		}
+		f = f.Elem()
+	}
+	var s string
+	if t, ok := f.Interface().(time.Time); ok {
+		s = t.Format(time.RFC3339)
+	} else {
+		s = fmt.Sprint(f.Interface())
+	}
+	return &s
+}
+
+// historyDecorator is implemented by repositories recording history into other ones.
+type historyDecorator interface {
+	unwrap() (repo interface{}, history HistoryStorage)
+}
+
+// undecorated returns the repository without history recording.
+func undecorated[T any](repo T) T {
+	if d, ok := interface{}(repo).(historyDecorator); ok {
+		inner, _ := d.unwrap()
+		return inner.(T)
+	}
+	return repo
+}
+
+// withHistory makes repositories of s record changes into s.History.
+func withHistory(s Storage) Storage {
+	s.Users = &historyUsers{UserStorage: s.Users, history: s.History, tx: s.Tx}
+	s.Profiles = &historyProfiles{ProfileStorage: s.Profiles, history: s.History, tx: s.Tx}
+	s.TelegramProfiles = &historyTelegramProfiles{TelegramProfileStorage: s.TelegramProfiles, history: s.History, tx: s.Tx}
+	s.Subscriptions = &historySubscriptions{SubscriptionStorage: s.Subscriptions, history: s.History, tx: s.Tx}
+	return s
+}
+
+// record writes changes between old and new versions of an entity.
+func record[T any](ctx context.Context, h HistoryStorage, userID uint64, entity string, old, new *T) error {
+	cs := diff(ctx, userID, entity, old, new)
+	if len(cs) == 0 {
+		return nil
+	}
+	return h.Append(ctx, cs...)
+}
+
+// previous returns the stored version of an entity or nil if there is none.
+func previous[T any](v *T, err error) (*T, error) {
+	if errors.Is(err, wrap.NotFoundError{}) {
+		return nil, nil
+	}
+	return v, err
+}
+
+type historyUsers struct {
+	UserStorage
+	history HistoryStorage
+	tx      Transactor
+}
+
+func (s *historyUsers) unwrap() (interface{}, HistoryStorage) {
+	return s.UserStorage, s.history
+}
+
+func (s *historyUsers) Insert(ctx context.Context, u *User) error {
+	return s.tx.InTx(ctx, func(ctx context.Context) error {
+		if err := s.UserStorage.Insert(ctx, u); err != nil {
+			return err
+		}
+		return record(ctx, s.history, u.UserID, UserEntity, nil, u)
+	})
+}
+
+func (s *historyUsers) Upsert(ctx context.Context, u *User) error {
+	return s.write(ctx, u, s.UserStorage.Upsert)
+}
+
+func (s *historyUsers) Update(ctx context.Context, u *User) error {
+	return s.write(ctx, u, s.UserStorage.Update)
+}
+
+func (s *historyUsers) write(ctx context.Context, u *User, write func(ctx context.Context, u *User) error) error {
+	return s.tx.InTx(ctx, func(ctx context.Context) error {
+		old, err := previous(s.UserStorage.Get(ctx, u.UserID))
+		if err != nil {
+			return err
+		}
+		if err = write(ctx, u); err != nil {
+			return err
+		}
+		return record(ctx, s.history, u.UserID, UserEntity, old, u)
+	})
+}
+
+func (s *historyUsers) Delete(ctx context.Context, userID uint64) error {
+	return s.tx.InTx(ctx, func(ctx context.Context) error {
+		old, err := previous(s.UserStorage.Get(ctx, userID))
+		if err != nil {
+			return err
+		}
+		if err = s.UserStorage.Delete(ctx, userID); err != nil {
+			return err
+		}
+		return record(ctx, s.history, userID, UserEntity, old, nil)
+	})
+}
+
+type historyProfiles struct {
+	ProfileStorage
+	history HistoryStorage
+	tx      Transactor
+}
+
+func (s *historyProfiles) unwrap() (interface{}, HistoryStorage) {
+	return s.ProfileStorage, s.history
+}
+
+func (s *historyProfiles) Insert(ctx context.Context, u *Profile) error {
+	return s.tx.InTx(ctx, func(ctx context.Context) error {
+		if err := s.ProfileStorage.Insert(ctx, u); err != nil {
+			return err
+		}
+		return record(ctx, s.history, u.UserID, ProfileEntity, nil, u)
+	})
+}
+
+func (s *historyProfiles) Upsert(ctx context.Context, u *Profile) error {
+	return s.tx.InTx(ctx, func(ctx context.Context) error {
+		old, err := previous(s.ProfileStorage.Get(ctx, u.UserID))
+		if err != nil {
+			return err
+		}
+		if err = s.ProfileStorage.Upsert(ctx, u); err != nil {
+			return err
+		}
+		return record(ctx, s.history, u.UserID, ProfileEntity, old, u)
+	})
+}
+
+func (s *historyProfiles) Delete(ctx context.Context, userID uint64) error {
+	return s.tx.InTx(ctx, func(ctx context.Context) error {
+		old, err := previous(s.ProfileStorage.Get(ctx, userID))
+		if err != nil {
+			return err
+		}
+		if err = s.ProfileStorage.Delete(ctx, userID); err != nil {
+			return err
+		}
+		return record(ctx, s.history, userID, ProfileEntity, old, nil)
+	})
+}
+
+type historyTelegramProfiles struct {
+	TelegramProfileStorage
+	history HistoryStorage
+	tx      Transactor
+}
+
+func (s *historyTelegramProfiles) unwrap() (interface{}, HistoryStorage) {
+	return s.TelegramProfileStorage, s.history
+}
+
+func (s *historyTelegramProfiles) Insert(ctx context.Context, u *TelegramProfile) error {
+	return s.tx.InTx(ctx, func(ctx context.Context) error {
+		if err := s.TelegramProfileStorage.Insert(ctx, u); err != nil {
+			return err
+		}
+		return record(ctx, s.history, u.UserID, TelegramProfileEntity, nil, u)
+	})
+}
+
+func (s *historyTelegramProfiles) Upsert(ctx context.Context, u *TelegramProfile) error {
+	return s.tx.InTx(ctx, func(ctx context.Context) error {
+		old, err := previous(s.TelegramProfileStorage.Get(ctx, u.UserID))
+		if err != nil {
+			return err
+		}
+		if err = s.TelegramProfileStorage.Upsert(ctx, u); err != nil {
+			return err
+		}
+		return record(ctx, s.history, u.UserID, TelegramProfileEntity, old, u)
+	})
+}
+
+func (s *historyTelegramProfiles) Delete(ctx context.Context, userID uint64) error {
+	return s.tx.InTx(ctx, func(ctx context.Context) error {
+		old, err := previous(s.TelegramProfileStorage.Get(ctx, userID))
+		if err != nil {
+			return err
+		}
+		if err = s.TelegramProfileStorage.Delete(ctx, userID); err != nil {
+			return err
+		}
+		return record(ctx, s.history, userID, TelegramProfileEntity, old, nil)
+	})
+}
+
+type historySubscriptions struct {
+	SubscriptionStorage
+	history HistoryStorage
+	tx      Transactor
+}
+
+func (s *historySubscriptions) unwrap() (interface{}, HistoryStorage) {
+	return s.SubscriptionStorage, s.history
+}
+
+func subscriptionEntity(topic string) string {
+	return SubscriptionEntity + ":" + topic
+}
+
+func (s *historySubscriptions) Insert(ctx context.Context, u *Subscription) error {
+	return s.tx.InTx(ctx, func(ctx context.Context) error {
+		if err := s.SubscriptionStorage.Insert(ctx, u); err != nil {
+			return err
+		}
+		return record(ctx, s.history, u.UserID, subscriptionEntity(u.Topic), nil, u)
+	})
+}
+
+func (s *historySubscriptions) Upsert(ctx context.Context, u *Subscription) error {
+	return s.tx.InTx(ctx, func(ctx context.Context) error {
+		old, err := previous(s.SubscriptionStorage.Get(ctx, u.UserID, u.Topic))
+		if err != nil {
+			return err
+		}
+		if err = s.SubscriptionStorage.Upsert(ctx, u); err != nil {
+			return err
+		}
+		return record(ctx, s.history, u.UserID, subscriptionEntity(u.Topic), old, u)
+	})
+}
+
+func (s *historySubscriptions) Delete(ctx context.Context, userID uint64, topic string) error {
+	return s.tx.InTx(ctx, func(ctx context.Context) error {
+		old, err := previous(s.SubscriptionStorage.Get(ctx, userID, topic))
+		if err != nil {
+			return err
+		}
+		if err = s.SubscriptionStorage.Delete(ctx, userID, topic); err != nil {
+			return err
+		}
+		return record(ctx, s.history, userID, subscriptionEntity(topic), old, nil)
+	})
+}
+
+func (s *historySubscriptions) DeleteByUserID(ctx context.Context, userID uint64) error {
+	return s.tx.InTx(ctx, func(ctx context.Context) error {
+		olds, err := s.SubscriptionStorage.GetByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if err = s.SubscriptionStorage.DeleteByUserID(ctx, userID); err != nil {
+			return err
+		}
+		for _, old := range olds {
+			if err = record(ctx, s.history, userID, subscriptionEntity(old.Topic), old, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+type HistoryRepo struct {
+	DB ydb.Connection
+}
+
+func (ur *HistoryRepo) table() *YDBTable[Change] {
+	return NewYDBTable[Change](ur.DB, "history")
+}
+
+func (ur *HistoryRepo) Append(ctx context.Context, cs ...*Change) (err error) {
+	defer wrap.Err("append history", &err)
+	t := ur.table()
+	return inTx(ctx, ur.DB, func(ctx context.Context) error {
+		for _, c := range cs {
+			if err := t.Insert(ctx, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (ur *HistoryRepo) Timeline(ctx context.Context, userID uint64, limit int) (cs []*Change, err error) {
+	defer wrap.Errf("get history of %d", &err, userID)
+	t := ur.table()
+	query := `
+		DECLARE $UserID AS Uint64;
+		DECLARE $Limit AS Uint64;
+		SELECT ` + t.fields(t.schema.columns) + ` FROM ` + t.Name + `
+		WHERE user_id = $UserID
+		ORDER BY user_id DESC, id DESC
+		LIMIT $Limit;
+`
+	cs, err = t.Query(ctx, query, table.NewQueryParameters(
+		table.ValueParam("$UserID", types.Uint64Value(userID)),
+		table.ValueParam("$Limit", types.Uint64Value(uint64(limit))),
+	))
+	reverseChanges(cs)
+	return cs, err
+}
+
+func (ur *HistoryRepo) DeleteByUserID(ctx context.Context, userID uint64) (err error) {
+	defer wrap.Errf("delete history of %d", &err, userID)
+	return ur.table().Delete(ctx, userID)
+}
+
+func (ur *HistoryRepo) CreateTable(ctx context.Context) (err error) {
+	return ur.table().CreateTable(ctx)
+}
+
+type MemoryHistoryRepo struct {
+	DB *MemoryDB
+}
+
+func (ur *MemoryHistoryRepo) Append(ctx context.Context, cs ...*Change) error {
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	for _, c := range cs {
+		stored := *c
+		stored.OldValue = copyString(c.OldValue)
+		stored.NewValue = copyString(c.NewValue)
+		stored.ChangedAt = datetime(c.ChangedAt)
+		ur.DB.history[c.UserID] = append(ur.DB.history[c.UserID], stored)
+	}
+	return nil
+}
+
+func (ur *MemoryHistoryRepo) Timeline(_ context.Context, userID uint64, limit int) ([]*Change, error) {
+	ur.DB.mu.RLock()
+	defer ur.DB.mu.RUnlock()
+	stored := ur.DB.history[userID]
+	if len(stored) > limit {
+		stored = stored[len(stored)-limit:]
+	}
+	cs := make([]*Change, 0, len(stored))
+	for i := range stored {
+		c := stored[i]
+		cs = append(cs, &c)
+	}
+	return cs, nil
+}
+
+func (ur *MemoryHistoryRepo) DeleteByUserID(ctx context.Context, userID uint64) error {
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	delete(ur.DB.history, userID)
+	return nil
+}
+
+type PgHistoryRepo struct {
+	DB *sql.DB
+}
+
+func (ur *PgHistoryRepo) table() *PgTable[Change] {
+	return NewPgTable[Change](ur.DB, "history")
+}
+
+func (ur *PgHistoryRepo) Append(ctx context.Context, cs ...*Change) (err error) {
+	defer wrap.Err("append history", &err)
+	t := ur.table()
+	return pgInTx(ctx, ur.DB, func(ctx context.Context) error {
+		for _, c := range cs {
+			if err := t.Insert(ctx, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (ur *PgHistoryRepo) Timeline(ctx context.Context, userID uint64, limit int) (cs []*Change, err error) {
+	defer wrap.Errf("get history of %d", &err, userID)
+	t := ur.table()
+	query := `SELECT ` + t.fields(t.schema.columns) + ` FROM ` + t.Name + `
+		WHERE user_id = $1
+		ORDER BY id DESC
+		LIMIT ` + strconv.Itoa(limit)
+	cs, err = t.Query(ctx, query, int64(userID))
+	reverseChanges(cs)
+	return cs, err
+}
+
+func (ur *PgHistoryRepo) DeleteByUserID(ctx context.Context, userID uint64) (err error) {
+	defer wrap.Errf("delete history of %d", &err, userID)
+	return ur.table().Delete(ctx, userID)
+}
+
+func reverseChanges(cs []*Change) {
+	for i, j := 0, len(cs)-1; i < j; i, j = i+1, j-1 {
+		cs[i], cs[j] = cs[j], cs[i]
+	}
+}
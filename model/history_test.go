@@ -0,0 +1,91 @@
+package model
+
+import (
+	"context"
+	"github.com/AlekSi/pointer"
+	"testing"
+)
+
+const historyUserID = userID + 2
+
+func TestHistory(t *testing.T) {
+	for name, s := range storages() {
+		t.Run(name, func(t *testing.T) {
+			ctx := WithActor(context.Background(), UserActor(historyUserID))
+			if err := createTable(ctx, s.Users); err != nil {
+				t.Fatal(err)
+			}
+			if err := createTable(ctx, s.Profiles); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.History.DeleteByUserID(ctx, historyUserID); err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = s.Users.Delete(ctx, historyUserID)
+				_ = s.Profiles.Delete(ctx, historyUserID)
+				_ = s.History.DeleteByUserID(ctx, historyUserID)
+			}()
+
+			u := &User{UserID: historyUserID, State: "register.name"}
+			if err := s.Users.Insert(ctx, u); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Profiles.Upsert(ctx, &Profile{UserID: historyUserID, Name: pointer.ToString("old")}); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Profiles.Upsert(ctx, &Profile{UserID: historyUserID, Name: pointer.ToString("new")}); err != nil {
+				t.Fatal(err)
+			}
+			u.State = ""
+			u.Context = "ignored"
+			if err := s.Users.Update(ctx, u); err != nil {
+				t.Fatal(err)
+			}
+
+			cs, err := s.History.Timeline(ctx, historyUserID, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []struct {
+				entity, field string
+				old, new      *string
+			}{
+				{UserEntity, "role", nil, pointer.ToString("0")},
+				{UserEntity, "state", nil, pointer.ToString("register.name")},
+				{UserEntity, "block_reason", nil, pointer.ToString("")},
+				{ProfileEntity, "name", nil, pointer.ToString("old")},
+				{ProfileEntity, "source", nil, pointer.ToString("")},
+				{ProfileEntity, "phone_source", nil, pointer.ToString("")},
+				{ProfileEntity, "email_verified", nil, pointer.ToString("false")},
+				{ProfileEntity, "name", pointer.ToString("old"), pointer.ToString("new")},
+				{UserEntity, "state", pointer.ToString("register.name"), pointer.ToString("")},
+			}
+			if len(cs) != len(want) {
+				for _, c := range cs {
+					t.Log(c.Entity, c.Field, pointer.GetString(c.OldValue), pointer.GetString(c.NewValue))
+				}
+				t.Fatalf("got %d changes, want %d", len(cs), len(want))
+			}
+			for i, w := range want {
+				c := cs[i]
+				if c.Entity != w.entity || c.Field != w.field ||
+					pointer.GetString(c.OldValue) != pointer.GetString(w.old) || (c.OldValue == nil) != (w.old == nil) ||
+					pointer.GetString(c.NewValue) != pointer.GetString(w.new) || (c.NewValue == nil) != (w.new == nil) {
+					t.Errorf("change %d: got %+v, want %+v", i, c, w)
+				}
+				if c.Actor != UserActor(historyUserID) {
+					t.Errorf("change %d: wrong actor %s", i, c.Actor)
+				}
+			}
+
+			last, err := s.History.Timeline(ctx, historyUserID, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(last) != 1 || last[0].ID != cs[len(cs)-1].ID {
+				t.Error("timeline limit must keep the latest changes", last)
+			}
+		})
+	}
+}
@@ -2,6 +2,9 @@ package model
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
+	"github.com/failoverbar/bot/migrations"
 	ydbEnviron "github.com/ydb-platform/ydb-go-sdk-auth-environ"
 	"github.com/ydb-platform/ydb-go-sdk/v3"
 	"log"
@@ -11,17 +14,89 @@ import (
 
 var db ydb.Connection
 
+var pgDB *sql.DB
+
 func TestMain(m *testing.M) {
 	ctx := context.Background()
-	dsn, ok := os.LookupEnv("YDB_DSN")
-	if !ok {
-		log.Fatal("Set env YDB_DSN and any cred from ydb-go-sdk-auth-environ")
+	if dsn, ok := os.LookupEnv("YDB_DSN"); ok {
+		var err error
+		db, err = ydb.Open(ctx, dsn, ydbEnviron.WithEnvironCredentials(ctx))
+		if err != nil {
+			log.Fatal("can't connect to DB", err)
+		}
+	} else {
+		log.Print("YDB_DSN is not set, YDB storage is not tested")
 	}
-	var err error
-	db, err = ydb.Open(ctx, dsn, ydbEnviron.WithEnvironCredentials(ctx))
-	if err != nil {
-		log.Fatal("can't connect to DB", err)
+
+	if dsn, ok := os.LookupEnv("POSTGRES_DSN"); ok {
+		var err error
+		pgDB, err = sql.Open("pgx", dsn)
+		if err != nil {
+			log.Fatal("can't connect to PostgreSQL (run tests with -tags postgres)", err)
+		}
+		mig, err := migrations.NewPostgres(pgDB)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, err = mig.Up(ctx); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		log.Print("POSTGRES_DSN is not set, PostgreSQL storage is not tested")
 	}
 
 	os.Exit(m.Run())
 }
+
+// storages returns every storage implementation the suite runs against.
+func storages() map[string]Storage {
+	res := map[string]Storage{
+		"memory": NewMemoryStorage(),
+	}
+	if db != nil {
+		res["ydb"] = NewYDBStorage(db)
+	}
+	if pgDB != nil {
+		res["postgres"] = NewPostgresStorage(pgDB)
+	}
+	return res
+}
+
+type tableCreator interface {
+	CreateTable(ctx context.Context) error
+}
+
+// createTable creates table for repos that need it, including history of decorated repos.
+func createTable(ctx context.Context, repo interface{}) error {
+	if d, ok := repo.(historyDecorator); ok {
+		inner, history := d.unwrap()
+		if err := createTable(ctx, history); err != nil {
+			return err
+		}
+		return createTable(ctx, inner)
+	}
+	if d, ok := repo.(topicsDecorator); ok {
+		inner, topics := d.unwrap()
+		if err := createTable(ctx, topics); err != nil {
+			return err
+		}
+		return createTable(ctx, inner)
+	}
+	if tc, ok := repo.(tableCreator); ok {
+		return tc.CreateTable(ctx)
+	}
+	return nil
+}
+
+// createTopics adds topics to the catalog, so that users can subscribe to them.
+func createTopics(ctx context.Context, s Storage, slugs ...string) error {
+	if err := createTable(ctx, s.Topics); err != nil {
+		return err
+	}
+	for _, slug := range slugs {
+		if err := s.Topics.Upsert(ctx, &Topic{Slug: slug, Title: fmt.Sprint("Topic ", slug)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,429 @@
+package model
+
+import (
+	"context"
+	"github.com/failoverbar/bot/wrap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryDB keeps all tables in process memory. It is used for tests and local runs without YDB.
+type MemoryDB struct {
+	mu sync.RWMutex
+	// txMu is held by the running transaction of MemoryTransactor, see lock.
+	txMu sync.Mutex
+
+	users            map[uint64]User
+	profiles         map[uint64]Profile
+	telegramProfiles map[uint64]TelegramProfile
+	subscriptions    map[subscriptionKey]Subscription
+	history          map[uint64][]Change
+	attributions     map[uint64][]Attribution
+	topics           map[string]Topic
+	broadcasts       map[uint64]Broadcast
+	bans             map[uint64]Ban
+	perks            map[uint64][]Perk
+	tickets          map[ticketKey]Ticket
+	ticketMessages   map[uint64]TicketMessage
+}
+
+type subscriptionKey struct {
+	userID uint64
+	topic  string
+}
+
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{
+		users:            map[uint64]User{},
+		profiles:         map[uint64]Profile{},
+		telegramProfiles: map[uint64]TelegramProfile{},
+		subscriptions:    map[subscriptionKey]Subscription{},
+		history:          map[uint64][]Change{},
+		attributions:     map[uint64][]Attribution{},
+		topics:           map[string]Topic{},
+		broadcasts:       map[uint64]Broadcast{},
+		bans:             map[uint64]Ban{},
+		perks:            map[uint64][]Perk{},
+		tickets:          map[ticketKey]Ticket{},
+		ticketMessages:   map[uint64]TicketMessage{},
+	}
+}
+
+// lock locks tables for a write. Writes outside of a transaction wait for the running one to end,
+// so that its rollback doesn't discard them.
+func (db *MemoryDB) lock(ctx context.Context) {
+	if ctx.Value(memoryTxKey{}) == nil {
+		db.txMu.Lock()
+	}
+	db.mu.Lock()
+}
+
+func (db *MemoryDB) unlock(ctx context.Context) {
+	db.mu.Unlock()
+	if ctx.Value(memoryTxKey{}) == nil {
+		db.txMu.Unlock()
+	}
+}
+
+func (db *MemoryDB) snapshot() *MemoryDB {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	res := NewMemoryDB()
+	for k, v := range db.users {
+		res.users[k] = v
+	}
+	for k, v := range db.profiles {
+		res.profiles[k] = v
+	}
+	for k, v := range db.telegramProfiles {
+		res.telegramProfiles[k] = v
+	}
+	for k, v := range db.subscriptions {
+		res.subscriptions[k] = v
+	}
+	for k, v := range db.history {
+		res.history[k] = append([]Change(nil), v...)
+	}
+	for k, v := range db.attributions {
+		res.attributions[k] = append([]Attribution(nil), v...)
+	}
+	for k, v := range db.topics {
+		res.topics[k] = v
+	}
+	for k, v := range db.broadcasts {
+		res.broadcasts[k] = v
+	}
+	for k, v := range db.bans {
+		res.bans[k] = v
+	}
+	for k, v := range db.perks {
+		res.perks[k] = append([]Perk(nil), v...)
+	}
+	for k, v := range db.tickets {
+		res.tickets[k] = v
+	}
+	for k, v := range db.ticketMessages {
+		res.ticketMessages[k] = v
+	}
+	return res
+}
+
+func (db *MemoryDB) restore(snapshot *MemoryDB) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.users = snapshot.users
+	db.profiles = snapshot.profiles
+	db.telegramProfiles = snapshot.telegramProfiles
+	db.subscriptions = snapshot.subscriptions
+	db.history = snapshot.history
+	db.attributions = snapshot.attributions
+	db.topics = snapshot.topics
+	db.broadcasts = snapshot.broadcasts
+	db.bans = snapshot.bans
+	db.perks = snapshot.perks
+	db.tickets = snapshot.tickets
+	db.ticketMessages = snapshot.ticketMessages
+}
+
+// datetime mimics precision of YDB Datetime columns.
+func datetime(t time.Time) time.Time {
+	return t.Truncate(time.Second)
+}
+
+func copyString(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	return &c
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := datetime(*t)
+	return &c
+}
+
+func copyBool(b *bool) *bool {
+	if b == nil {
+		return nil
+	}
+	c := *b
+	return &c
+}
+
+type MemoryUserRepo struct {
+	DB *MemoryDB
+}
+
+func (ur *MemoryUserRepo) Get(_ context.Context, userID uint64) (u *User, err error) {
+	defer wrap.Errf("get user %d", &err, userID)
+	ur.DB.mu.RLock()
+	defer ur.DB.mu.RUnlock()
+	stored, ok := ur.DB.users[userID]
+	if !ok {
+		return nil, wrap.NotFoundError{}
+	}
+	return &stored, nil
+}
+
+func (ur *MemoryUserRepo) Insert(ctx context.Context, u *User) (err error) {
+	defer wrap.Errf("insert user %d", &err, u.UserID)
+	u.BeforeInsert()
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	if _, ok := ur.DB.users[u.UserID]; ok {
+		return wrap.AlreadyExistsError{}
+	}
+	ur.DB.users[u.UserID] = ur.stored(u)
+	return nil
+}
+
+func (ur *MemoryUserRepo) Upsert(ctx context.Context, u *User) (err error) {
+	u.BeforeUpdate()
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	ur.DB.users[u.UserID] = ur.stored(u)
+	return nil
+}
+
+func (ur *MemoryUserRepo) Update(ctx context.Context, u *User) (err error) {
+	defer wrap.Errf("update user %d", &err, u.UserID)
+	u.BeforeUpdate()
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	stored, ok := ur.DB.users[u.UserID]
+	if !ok {
+		return wrap.NotFoundError{}
+	}
+	if stored.Version != u.Version {
+		return wrap.ConflictError{}
+	}
+	u.Version++
+	ur.DB.users[u.UserID] = ur.stored(u)
+	return nil
+}
+
+func (ur *MemoryUserRepo) Delete(ctx context.Context, userID uint64) (err error) {
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	delete(ur.DB.users, userID)
+	return nil
+}
+
+func (ur *MemoryUserRepo) stored(u *User) User {
+	res := *u
+	res.BlockedAt = copyTime(u.BlockedAt)
+	res.CreatedAt = datetime(res.CreatedAt)
+	res.LastAction = datetime(res.LastAction)
+	return res
+}
+
+type MemoryProfileRepo struct {
+	DB *MemoryDB
+}
+
+func (ur *MemoryProfileRepo) Get(_ context.Context, userID uint64) (u *Profile, err error) {
+	defer wrap.Errf("get profile %d", &err, userID)
+	ur.DB.mu.RLock()
+	defer ur.DB.mu.RUnlock()
+	stored, ok := ur.DB.profiles[userID]
+	if !ok {
+		return nil, wrap.NotFoundError{}
+	}
+	return ur.copy(&stored), nil
+}
+
+func (ur *MemoryProfileRepo) Insert(ctx context.Context, u *Profile) (err error) {
+	defer wrap.Errf("insert profile %d", &err, u.UserID)
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	if _, ok := ur.DB.profiles[u.UserID]; ok {
+		return wrap.AlreadyExistsError{}
+	}
+	ur.DB.profiles[u.UserID] = *ur.copy(u)
+	return nil
+}
+
+func (ur *MemoryProfileRepo) Upsert(ctx context.Context, u *Profile) (err error) {
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	ur.DB.profiles[u.UserID] = *ur.copy(u)
+	return nil
+}
+
+func (ur *MemoryProfileRepo) Delete(ctx context.Context, userID uint64) (err error) {
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	delete(ur.DB.profiles, userID)
+	return nil
+}
+
+func (ur *MemoryProfileRepo) copy(u *Profile) *Profile {
+	res := *u
+	res.Name = copyString(u.Name)
+	res.Phone = copyString(u.Phone)
+	res.Email = copyString(u.Email)
+	res.InIT = copyBool(u.InIT)
+	res.ITRole = copyString(u.ITRole)
+	return &res
+}
+
+type MemoryTelegramProfileRepo struct {
+	DB *MemoryDB
+}
+
+func (ur *MemoryTelegramProfileRepo) Get(_ context.Context, userID uint64) (u *TelegramProfile, err error) {
+	defer wrap.Errf("get telegram profile %d", &err, userID)
+	ur.DB.mu.RLock()
+	defer ur.DB.mu.RUnlock()
+	stored, ok := ur.DB.telegramProfiles[userID]
+	if !ok {
+		return nil, wrap.NotFoundError{}
+	}
+	return &stored, nil
+}
+
+func (ur *MemoryTelegramProfileRepo) Insert(ctx context.Context, u *TelegramProfile) (err error) {
+	defer wrap.Errf("insert telegram profile %d", &err, u.UserID)
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	if _, ok := ur.DB.telegramProfiles[u.UserID]; ok {
+		return wrap.AlreadyExistsError{}
+	}
+	ur.DB.telegramProfiles[u.UserID] = *u
+	return nil
+}
+
+func (ur *MemoryTelegramProfileRepo) Upsert(ctx context.Context, u *TelegramProfile) (err error) {
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	ur.DB.telegramProfiles[u.UserID] = *u
+	return nil
+}
+
+func (ur *MemoryTelegramProfileRepo) Delete(ctx context.Context, userID uint64) (err error) {
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	delete(ur.DB.telegramProfiles, userID)
+	return nil
+}
+
+type MemorySubscriptionRepo struct {
+	DB *MemoryDB
+}
+
+func (ur *MemorySubscriptionRepo) Get(_ context.Context, userID uint64, topic string) (u *Subscription, err error) {
+	defer wrap.Errf("get subscription %d,%s", &err, userID, topic)
+	ur.DB.mu.RLock()
+	defer ur.DB.mu.RUnlock()
+	stored, ok := ur.DB.subscriptions[subscriptionKey{userID: userID, topic: topic}]
+	if !ok {
+		return nil, wrap.NotFoundError{}
+	}
+	return &stored, nil
+}
+
+func (ur *MemorySubscriptionRepo) GetByUserID(_ context.Context, userID uint64) (ss []*Subscription, err error) {
+	ur.DB.mu.RLock()
+	defer ur.DB.mu.RUnlock()
+	for k, stored := range ur.DB.subscriptions {
+		if k.userID != userID {
+			continue
+		}
+		s := stored
+		ss = append(ss, &s)
+	}
+	// YDB returns rows ordered by primary key
+	sort.Slice(ss, func(i, j int) bool {
+		return ss[i].Topic < ss[j].Topic
+	})
+	return ss, nil
+}
+
+func (ur *MemorySubscriptionRepo) Insert(ctx context.Context, u *Subscription) (err error) {
+	defer wrap.Errf("insert subscription %d,%s", &err, u.UserID, u.Topic)
+	u.BeforeInsert()
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	key := subscriptionKey{userID: u.UserID, topic: u.Topic}
+	if _, ok := ur.DB.subscriptions[key]; ok {
+		return wrap.AlreadyExistsError{}
+	}
+	ur.DB.subscriptions[key] = ur.stored(u)
+	return nil
+}
+
+func (ur *MemorySubscriptionRepo) Upsert(ctx context.Context, u *Subscription) (err error) {
+	u.BeforeUpdate()
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	ur.DB.subscriptions[subscriptionKey{userID: u.UserID, topic: u.Topic}] = ur.stored(u)
+	return nil
+}
+
+func (ur *MemorySubscriptionRepo) Delete(ctx context.Context, userID uint64, topic string) (err error) {
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	delete(ur.DB.subscriptions, subscriptionKey{userID: userID, topic: topic})
+	return nil
+}
+
+func (ur *MemorySubscriptionRepo) DeleteByUserID(ctx context.Context, userID uint64) (err error) {
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	for k := range ur.DB.subscriptions {
+		if k.userID == userID {
+			delete(ur.DB.subscriptions, k)
+		}
+	}
+	return nil
+}
+
+func (ur *MemorySubscriptionRepo) ListActiveByTopic(
+	_ context.Context, topic string, cursor string, limit int,
+) (ss []*Subscription, next string, err error) {
+	defer wrap.Errf("list active subscriptions to %s", &err, topic)
+	after, err := parseSubscriptionPage(cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	ur.DB.mu.RLock()
+	defer ur.DB.mu.RUnlock()
+	for k, stored := range ur.DB.subscriptions {
+		if k.topic != topic || !stored.Active || k.userID <= after {
+			continue
+		}
+		s := stored
+		ss = append(ss, &s)
+	}
+	sort.Slice(ss, func(i, j int) bool {
+		return ss[i].UserID < ss[j].UserID
+	})
+	if len(ss) > limit+1 {
+		ss = ss[:limit+1]
+	}
+	ss, next = subscriptionsPage(ss, limit)
+	return ss, next, nil
+}
+
+func (ur *MemorySubscriptionRepo) CountActive(_ context.Context, topic string) (count uint64, err error) {
+	ur.DB.mu.RLock()
+	defer ur.DB.mu.RUnlock()
+	for k, stored := range ur.DB.subscriptions {
+		if k.topic == topic && stored.Active {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (ur *MemorySubscriptionRepo) stored(u *Subscription) Subscription {
+	res := *u
+	res.CreatedAt = datetime(res.CreatedAt)
+	res.LastAction = datetime(res.LastAction)
+	return res
+}
@@ -0,0 +1,130 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"github.com/failoverbar/bot/wrap"
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"time"
+)
+
+// Perk records something staff gave the user, e.g. a welcome drink. Every kind is given once.
+type Perk struct {
+	UserID uint64 `ydb:"user_id,primary"`
+	Kind   string `ydb:"kind,primary"`
+
+	// IssuedBy is the staff member who pressed the button.
+	IssuedBy uint64    `ydb:"issued_by"`
+	IssuedAt time.Time `ydb:"issued_at"`
+}
+
+// Kinds of perks.
+const (
+	PerkWelcomeDrink = "welcome_drink"
+)
+
+func (p *Perk) BeforeInsert() {
+	p.IssuedAt = time.Now()
+}
+
+type PerkStorage interface {
+	// Insert returns wrap.AlreadyExistsError if the perk is already issued.
+	Insert(ctx context.Context, p *Perk) error
+	GetByUserID(ctx context.Context, userID uint64) ([]*Perk, error)
+	DeleteByUserID(ctx context.Context, userID uint64) error
+}
+
+var (
+	_ PerkStorage = (*PerkRepo)(nil)
+	_ PerkStorage = (*MemoryPerkRepo)(nil)
+	_ PerkStorage = (*PgPerkRepo)(nil)
+)
+
+type PerkRepo struct {
+	DB ydb.Connection
+}
+
+func (ur *PerkRepo) table() *YDBTable[Perk] {
+	return NewYDBTable[Perk](ur.DB, "perks")
+}
+
+func (ur *PerkRepo) Insert(ctx context.Context, p *Perk) (err error) {
+	defer wrap.Errf("insert perk %s of %d", &err, p.Kind, p.UserID)
+	return ur.table().Insert(ctx, p)
+}
+
+func (ur *PerkRepo) GetByUserID(ctx context.Context, userID uint64) (ps []*Perk, err error) {
+	defer wrap.Errf("get perks by userID %d", &err, userID)
+	return ur.table().Select(ctx, userID)
+}
+
+func (ur *PerkRepo) DeleteByUserID(ctx context.Context, userID uint64) (err error) {
+	defer wrap.Errf("delete perks by userID %d", &err, userID)
+	return ur.table().Delete(ctx, userID)
+}
+
+func (ur *PerkRepo) CreateTable(ctx context.Context) (err error) {
+	return ur.table().CreateTable(ctx)
+}
+
+type MemoryPerkRepo struct {
+	DB *MemoryDB
+}
+
+func (ur *MemoryPerkRepo) Insert(ctx context.Context, p *Perk) (err error) {
+	defer wrap.Errf("insert perk %s of %d", &err, p.Kind, p.UserID)
+	p.BeforeInsert()
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	for _, stored := range ur.DB.perks[p.UserID] {
+		if stored.Kind == p.Kind {
+			return wrap.AlreadyExistsError{}
+		}
+	}
+	stored := *p
+	stored.IssuedAt = datetime(p.IssuedAt)
+	ur.DB.perks[p.UserID] = append(ur.DB.perks[p.UserID], stored)
+	return nil
+}
+
+func (ur *MemoryPerkRepo) GetByUserID(_ context.Context, userID uint64) ([]*Perk, error) {
+	ur.DB.mu.RLock()
+	defer ur.DB.mu.RUnlock()
+	stored := ur.DB.perks[userID]
+	ps := make([]*Perk, 0, len(stored))
+	for i := range stored {
+		p := stored[i]
+		ps = append(ps, &p)
+	}
+	return ps, nil
+}
+
+func (ur *MemoryPerkRepo) DeleteByUserID(ctx context.Context, userID uint64) error {
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	delete(ur.DB.perks, userID)
+	return nil
+}
+
+type PgPerkRepo struct {
+	DB *sql.DB
+}
+
+func (ur *PgPerkRepo) table() *PgTable[Perk] {
+	return NewPgTable[Perk](ur.DB, "perks")
+}
+
+func (ur *PgPerkRepo) Insert(ctx context.Context, p *Perk) (err error) {
+	defer wrap.Errf("insert perk %s of %d", &err, p.Kind, p.UserID)
+	return ur.table().Insert(ctx, p)
+}
+
+func (ur *PgPerkRepo) GetByUserID(ctx context.Context, userID uint64) (ps []*Perk, err error) {
+	defer wrap.Errf("get perks by userID %d", &err, userID)
+	return ur.table().Select(ctx, userID)
+}
+
+func (ur *PgPerkRepo) DeleteByUserID(ctx context.Context, userID uint64) (err error) {
+	defer wrap.Errf("delete perks by userID %d", &err, userID)
+	return ur.table().Delete(ctx, userID)
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"github.com/failoverbar/bot/wrap"
+	"testing"
+)
+
+const perkUserID = userID + 8
+
+func TestPerk(t *testing.T) {
+	for name, s := range storages() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := createTable(ctx, s.Perks); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Perks.Insert(ctx, &Perk{UserID: perkUserID, Kind: PerkWelcomeDrink, IssuedBy: userID}); err != nil {
+				t.Fatal(err)
+			}
+			err := s.Perks.Insert(ctx, &Perk{UserID: perkUserID, Kind: PerkWelcomeDrink, IssuedBy: userID + 1})
+			if !errors.Is(err, wrap.AlreadyExistsError{}) {
+				t.Error("perk is issued twice", err)
+			}
+			ps, err := s.Perks.GetByUserID(ctx, perkUserID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ps) != 1 || ps[0].Kind != PerkWelcomeDrink || ps[0].IssuedBy != userID || ps[0].IssuedAt.IsZero() {
+				t.Error("wrong perks", ps)
+			}
+			if err = s.Perks.DeleteByUserID(ctx, perkUserID); err != nil {
+				t.Fatal(err)
+			}
+			if ps, err = s.Perks.GetByUserID(ctx, perkUserID); err != nil || len(ps) != 0 {
+				t.Error("perks are not deleted", ps, err)
+			}
+		})
+	}
+}
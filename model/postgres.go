@@ -0,0 +1,261 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/failoverbar/bot/wrap"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// PostgreSQL error codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgUniqueViolation      = "23505"
+	pgSerializationFailure = "40001"
+)
+
+// pgTxRetries limits attempts to run a transaction failed with serialization error.
+const pgTxRetries = 5
+
+// pgError is implemented by errors of PostgreSQL drivers (pgconn.PgError in particular).
+type pgError interface {
+	SQLState() string
+}
+
+func pgErrorCode(err error) string {
+	var pe pgError
+	if errors.As(err, &pe) {
+		return pe.SQLState()
+	}
+	return ""
+}
+
+// PgTable implements storage of T in a PostgreSQL table. Columns are described by the same
+// `ydb` struct tags as for YDBTable, and non-pointer columns are expected to be NOT NULL.
+type PgTable[T any] struct {
+	DB     *sql.DB
+	Name   string
+	schema *schema
+}
+
+func NewPgTable[T any](db *sql.DB, name string) *PgTable[T] {
+	return &PgTable[T]{
+		DB:     db,
+		Name:   name,
+		schema: schemaOf[T](),
+	}
+}
+
+// Get returns the row with the primary key or wrap.NotFoundError.
+func (t *PgTable[T]) Get(ctx context.Context, key ...interface{}) (v *T, err error) {
+	if len(key) != len(t.schema.primary) {
+		return nil, fmt.Errorf("%s: got %d key values for %d primary columns", t.Name, len(key), len(t.schema.primary))
+	}
+	vs, err := t.Select(ctx, key...)
+	if err != nil {
+		return nil, err
+	}
+	if len(vs) == 0 {
+		return nil, wrap.NotFoundError{}
+	}
+	return vs[0], nil
+}
+
+// Select returns rows which primary key starts with keyPrefix ordered by primary key.
+func (t *PgTable[T]) Select(ctx context.Context, keyPrefix ...interface{}) (vs []*T, err error) {
+	where, args, err := t.keyWhere(keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	query := `SELECT ` + t.fields(t.schema.columns) + ` FROM ` + t.Name + where +
+		` ORDER BY ` + t.fields(t.schema.primary)
+	return t.Query(ctx, query, args...)
+}
+
+// Query runs arbitrary SELECT returning all columns of the table and scans its rows.
+func (t *PgTable[T]) Query(ctx context.Context, query string, args ...interface{}) (vs []*T, err error) {
+	rows, err := pgConn(ctx, t.DB).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		v := new(T)
+		if err = rows.Scan(t.schema.scanDest(v)...); err != nil {
+			return nil, err
+		}
+		vs = append(vs, v)
+	}
+	return vs, rows.Err()
+}
+
+// Insert adds a row failing with wrap.AlreadyExistsError if the primary key is taken.
+func (t *PgTable[T]) Insert(ctx context.Context, v *T) error {
+	if h, ok := interface{}(v).(beforeInserter); ok {
+		h.BeforeInsert()
+	}
+	err := t.write(ctx, v, ``)
+	if pgErrorCode(err) == pgUniqueViolation {
+		return wrap.AlreadyExistsError{}
+	}
+	return err
+}
+
+// Upsert adds a row or replaces the existing one.
+func (t *PgTable[T]) Upsert(ctx context.Context, v *T) error {
+	if h, ok := interface{}(v).(beforeUpdater); ok {
+		h.BeforeUpdate()
+	}
+	sets := make([]string, 0, len(t.schema.columns))
+	for _, c := range t.schema.columns {
+		if !c.primary {
+			sets = append(sets, c.name+` = EXCLUDED.`+c.name)
+		}
+	}
+	conflict := ` ON CONFLICT (` + t.fields(t.schema.primary) + `) DO NOTHING`
+	if len(sets) > 0 {
+		conflict = ` ON CONFLICT (` + t.fields(t.schema.primary) + `) DO UPDATE SET ` + strings.Join(sets, `, `)
+	}
+	return t.write(ctx, v, conflict)
+}
+
+// Delete removes rows which primary key starts with keyPrefix.
+func (t *PgTable[T]) Delete(ctx context.Context, keyPrefix ...interface{}) error {
+	where, args, err := t.keyWhere(keyPrefix)
+	if err != nil {
+		return err
+	}
+	_, err = pgConn(ctx, t.DB).ExecContext(ctx, `DELETE FROM `+t.Name+where, args...)
+	return err
+}
+
+func (t *PgTable[T]) write(ctx context.Context, v *T, suffix string) error {
+	query := `INSERT INTO ` + t.Name + ` (` + t.fields(t.schema.columns) + `) VALUES (` +
+		pgPlaceholders(1, len(t.schema.columns)) + `)` + suffix
+	_, err := pgConn(ctx, t.DB).ExecContext(ctx, query, t.schema.args(v)...)
+	return err
+}
+
+func (t *PgTable[T]) keyWhere(keyPrefix []interface{}) (string, []interface{}, error) {
+	if len(keyPrefix) > len(t.schema.primary) {
+		return "", nil, fmt.Errorf("%s: got %d key values for %d primary columns", t.Name, len(keyPrefix), len(t.schema.primary))
+	}
+	if len(keyPrefix) == 0 {
+		return ``, nil, nil
+	}
+	conds := make([]string, 0, len(keyPrefix))
+	args := make([]interface{}, 0, len(keyPrefix))
+	for i, c := range t.schema.primary[:len(keyPrefix)] {
+		v := reflect.ValueOf(keyPrefix[i])
+		if v.Type() != c.goType {
+			return "", nil, fmt.Errorf("%s: key %s must be %s, got %s", t.Name, c.name, c.goType, v.Type())
+		}
+		conds = append(conds, c.name+` = $`+strconv.Itoa(i+1))
+		args = append(args, c.arg(v))
+	}
+	return ` WHERE ` + strings.Join(conds, ` AND `), args, nil
+}
+
+func (t *PgTable[T]) fields(cols []column) string {
+	return strings.Join(columnNames(cols), `, `)
+}
+
+// pgPlaceholders returns "$from, ..., $from+n-1".
+func pgPlaceholders(from, n int) string {
+	ps := make([]string, 0, n)
+	for i := from; i < from+n; i++ {
+		ps = append(ps, `$`+strconv.Itoa(i))
+	}
+	return strings.Join(ps, `, `)
+}
+
+// arg converts field value of the column to database/sql argument.
+// Unsigned integers are passed as int64, because database/sql rejects uint64 with the high bit set.
+func (c column) arg(v reflect.Value) interface{} {
+	if c.nullable {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	default:
+		return v.Interface()
+	}
+}
+
+func (s *schema) scanDest(v interface{}) []interface{} {
+	rv := reflect.ValueOf(v).Elem()
+	res := make([]interface{}, 0, len(s.columns))
+	for _, c := range s.columns {
+		res = append(res, rv.Field(c.field).Addr().Interface())
+	}
+	return res
+}
+
+func (s *schema) args(v interface{}) []interface{} {
+	rv := reflect.ValueOf(v).Elem()
+	res := make([]interface{}, 0, len(s.columns))
+	for _, c := range s.columns {
+		res = append(res, c.arg(rv.Field(c.field)))
+	}
+	return res
+}
+
+// pgQueryer is implemented by both *sql.DB and *sql.Tx.
+type pgQueryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type pgTxKey struct{}
+
+// pgConn returns the transaction bound to ctx or db itself.
+func pgConn(ctx context.Context, db *sql.DB) pgQueryer {
+	if tx, ok := ctx.Value(pgTxKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return db
+}
+
+// PgTransactor runs serializable transactions in PostgreSQL retrying serialization failures.
+type PgTransactor struct {
+	DB *sql.DB
+}
+
+func (t *PgTransactor) InTx(ctx context.Context, f func(ctx context.Context) error) error {
+	return pgInTx(ctx, t.DB, f)
+}
+
+func pgInTx(ctx context.Context, db *sql.DB, f func(ctx context.Context) error) (err error) {
+	if _, ok := ctx.Value(pgTxKey{}).(*sql.Tx); ok {
+		return f(ctx)
+	}
+	for i := 0; i < pgTxRetries; i++ {
+		err = pgTxOnce(ctx, db, f)
+		if pgErrorCode(err) != pgSerializationFailure {
+			return err
+		}
+	}
+	return err
+}
+
+func pgTxOnce(ctx context.Context, db *sql.DB, f func(ctx context.Context) error) error {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+	if err = f(context.WithValue(ctx, pgTxKey{}, tx)); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
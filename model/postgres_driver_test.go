@@ -0,0 +1,7 @@
+//go:build postgres
+
+package model
+
+import (
+	_ "github.com/jackc/pgx/v4/stdlib"
+)
@@ -0,0 +1,183 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"github.com/failoverbar/bot/wrap"
+	"strconv"
+)
+
+type PgUserRepo struct {
+	DB *sql.DB
+}
+
+func (ur *PgUserRepo) table() *PgTable[User] {
+	return NewPgTable[User](ur.DB, "users")
+}
+
+func (ur *PgUserRepo) Get(ctx context.Context, userID uint64) (u *User, err error) {
+	defer wrap.Errf("get user %d", &err, userID)
+	return ur.table().Get(ctx, userID)
+}
+
+func (ur *PgUserRepo) Insert(ctx context.Context, u *User) (err error) {
+	defer wrap.Errf("insert user %d", &err, u.UserID)
+	return ur.table().Insert(ctx, u)
+}
+
+func (ur *PgUserRepo) Upsert(ctx context.Context, u *User) (err error) {
+	defer wrap.Errf("upsert user %d", &err, u.UserID)
+	return ur.table().Upsert(ctx, u)
+}
+
+// Update stores u only if its version matches the stored one and increments the version.
+// wrap.ConflictError is returned if the user was changed since u was read.
+func (ur *PgUserRepo) Update(ctx context.Context, u *User) (err error) {
+	defer wrap.Errf("update user %d", &err, u.UserID)
+	u.BeforeUpdate()
+	next := *u
+	next.Version++
+	t := ur.table()
+	err = pgInTx(ctx, ur.DB, func(ctx context.Context) error {
+		stored, err := t.Get(ctx, u.UserID)
+		if err != nil {
+			return err
+		}
+		if stored.Version != u.Version {
+			return wrap.ConflictError{}
+		}
+		return t.Upsert(ctx, &next)
+	})
+	if err != nil {
+		return
+	}
+	u.Version = next.Version
+	return
+}
+
+func (ur *PgUserRepo) Delete(ctx context.Context, userID uint64) (err error) {
+	defer wrap.Errf("delete user %d", &err, userID)
+	return ur.table().Delete(ctx, userID)
+}
+
+type PgProfileRepo struct {
+	DB *sql.DB
+}
+
+func (ur *PgProfileRepo) table() *PgTable[Profile] {
+	return NewPgTable[Profile](ur.DB, "profiles")
+}
+
+func (ur *PgProfileRepo) Get(ctx context.Context, userID uint64) (u *Profile, err error) {
+	defer wrap.Errf("get profile %d", &err, userID)
+	return ur.table().Get(ctx, userID)
+}
+
+func (ur *PgProfileRepo) Insert(ctx context.Context, u *Profile) (err error) {
+	defer wrap.Errf("insert profile %d", &err, u.UserID)
+	return ur.table().Insert(ctx, u)
+}
+
+func (ur *PgProfileRepo) Upsert(ctx context.Context, u *Profile) (err error) {
+	defer wrap.Errf("upsert profile %d", &err, u.UserID)
+	return ur.table().Upsert(ctx, u)
+}
+
+func (ur *PgProfileRepo) Delete(ctx context.Context, userID uint64) (err error) {
+	defer wrap.Errf("delete profile %d", &err, userID)
+	return ur.table().Delete(ctx, userID)
+}
+
+type PgTelegramProfileRepo struct {
+	DB *sql.DB
+}
+
+func (ur *PgTelegramProfileRepo) table() *PgTable[TelegramProfile] {
+	return NewPgTable[TelegramProfile](ur.DB, "telegram_profiles")
+}
+
+func (ur *PgTelegramProfileRepo) Get(ctx context.Context, userID uint64) (u *TelegramProfile, err error) {
+	defer wrap.Errf("get telegram profile %d", &err, userID)
+	return ur.table().Get(ctx, userID)
+}
+
+func (ur *PgTelegramProfileRepo) Insert(ctx context.Context, u *TelegramProfile) (err error) {
+	defer wrap.Errf("insert telegram profile %d", &err, u.UserID)
+	return ur.table().Insert(ctx, u)
+}
+
+func (ur *PgTelegramProfileRepo) Upsert(ctx context.Context, u *TelegramProfile) (err error) {
+	defer wrap.Errf("upsert telegram profile %d", &err, u.UserID)
+	return ur.table().Upsert(ctx, u)
+}
+
+func (ur *PgTelegramProfileRepo) Delete(ctx context.Context, userID uint64) (err error) {
+	defer wrap.Errf("delete telegram profile %d", &err, userID)
+	return ur.table().Delete(ctx, userID)
+}
+
+type PgSubscriptionRepo struct {
+	DB *sql.DB
+}
+
+func (ur *PgSubscriptionRepo) table() *PgTable[Subscription] {
+	return NewPgTable[Subscription](ur.DB, "subscriptions")
+}
+
+func (ur *PgSubscriptionRepo) Get(ctx context.Context, userID uint64, topic string) (u *Subscription, err error) {
+	defer wrap.Errf("get subscription %d,%s", &err, userID, topic)
+	return ur.table().Get(ctx, userID, topic)
+}
+
+func (ur *PgSubscriptionRepo) GetByUserID(ctx context.Context, userID uint64) (ss []*Subscription, err error) {
+	defer wrap.Errf("get subscriptions by userID %d", &err, userID)
+	return ur.table().Select(ctx, userID)
+}
+
+func (ur *PgSubscriptionRepo) Insert(ctx context.Context, u *Subscription) (err error) {
+	defer wrap.Errf("insert subscription %d,%s", &err, u.UserID, u.Topic)
+	return ur.table().Insert(ctx, u)
+}
+
+func (ur *PgSubscriptionRepo) Upsert(ctx context.Context, u *Subscription) (err error) {
+	defer wrap.Errf("upsert subscription %d,%s", &err, u.UserID, u.Topic)
+	return ur.table().Upsert(ctx, u)
+}
+
+func (ur *PgSubscriptionRepo) Delete(ctx context.Context, userID uint64, topic string) (err error) {
+	defer wrap.Errf("delete subscription %d,%s", &err, userID, topic)
+	return ur.table().Delete(ctx, userID, topic)
+}
+
+func (ur *PgSubscriptionRepo) DeleteByUserID(ctx context.Context, userID uint64) (err error) {
+	defer wrap.Errf("delete subscription by userID %d", &err, userID)
+	return ur.table().Delete(ctx, userID)
+}
+
+func (ur *PgSubscriptionRepo) ListActiveByTopic(
+	ctx context.Context, topic string, cursor string, limit int,
+) (ss []*Subscription, next string, err error) {
+	defer wrap.Errf("list active subscriptions to %s", &err, topic)
+	after, err := parseSubscriptionPage(cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	t := ur.table()
+	query := `SELECT ` + t.fields(t.schema.columns) + ` FROM ` + t.Name + `
+		WHERE topic = $1 AND active AND user_id > $2
+		ORDER BY user_id
+		LIMIT ` + strconv.Itoa(limit+1)
+	ss, err = t.Query(ctx, query, topic, int64(after))
+	if err != nil {
+		return nil, "", err
+	}
+	ss, next = subscriptionsPage(ss, limit)
+	return ss, next, nil
+}
+
+func (ur *PgSubscriptionRepo) CountActive(ctx context.Context, topic string) (count uint64, err error) {
+	defer wrap.Errf("count active subscriptions to %s", &err, topic)
+	err = pgConn(ctx, ur.DB).QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM subscriptions WHERE topic = $1 AND active`, topic).Scan(&count)
+	return count, err
+}
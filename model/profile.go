@@ -4,12 +4,6 @@ import (
 	"context"
 	"github.com/failoverbar/bot/wrap"
 	"github.com/ydb-platform/ydb-go-sdk/v3"
-	"github.com/ydb-platform/ydb-go-sdk/v3/table"
-	"github.com/ydb-platform/ydb-go-sdk/v3/table/options"
-	"github.com/ydb-platform/ydb-go-sdk/v3/table/result"
-	"github.com/ydb-platform/ydb-go-sdk/v3/table/result/named"
-	"github.com/ydb-platform/ydb-go-sdk/v3/table/types"
-	"path"
 )
 
 type Profile struct {
@@ -19,159 +13,67 @@ type Profile struct {
 	Phone  *string `ydb:"phone"`
 	Email  *string `ydb:"email"`
 	Source string  `ydb:"source"`
-}
 
-func (u *Profile) scanValues() []named.Value {
-	return []named.Value{
-		named.Required("user_id", &u.UserID),
-		named.Optional("name", &u.Name),
-		named.Optional("phone", &u.Phone),
-		named.Optional("email", &u.Email),
-		named.OptionalWithDefault("source", &u.Source),
-	}
-}
+	// PhoneSource tells how Phone was given: PhoneFromContact or PhoneTyped.
+	PhoneSource string `ydb:"phone_source"`
+	// EmailVerified is set when the guest enters the code sent to Email.
+	EmailVerified bool `ydb:"email_verified"`
 
-func (u *Profile) setValues() []table.ParameterOption {
-	return []table.ParameterOption{
-		table.ValueParam("$UserID", types.Uint64Value(u.UserID)),
-		table.ValueParam("$Name", types.NullableUTF8Value(u.Name)),
-		table.ValueParam("$Phone", types.NullableUTF8Value(u.Phone)),
-		table.ValueParam("$Email", types.NullableUTF8Value(u.Email)),
-		table.ValueParam("$Source", types.UTF8Value(u.Source)),
-	}
+	// InIT is nil until the guest answers whether they work in IT.
+	InIT   *bool   `ydb:"in_it"`
+	ITRole *string `ydb:"it_role"`
 }
 
-type ProfileRepo struct {
-	DB ydb.Connection
-}
-
-func (ur ProfileRepo) declarePrimary() string {
-	return `DECLARE $UserID AS Uint64;
-`
-}
-
-func (ur ProfileRepo) declareProfile() string {
-	return `
-		DECLARE $UserID AS Uint64;
-		DECLARE $Name AS Utf8?;
-		DECLARE $Phone AS Utf8?;
-		DECLARE $Email AS Utf8?;
-		DECLARE $Source AS Utf8;
-`
-}
-
-func (ur ProfileRepo) fields() string {
-	return ` user_id, name, phone, email, source `
-}
+// Sources of Profile.Phone.
+const (
+	PhoneFromContact = "contact"
+	PhoneTyped       = "typed"
+)
 
-func (ur ProfileRepo) values() string {
-	return ` ($UserID, $Name, $Phone, $Email, $Source) `
-}
+// Values of Profile.ITRole, the guest chooses one of them at registration.
+const (
+	ITBackend  = "backend"
+	ITFrontend = "frontend"
+	ITMobile   = "mobile"
+	ITQA       = "qa"
+	ITDevOps   = "devops"
+	ITData     = "data"
+	ITPM       = "pm"
+	ITDesign   = "design"
+	ITOther    = "other"
+)
 
-func (ur ProfileRepo) table(name string) string {
-	res := ` profiles `
-	if name != "" {
-		res += name + ` `
-	}
-	return res
-}
+// ITRoles are all values of Profile.ITRole in the order they are offered.
+var ITRoles = []string{ITBackend, ITFrontend, ITMobile, ITQA, ITDevOps, ITData, ITPM, ITDesign, ITOther}
 
-func (ur ProfileRepo) findPrimary() string {
-	return ` WHERE user_id = $UserID `
+type ProfileRepo struct {
+	DB ydb.Connection
 }
 
-func (ur ProfileRepo) primaryParams(profileID uint64) *table.QueryParameters {
-	return table.NewQueryParameters(table.ValueParam("$UserID", types.Uint64Value(profileID)))
+func (ur *ProfileRepo) table() *YDBTable[Profile] {
+	return NewYDBTable[Profile](ur.DB, "profiles")
 }
 
 func (ur *ProfileRepo) Get(ctx context.Context, userID uint64) (u *Profile, err error) {
 	defer wrap.Errf("get profile %d", &err, userID)
-	u = &Profile{}
-	query := ur.declarePrimary() + `SELECT ` + ur.fields() +
-		" FROM " + ur.table("") +
-		ur.findPrimary()
-	var res result.Result
-	err = ur.DB.Table().Do(ctx, func(ctx context.Context, s table.Session) (err error) {
-		_, res, err = s.Execute(ctx, table.DefaultTxControl(), query,
-			ur.primaryParams(userID),
-			options.WithCollectStatsModeBasic(),
-		)
-		return err
-	})
-	if err != nil {
-		return
-	}
-	defer func() {
-		_ = res.Close()
-	}()
-	for res.NextResultSet(ctx) {
-		for res.NextRow() {
-			err = res.ScanNamed(u.scanValues()...)
-			return
-		}
-	}
-	err = wrap.NotFoundError{}
-	return
+	return ur.table().Get(ctx, userID)
 }
 
 func (ur *ProfileRepo) Insert(ctx context.Context, u *Profile) (err error) {
 	defer wrap.Errf("insert profile %d", &err, u.UserID)
-	query := ur.declareProfile() + `INSERT INTO ` + ur.table("") + ` (` + ur.fields() + `) VALUES ` + ur.values()
-	return ur.DB.Table().Do(
-		ctx,
-		func(ctx context.Context, s table.Session) (err error) {
-			_, _, err = s.Execute(ctx, writeTx, query,
-				table.NewQueryParameters(u.setValues()...),
-				options.WithCollectStatsModeBasic(),
-			)
-			return err
-		},
-	)
+	return ur.table().Insert(ctx, u)
 }
 
 func (ur *ProfileRepo) Upsert(ctx context.Context, u *Profile) (err error) {
 	defer wrap.Errf("upsert profile %d", &err, u.UserID)
-	query := ur.declareProfile() + `UPSERT INTO ` + ur.table("") + ` (` + ur.fields() + `) VALUES ` + ur.values()
-	return ur.DB.Table().Do(
-		ctx,
-		func(ctx context.Context, s table.Session) (err error) {
-			_, _, err = s.Execute(ctx, writeTx, query,
-				table.NewQueryParameters(u.setValues()...),
-				options.WithCollectStatsModeBasic(),
-			)
-			return err
-		},
-	)
+	return ur.table().Upsert(ctx, u)
 }
 
 func (ur *ProfileRepo) Delete(ctx context.Context, userID uint64) (err error) {
 	defer wrap.Errf("delete profile %d", &err, userID)
-	query := ur.declarePrimary() + `DELETE FROM ` + ur.table("") + ur.findPrimary()
-	return ur.DB.Table().Do(
-		ctx,
-		func(ctx context.Context, s table.Session) (err error) {
-			_, _, err = s.Execute(ctx, writeTx, query,
-				ur.primaryParams(userID),
-				options.WithCollectStatsModeBasic(),
-			)
-			return err
-		},
-	)
+	return ur.table().Delete(ctx, userID)
 }
 
 func (ur *ProfileRepo) CreateTable(ctx context.Context) (err error) {
-	defer wrap.Err("create table", &err)
-	return ur.DB.Table().Do(
-		ctx,
-		func(ctx context.Context, s table.Session) (err error) {
-			return s.CreateTable(ctx, path.Join(ur.DB.Name(), "profiles"),
-				options.WithColumn("user_id", types.Optional(types.TypeUint64)),
-				options.WithColumn("name", types.Optional(types.TypeUTF8)),
-				options.WithColumn("phone", types.Optional(types.TypeUTF8)),
-				options.WithColumn("email", types.Optional(types.TypeUTF8)),
-				options.WithColumn("source", types.Optional(types.TypeUTF8)),
-				options.WithPrimaryKeyColumn("user_id"),
-			)
-		},
-	)
+	return ur.table().CreateTable(ctx)
 }
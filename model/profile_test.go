@@ -8,19 +8,23 @@ import (
 	"testing"
 )
 
-var pr *ProfileRepo
+var pr ProfileStorage
 
 func TestProfile(t *testing.T) {
-	pr = &ProfileRepo{DB: db}
-	t.Run("create", testProfileCreateTable)
-	t.Run("insert", testProfileInsert)
-	t.Run("get", testProfileGet)
-	t.Run("update", testProfileUpdate)
-	t.Run("delete", testProfileDelete)
+	for name, s := range storages() {
+		pr = s.Profiles
+		t.Run(name, func(t *testing.T) {
+			t.Run("create", testProfileCreateTable)
+			t.Run("insert", testProfileInsert)
+			t.Run("get", testProfileGet)
+			t.Run("update", testProfileUpdate)
+			t.Run("delete", testProfileDelete)
+		})
+	}
 }
 
 func testProfileCreateTable(t *testing.T) {
-	if err := pr.CreateTable(context.Background()); err != nil {
+	if err := createTable(context.Background(), pr); err != nil {
 		t.Error(err)
 	}
 }
@@ -51,6 +55,9 @@ func testProfileUpdate(t *testing.T) {
 		t.Error("get: ", err)
 	}
 	u.Name = pointer.ToString("test")
+	u.InIT = pointer.ToBool(true)
+	u.ITRole = pointer.ToString("backend")
+	u.EmailVerified = true
 	err = pr.Upsert(context.Background(), u)
 	if err != nil {
 		t.Error("upsert: ", err)
@@ -62,6 +69,9 @@ func testProfileUpdate(t *testing.T) {
 	if u.Name == nil || *u.Name != "test" {
 		t.Error("nothing changed", u)
 	}
+	if !pointer.GetBool(u.InIT) || pointer.GetString(u.ITRole) != "backend" || !u.EmailVerified {
+		t.Error("IT background is not stored", u)
+	}
 }
 
 func testProfileDelete(t *testing.T) {
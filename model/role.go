@@ -0,0 +1,46 @@
+package model
+
+import (
+	"fmt"
+)
+
+// Role is an access level kept in User.Role. Every role is granted everything lower roles are.
+type Role uint8
+
+const (
+	// RoleGuest is the role of new users until they finish registration.
+	RoleGuest Role = iota
+	RoleRegular
+	RoleStaff
+	RoleAdmin
+	RoleOwner
+)
+
+var roleNames = []string{"guest", "regular", "staff", "admin", "owner"}
+
+func (r Role) String() string {
+	if int(r) < len(roleNames) {
+		return roleNames[r]
+	}
+	return fmt.Sprintf("role(%d)", uint8(r))
+}
+
+// ParseRole returns the role by its name.
+func ParseRole(name string) (Role, error) {
+	for i, n := range roleNames {
+		if n == name {
+			return Role(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown role %q", name)
+}
+
+// AccessRole returns Role of the user.
+func (u *User) AccessRole() Role {
+	return Role(u.Role)
+}
+
+// Can tells whether the user has at least role min.
+func (u *User) Can(min Role) bool {
+	return u.AccessRole() >= min
+}
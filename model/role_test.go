@@ -0,0 +1,21 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestRole(t *testing.T) {
+	for r := RoleGuest; r <= RoleOwner; r++ {
+		parsed, err := ParseRole(r.String())
+		if err != nil || parsed != r {
+			t.Error("role doesn't round trip", r, parsed, err)
+		}
+	}
+	if _, err := ParseRole("root"); err == nil {
+		t.Error("unknown role is parsed")
+	}
+	u := &User{Role: uint8(RoleAdmin)}
+	if !u.Can(RoleStaff) || !u.Can(RoleAdmin) || u.Can(RoleOwner) {
+		t.Error("wrong access of admin")
+	}
+}
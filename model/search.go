@@ -0,0 +1,229 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"github.com/failoverbar/bot/wrap"
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/result"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/result/named"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/types"
+	"sort"
+	"strings"
+)
+
+// UserQuery finds users matching any of its non-empty fields.
+type UserQuery struct {
+	// Username is Telegram username without @, it is matched case-insensitively.
+	Username string
+	// Phone is matched exactly, it should be normalized with package phone.
+	Phone string
+	// Name is a case-insensitive fragment of the profile name or the Telegram first and last name.
+	Name string
+	// After skips users with ids up to it, it pages through results.
+	After uint64
+}
+
+// UserDirectory searches users across profiles and telegram_profiles.
+type UserDirectory interface {
+	// FindUsers returns ids of up to limit users ordered by id.
+	FindUsers(ctx context.Context, q UserQuery, limit int) ([]uint64, error)
+	// ListUsers returns ids of up to limit users following after ordered by id.
+	ListUsers(ctx context.Context, after uint64, limit int) ([]uint64, error)
+}
+
+var (
+	_ UserDirectory = (*DirectoryRepo)(nil)
+	_ UserDirectory = (*MemoryDirectoryRepo)(nil)
+	_ UserDirectory = (*PgDirectoryRepo)(nil)
+)
+
+// likeFragment makes a LIKE pattern matching s anywhere, '!' is the escape character.
+func likeFragment(s string) string {
+	r := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+	return "%" + r.Replace(strings.ToLower(s)) + "%"
+}
+
+type DirectoryRepo struct {
+	DB ydb.Connection
+}
+
+func (ur *DirectoryRepo) FindUsers(ctx context.Context, q UserQuery, limit int) (ids []uint64, err error) {
+	defer wrap.Errf("find users %+v", &err, q)
+	query := `
+		DECLARE $Username AS Utf8;
+		DECLARE $Phone AS Utf8;
+		DECLARE $Name AS Utf8;
+		DECLARE $After AS Uint64;
+		DECLARE $Limit AS Uint64;
+		SELECT COALESCE(p.user_id, t.user_id) AS user_id
+		FROM profiles AS p
+		FULL JOIN telegram_profiles AS t ON p.user_id = t.user_id
+		WHERE COALESCE(p.user_id, t.user_id) > $After AND (
+			($Username != "" AND Unicode::ToLower(t.username) = $Username)
+			OR ($Phone != "" AND p.phone = $Phone)
+			OR ($Name != "%%" AND (
+				Unicode::ToLower(p.name) LIKE $Name ESCAPE "!"
+				OR Unicode::ToLower(t.first_name || " " || t.last_name) LIKE $Name ESCAPE "!"
+			))
+		)
+		ORDER BY user_id
+		LIMIT $Limit;
+`
+	res, err := execute(ctx, ur.DB, table.DefaultTxControl(), query, table.NewQueryParameters(
+		table.ValueParam("$Username", types.UTF8Value(strings.ToLower(q.Username))),
+		table.ValueParam("$Phone", types.UTF8Value(q.Phone)),
+		table.ValueParam("$Name", types.UTF8Value(likeFragment(q.Name))),
+		table.ValueParam("$After", types.Uint64Value(q.After)),
+		table.ValueParam("$Limit", types.Uint64Value(uint64(limit))),
+	))
+	if err != nil {
+		return nil, err
+	}
+	return scanUserIDs(ctx, res)
+}
+
+func (ur *DirectoryRepo) ListUsers(ctx context.Context, after uint64, limit int) (ids []uint64, err error) {
+	defer wrap.Errf("list users after %d", &err, after)
+	query := `
+		DECLARE $After AS Uint64;
+		DECLARE $Limit AS Uint64;
+		SELECT user_id FROM users
+		WHERE user_id > $After
+		ORDER BY user_id
+		LIMIT $Limit;
+`
+	res, err := execute(ctx, ur.DB, table.DefaultTxControl(), query, table.NewQueryParameters(
+		table.ValueParam("$After", types.Uint64Value(after)),
+		table.ValueParam("$Limit", types.Uint64Value(uint64(limit))),
+	))
+	if err != nil {
+		return nil, err
+	}
+	return scanUserIDs(ctx, res)
+}
+
+func scanUserIDs(ctx context.Context, res result.Result) (ids []uint64, err error) {
+	defer func() {
+		_ = res.Close()
+	}()
+	for res.NextResultSet(ctx) {
+		for res.NextRow() {
+			var id uint64
+			if err = res.ScanNamed(named.OptionalWithDefault("user_id", &id)); err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+	}
+	return ids, res.Err()
+}
+
+type MemoryDirectoryRepo struct {
+	DB *MemoryDB
+}
+
+func (ur *MemoryDirectoryRepo) FindUsers(_ context.Context, q UserQuery, limit int) ([]uint64, error) {
+	ur.DB.mu.RLock()
+	defer ur.DB.mu.RUnlock()
+	username, name := strings.ToLower(q.Username), strings.ToLower(q.Name)
+	found := map[uint64]bool{}
+	for id, p := range ur.DB.profiles {
+		if id <= q.After {
+			continue
+		}
+		if (q.Phone != "" && p.Phone != nil && *p.Phone == q.Phone) ||
+			(name != "" && p.Name != nil && strings.Contains(strings.ToLower(*p.Name), name)) {
+			found[id] = true
+		}
+	}
+	for id, t := range ur.DB.telegramProfiles {
+		if id <= q.After {
+			continue
+		}
+		if (username != "" && strings.ToLower(t.Username) == username) ||
+			(name != "" && strings.Contains(strings.ToLower(t.FirstName+" "+t.LastName), name)) {
+			found[id] = true
+		}
+	}
+	ids := make([]uint64, 0, len(found))
+	for id := range found {
+		ids = append(ids, id)
+	}
+	return firstUserIDs(ids, limit), nil
+}
+
+func (ur *MemoryDirectoryRepo) ListUsers(_ context.Context, after uint64, limit int) ([]uint64, error) {
+	ur.DB.mu.RLock()
+	defer ur.DB.mu.RUnlock()
+	ids := make([]uint64, 0, len(ur.DB.users))
+	for id := range ur.DB.users {
+		if id > after {
+			ids = append(ids, id)
+		}
+	}
+	return firstUserIDs(ids, limit), nil
+}
+
+// firstUserIDs sorts ids and cuts them to limit.
+func firstUserIDs(ids []uint64, limit int) []uint64 {
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids
+}
+
+type PgDirectoryRepo struct {
+	DB *sql.DB
+}
+
+func (ur *PgDirectoryRepo) FindUsers(ctx context.Context, q UserQuery, limit int) (ids []uint64, err error) {
+	defer wrap.Errf("find users %+v", &err, q)
+	rows, err := pgConn(ctx, ur.DB).QueryContext(ctx, `
+		SELECT COALESCE(p.user_id, t.user_id) AS id
+		FROM profiles p
+		FULL JOIN telegram_profiles t ON p.user_id = t.user_id
+		WHERE COALESCE(p.user_id, t.user_id) > $5 AND (
+			($1 <> '' AND lower(t.username) = $1)
+			OR ($2 <> '' AND p.phone = $2)
+			OR ($3 <> '%%' AND (
+				lower(p.name) LIKE $3 ESCAPE '!'
+				OR lower(t.first_name || ' ' || t.last_name) LIKE $3 ESCAPE '!'
+			))
+		)
+		ORDER BY id
+		LIMIT $4`,
+		strings.ToLower(q.Username), q.Phone, likeFragment(q.Name), limit, int64(q.After))
+	if err != nil {
+		return nil, err
+	}
+	return scanPgUserIDs(rows)
+}
+
+func (ur *PgDirectoryRepo) ListUsers(ctx context.Context, after uint64, limit int) (ids []uint64, err error) {
+	defer wrap.Errf("list users after %d", &err, after)
+	rows, err := pgConn(ctx, ur.DB).QueryContext(ctx,
+		`SELECT user_id FROM users WHERE user_id > $1 ORDER BY user_id LIMIT $2`, int64(after), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanPgUserIDs(rows)
+}
+
+func scanPgUserIDs(rows *sql.Rows) (ids []uint64, err error) {
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, uint64(id))
+	}
+	return ids, rows.Err()
+}
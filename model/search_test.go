@@ -0,0 +1,99 @@
+package model
+
+import (
+	"context"
+	"github.com/AlekSi/pointer"
+	"testing"
+)
+
+const searchUserID = userID + 7
+
+func TestFindUsers(t *testing.T) {
+	for name, s := range storages() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, repo := range []interface{}{s.Users, s.Profiles, s.TelegramProfiles} {
+				if err := createTable(ctx, repo); err != nil {
+					t.Fatal(err)
+				}
+			}
+			profiles := []*Profile{
+				{UserID: searchUserID, Name: pointer.ToString("Вася Пупкин"), Phone: pointer.ToString("+79990001122")},
+				{UserID: searchUserID + 1, Name: pointer.ToString("Маша")},
+			}
+			for _, p := range profiles {
+				if err := s.Profiles.Upsert(ctx, p); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tgs := []*TelegramProfile{
+				{UserID: searchUserID, Username: "Vasya_P", FirstName: "Vasiliy"},
+				// A guest who has no profile yet.
+				{UserID: searchUserID + 2, Username: "masha100", FirstName: "Мария", LastName: "Пупкина"},
+			}
+			for _, tg := range tgs {
+				if err := s.TelegramProfiles.Upsert(ctx, tg); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tests := []struct {
+				name string
+				q    UserQuery
+				want []uint64
+			}{
+				{"username", UserQuery{Username: "vasya_p"}, []uint64{searchUserID}},
+				{"phone", UserQuery{Phone: "+79990001122"}, []uint64{searchUserID}},
+				{"name", UserQuery{Name: "пупкин"}, []uint64{searchUserID, searchUserID + 2}},
+				{"escaped", UserQuery{Name: "_"}, nil},
+				{"empty", UserQuery{}, nil},
+				{"any", UserQuery{Username: "masha100", Name: "маша"}, []uint64{searchUserID + 1, searchUserID + 2}},
+				{"after", UserQuery{Name: "пупкин", After: searchUserID}, []uint64{searchUserID + 2}},
+			}
+			for _, tt := range tests {
+				got, err := s.Directory.FindUsers(ctx, tt.q, 10)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(got) != len(tt.want) {
+					t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+					continue
+				}
+				for i := range got {
+					if got[i] != tt.want[i] {
+						t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+						break
+					}
+				}
+			}
+			if got, err := s.Directory.FindUsers(ctx, UserQuery{Name: "пупкин"}, 1); err != nil || len(got) != 1 {
+				t.Error("limit is ignored", got, err)
+			}
+
+			for id := uint64(searchUserID); id <= searchUserID+2; id++ {
+				if err := s.Users.Insert(ctx, &User{UserID: id}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			listed, err := s.Directory.ListUsers(ctx, searchUserID, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(listed) < 2 || listed[0] != searchUserID+1 || listed[1] != searchUserID+2 {
+				t.Error("wrong users page", listed)
+			}
+
+			for id := uint64(searchUserID); id <= searchUserID+2; id++ {
+				if err := s.Users.Delete(ctx, id); err != nil {
+					t.Error(err)
+				}
+				if err := s.Profiles.Delete(ctx, id); err != nil {
+					t.Error(err)
+				}
+				if err := s.TelegramProfiles.Delete(ctx, id); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"github.com/failoverbar/bot/wrap"
+	"github.com/ydb-platform/ydb-go-genproto/protos/Ydb"
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+)
+
+type UserStorage interface {
+	Get(ctx context.Context, userID uint64) (*User, error)
+	Insert(ctx context.Context, u *User) error
+	Upsert(ctx context.Context, u *User) error
+	// Update is Upsert with optimistic locking on User.Version.
+	Update(ctx context.Context, u *User) error
+	Delete(ctx context.Context, userID uint64) error
+}
+
+type ProfileStorage interface {
+	Get(ctx context.Context, userID uint64) (*Profile, error)
+	Insert(ctx context.Context, u *Profile) error
+	Upsert(ctx context.Context, u *Profile) error
+	Delete(ctx context.Context, userID uint64) error
+}
+
+type TelegramProfileStorage interface {
+	Get(ctx context.Context, userID uint64) (*TelegramProfile, error)
+	Insert(ctx context.Context, u *TelegramProfile) error
+	Upsert(ctx context.Context, u *TelegramProfile) error
+	Delete(ctx context.Context, userID uint64) error
+}
+
+type SubscriptionStorage interface {
+	Get(ctx context.Context, userID uint64, topic string) (*Subscription, error)
+	GetByUserID(ctx context.Context, userID uint64) ([]*Subscription, error)
+	Insert(ctx context.Context, u *Subscription) error
+	Upsert(ctx context.Context, u *Subscription) error
+	Delete(ctx context.Context, userID uint64, topic string) error
+	DeleteByUserID(ctx context.Context, userID uint64) error
+	// ListActiveByTopic pages through active subscribers of topic, see SubscriptionRepo.ListActiveByTopic.
+	ListActiveByTopic(ctx context.Context, topic string, cursor string, limit int) ([]*Subscription, string, error)
+	CountActive(ctx context.Context, topic string) (uint64, error)
+}
+
+var (
+	_ UserStorage            = (*UserRepo)(nil)
+	_ ProfileStorage         = (*ProfileRepo)(nil)
+	_ TelegramProfileStorage = (*TelegramProfileRepo)(nil)
+	_ SubscriptionStorage    = (*SubscriptionRepo)(nil)
+
+	_ UserStorage            = (*MemoryUserRepo)(nil)
+	_ ProfileStorage         = (*MemoryProfileRepo)(nil)
+	_ TelegramProfileStorage = (*MemoryTelegramProfileRepo)(nil)
+	_ SubscriptionStorage    = (*MemorySubscriptionRepo)(nil)
+
+	_ UserStorage            = (*PgUserRepo)(nil)
+	_ ProfileStorage         = (*PgProfileRepo)(nil)
+	_ TelegramProfileStorage = (*PgTelegramProfileRepo)(nil)
+	_ SubscriptionStorage    = (*PgSubscriptionRepo)(nil)
+)
+
+// Storage is a set of repositories backed by the same database.
+type Storage struct {
+	Users            UserStorage
+	Profiles         ProfileStorage
+	TelegramProfiles TelegramProfileStorage
+	Subscriptions    SubscriptionStorage
+	// History is written by the other repositories, see withHistory.
+	History      HistoryStorage
+	Attributions AttributionStorage
+	Topics       TopicStorage
+	Broadcasts   BroadcastStorage
+	Bans         BanStorage
+	Perks        PerkStorage
+	Tickets      TicketStorage
+	Directory    UserDirectory
+
+	Tx Transactor
+}
+
+func NewYDBStorage(db ydb.Connection) Storage {
+	return withHistory(withTopics(Storage{
+		Users:            &UserRepo{DB: db},
+		Profiles:         &ProfileRepo{DB: db},
+		TelegramProfiles: &TelegramProfileRepo{DB: db},
+		Subscriptions:    &SubscriptionRepo{DB: db},
+		History:          &HistoryRepo{DB: db},
+		Attributions:     &AttributionRepo{DB: db},
+		Topics:           &TopicRepo{DB: db},
+		Broadcasts:       &BroadcastRepo{DB: db},
+		Bans:             &BanRepo{DB: db},
+		Perks:            &PerkRepo{DB: db},
+		Tickets:          &TicketRepo{DB: db},
+		Directory:        &DirectoryRepo{DB: db},
+
+		Tx: &YDBTransactor{DB: db},
+	}))
+}
+
+func NewPostgresStorage(db *sql.DB) Storage {
+	return withHistory(withTopics(Storage{
+		Users:            &PgUserRepo{DB: db},
+		Profiles:         &PgProfileRepo{DB: db},
+		TelegramProfiles: &PgTelegramProfileRepo{DB: db},
+		Subscriptions:    &PgSubscriptionRepo{DB: db},
+		History:          &PgHistoryRepo{DB: db},
+		Attributions:     &PgAttributionRepo{DB: db},
+		Topics:           &PgTopicRepo{DB: db},
+		Broadcasts:       &PgBroadcastRepo{DB: db},
+		Bans:             &PgBanRepo{DB: db},
+		Perks:            &PgPerkRepo{DB: db},
+		Tickets:          &PgTicketRepo{DB: db},
+		Directory:        &PgDirectoryRepo{DB: db},
+
+		Tx: &PgTransactor{DB: db},
+	}))
+}
+
+func NewMemoryStorage() Storage {
+	db := NewMemoryDB()
+	return withHistory(withTopics(Storage{
+		Users:            &MemoryUserRepo{DB: db},
+		Profiles:         &MemoryProfileRepo{DB: db},
+		TelegramProfiles: &MemoryTelegramProfileRepo{DB: db},
+		Subscriptions:    &MemorySubscriptionRepo{DB: db},
+		History:          &MemoryHistoryRepo{DB: db},
+		Attributions:     &MemoryAttributionRepo{DB: db},
+		Topics:           &MemoryTopicRepo{DB: db},
+		Broadcasts:       &MemoryBroadcastRepo{DB: db},
+		Bans:             &MemoryBanRepo{DB: db},
+		Perks:            &MemoryPerkRepo{DB: db},
+		Tickets:          &MemoryTicketRepo{DB: db},
+		Directory:        &MemoryDirectoryRepo{DB: db},
+
+		Tx: &MemoryTransactor{DB: db},
+	}))
+}
+
+// insertErr converts YDB "row already exists" failure of INSERT into wrap.AlreadyExistsError.
+func insertErr(err error) error {
+	if ydb.IsOperationError(err, Ydb.StatusIds_PRECONDITION_FAILED) {
+		return wrap.AlreadyExistsError{}
+	}
+	return err
+}
@@ -2,25 +2,28 @@ package model
 
 import (
 	"context"
+	"fmt"
 	"github.com/failoverbar/bot/wrap"
 	"github.com/ydb-platform/ydb-go-sdk/v3"
 	"github.com/ydb-platform/ydb-go-sdk/v3/table"
 	"github.com/ydb-platform/ydb-go-sdk/v3/table/options"
-	"github.com/ydb-platform/ydb-go-sdk/v3/table/result"
 	"github.com/ydb-platform/ydb-go-sdk/v3/table/result/named"
 	"github.com/ydb-platform/ydb-go-sdk/v3/table/types"
-	"path"
+	"strconv"
 	"time"
 )
 
+// subscriptionsTopicIndex is a secondary index on (topic, active), see migrations.
+const subscriptionsTopicIndex = "subscriptions_topic_active"
+
 type Subscription struct {
 	UserID uint64 `ydb:"user_id,primary"`
 	Topic  string `ydb:"topic,primary"`
 
 	Active bool `ydb:"active"`
 
-	CreatedAt  time.Time `ydb:"created_at"`
-	LastAction time.Time `ydb:"last_action"`
+	CreatedAt  time.Time `ydb:"created_at" history:"-"`
+	LastAction time.Time `ydb:"last_action" history:"-"`
 }
 
 func (u *Subscription) BeforeInsert() {
@@ -28,27 +31,11 @@ func (u *Subscription) BeforeInsert() {
 	u.BeforeUpdate()
 }
 
+// BeforeUpdate also sets CreatedAt of subscriptions upserted without being inserted first.
 func (u *Subscription) BeforeUpdate() {
 	u.LastAction = time.Now()
-}
-
-func (u *Subscription) scanValues() []named.Value {
-	return []named.Value{
-		named.Required("user_id", &u.UserID),
-		named.OptionalWithDefault("topic", &u.Topic),
-		named.OptionalWithDefault("active", &u.Active),
-		named.OptionalWithDefault("created_at", &u.CreatedAt),
-		named.OptionalWithDefault("last_action", &u.LastAction),
-	}
-}
-
-func (u *Subscription) setValues() []table.ParameterOption {
-	return []table.ParameterOption{
-		table.ValueParam("$UserID", types.Uint64Value(u.UserID)),
-		table.ValueParam("$Topic", types.UTF8Value(u.Topic)),
-		table.ValueParam("$Active", types.BoolValue(u.Active)),
-		table.ValueParam("$CreatedAt", types.DatetimeValueFromTime(u.CreatedAt)),
-		table.ValueParam("$LastAction", types.DatetimeValueFromTime(u.LastAction)),
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = u.LastAction
 	}
 }
 
@@ -56,197 +43,129 @@ type SubscriptionRepo struct {
 	DB ydb.Connection
 }
 
-func (ur SubscriptionRepo) declarePrimary() string {
-	return `
-		DECLARE $UserID AS Uint64;
-		DECLARE $Topic AS Utf8;
-`
-}
-
-func (ur SubscriptionRepo) declareSubscription() string {
-	return `
-		DECLARE $UserID AS Uint64;
-		DECLARE $Topic AS Utf8;
-		DECLARE $Active AS Bool;
-		DECLARE $CreatedAt AS Datetime;
-		DECLARE $LastAction AS Datetime;
-`
-}
-
-func (ur SubscriptionRepo) fields() string {
-	return ` user_id, topic, active, created_at, last_action `
+func (ur *SubscriptionRepo) table() *YDBTable[Subscription] {
+	return NewYDBTable[Subscription](ur.DB, "subscriptions")
 }
 
-func (ur SubscriptionRepo) values() string {
-	return ` ($UserID, $Topic, $Active, $CreatedAt, $LastAction) `
+func (ur *SubscriptionRepo) Get(ctx context.Context, userID uint64, topic string) (u *Subscription, err error) {
+	defer wrap.Errf("get subscription %d,%s", &err, userID, topic)
+	return ur.table().Get(ctx, userID, topic)
 }
 
-func (ur SubscriptionRepo) table(name string) string {
-	res := ` subscriptions `
-	if name != "" {
-		res += name + ` `
-	}
-	return res
+func (ur *SubscriptionRepo) GetByUserID(ctx context.Context, userID uint64) (ss []*Subscription, err error) {
+	defer wrap.Errf("get subscriptions by userID %d", &err, userID)
+	return ur.table().Select(ctx, userID)
 }
 
-func (ur SubscriptionRepo) findPrimary() string {
-	return ` WHERE user_id = $UserID AND topic = $Topic `
+func (ur *SubscriptionRepo) Insert(ctx context.Context, u *Subscription) (err error) {
+	defer wrap.Errf("insert subscription %d,%s", &err, u.UserID, u.Topic)
+	return ur.table().Insert(ctx, u)
 }
 
-func (ur SubscriptionRepo) findByFirst() string {
-	return ` WHERE user_id = $UserID `
+func (ur *SubscriptionRepo) Upsert(ctx context.Context, u *Subscription) (err error) {
+	defer wrap.Errf("upsert subscription %d,%s", &err, u.UserID, u.Topic)
+	return ur.table().Upsert(ctx, u)
 }
 
-func (ur SubscriptionRepo) firstParam(userID uint64) *table.QueryParameters {
-	return table.NewQueryParameters(
-		table.ValueParam("$UserID", types.Uint64Value(userID)),
-	)
+func (ur *SubscriptionRepo) Delete(ctx context.Context, userID uint64, topic string) (err error) {
+	defer wrap.Errf("delete subscription %d,%s", &err, userID, topic)
+	return ur.table().Delete(ctx, userID, topic)
 }
 
-func (ur SubscriptionRepo) primaryParams(userID uint64, topic string) *table.QueryParameters {
-	return table.NewQueryParameters(
-		table.ValueParam("$UserID", types.Uint64Value(userID)),
-		table.ValueParam("$Topic", types.UTF8Value(topic)),
-	)
+func (ur *SubscriptionRepo) DeleteByUserID(ctx context.Context, userID uint64) (err error) {
+	defer wrap.Errf("delete subscription by userID %d", &err, userID)
+	return ur.table().Delete(ctx, userID)
 }
 
-func (ur *SubscriptionRepo) Get(ctx context.Context, userID uint64, topic string) (u *Subscription, err error) {
-	defer wrap.Errf("get subscription %d,%s", &err, userID, topic)
-	u = &Subscription{}
-	query := ur.declarePrimary() + `SELECT ` + ur.fields() +
-		" FROM " + ur.table("") +
-		ur.findPrimary()
-	var res result.Result
-	err = ur.DB.Table().Do(ctx, func(ctx context.Context, s table.Session) (err error) {
-		_, res, err = s.Execute(ctx, table.DefaultTxControl(), query,
-			ur.primaryParams(userID, topic),
-			options.WithCollectStatsModeBasic(),
-		)
-		return err
-	})
+// ListActiveByTopic returns up to limit active subscriptions to topic ordered by user id
+// starting after cursor. Empty cursor means the first page. The returned next cursor is empty
+// when there are no more subscriptions.
+func (ur *SubscriptionRepo) ListActiveByTopic(
+	ctx context.Context, topic string, cursor string, limit int,
+) (ss []*Subscription, next string, err error) {
+	defer wrap.Errf("list active subscriptions to %s", &err, topic)
+	after, err := parseSubscriptionPage(cursor, limit)
 	if err != nil {
-		return
+		return nil, "", err
 	}
-	defer func() {
-		_ = res.Close()
-	}()
-	for res.NextResultSet(ctx) {
-		for res.NextRow() {
-			err = res.ScanNamed(u.scanValues()...)
-			return
-		}
+	t := ur.table()
+	query := `
+		DECLARE $Topic AS Utf8;
+		DECLARE $After AS Uint64;
+		DECLARE $Limit AS Uint64;
+		SELECT ` + t.fields(t.schema.columns) + ` FROM ` + t.Name + ` VIEW ` + subscriptionsTopicIndex + `
+		WHERE topic = $Topic AND active = true AND user_id > $After
+		ORDER BY user_id
+		LIMIT $Limit;
+`
+	ss, err = t.Query(ctx, query, table.NewQueryParameters(
+		table.ValueParam("$Topic", types.UTF8Value(topic)),
+		table.ValueParam("$After", types.Uint64Value(after)),
+		table.ValueParam("$Limit", types.Uint64Value(uint64(limit)+1)),
+	))
+	if err != nil {
+		return nil, "", err
 	}
-	err = wrap.NotFoundError{}
-	return
+	ss, next = subscriptionsPage(ss, limit)
+	return ss, next, nil
 }
 
-func (ur *SubscriptionRepo) GetByUserID(ctx context.Context, userID uint64) (ss []*Subscription, err error) {
-	defer wrap.Errf("get subscriptions by userID %d", &err, userID)
-	query := ur.declarePrimary() + `SELECT ` + ur.fields() +
-		" FROM " + ur.table("") +
-		ur.findByFirst()
-	var res result.Result
-	err = ur.DB.Table().Do(ctx, func(ctx context.Context, s table.Session) (err error) {
-		_, res, err = s.Execute(ctx, table.DefaultTxControl(), query,
-			ur.firstParam(userID),
-			options.WithCollectStatsModeBasic(),
-		)
-		return err
-	})
+// CountActive returns number of active subscribers of the topic.
+func (ur *SubscriptionRepo) CountActive(ctx context.Context, topic string) (count uint64, err error) {
+	defer wrap.Errf("count active subscriptions to %s", &err, topic)
+	query := `
+		DECLARE $Topic AS Utf8;
+		SELECT COUNT(*) AS count FROM subscriptions VIEW ` + subscriptionsTopicIndex + `
+		WHERE topic = $Topic AND active = true;
+`
+	res, err := execute(ctx, ur.DB, table.DefaultTxControl(), query, table.NewQueryParameters(
+		table.ValueParam("$Topic", types.UTF8Value(topic)),
+	))
 	if err != nil {
-		return
+		return 0, err
 	}
 	defer func() {
 		_ = res.Close()
 	}()
 	for res.NextResultSet(ctx) {
 		for res.NextRow() {
-			s := &Subscription{}
-			err = res.ScanNamed(s.scanValues()...)
-			if err != nil {
-				return
+			if err = res.ScanNamed(named.OptionalWithDefault("count", &count)); err != nil {
+				return 0, err
 			}
-			ss = append(ss, s)
 		}
 	}
-	return
+	return count, res.Err()
 }
 
-func (ur *SubscriptionRepo) Insert(ctx context.Context, u *Subscription) (err error) {
-	defer wrap.Errf("insert subscription %d,%s", &err, u.UserID, u.Topic)
-	u.BeforeInsert()
-	query := ur.declareSubscription() + `INSERT INTO ` + ur.table("") + ` (` + ur.fields() + `) VALUES ` + ur.values()
-	return ur.DB.Table().Do(
-		ctx,
-		func(ctx context.Context, s table.Session) (err error) {
-			_, _, err = s.Execute(ctx, writeTx, query,
-				table.NewQueryParameters(u.setValues()...),
-				options.WithCollectStatsModeBasic(),
-			)
-			return err
-		},
-	)
-}
-
-func (ur *SubscriptionRepo) Upsert(ctx context.Context, u *Subscription) (err error) {
-	defer wrap.Errf("upsert subscription %d,%s", &err, u.UserID, u.Topic)
-	u.BeforeUpdate()
-	query := ur.declareSubscription() + `UPSERT INTO ` + ur.table("") + ` (` + ur.fields() + `) VALUES ` + ur.values()
-	return ur.DB.Table().Do(
-		ctx,
-		func(ctx context.Context, s table.Session) (err error) {
-			_, _, err = s.Execute(ctx, writeTx, query,
-				table.NewQueryParameters(u.setValues()...),
-				options.WithCollectStatsModeBasic(),
-			)
-			return err
-		},
-	)
-}
-
-func (ur *SubscriptionRepo) Delete(ctx context.Context, userID uint64, topic string) (err error) {
-	defer wrap.Errf("delete subscription %d,%s", &err, userID, topic)
-	query := ur.declarePrimary() + `DELETE FROM ` + ur.table("") + ur.findPrimary()
-	return ur.DB.Table().Do(
-		ctx,
-		func(ctx context.Context, s table.Session) (err error) {
-			_, _, err = s.Execute(ctx, writeTx, query,
-				ur.primaryParams(userID, topic),
-				options.WithCollectStatsModeBasic(),
-			)
-			return err
-		},
+func (ur *SubscriptionRepo) CreateTable(ctx context.Context) (err error) {
+	return ur.table().CreateTable(ctx,
+		options.WithIndex(subscriptionsTopicIndex,
+			options.WithIndexType(options.GlobalIndex()),
+			options.WithIndexColumns("topic", "active"),
+		),
 	)
 }
 
-func (ur *SubscriptionRepo) DeleteByUserID(ctx context.Context, userID uint64) (err error) {
-	defer wrap.Errf("delete subscription by userID %d", &err, userID)
-	query := ur.declarePrimary() + `DELETE FROM ` + ur.table("") + ur.findByFirst()
-	return ur.DB.Table().Do(
-		ctx,
-		func(ctx context.Context, s table.Session) (err error) {
-			_, _, err = s.Execute(ctx, writeTx, query,
-				ur.firstParam(userID),
-				options.WithCollectStatsModeBasic(),
-			)
-			return err
-		},
-	)
+// parseSubscriptionPage validates page request and returns user id to start after.
+func parseSubscriptionPage(cursor string, limit int) (uint64, error) {
+	if limit <= 0 {
+		return 0, fmt.Errorf("limit must be positive, got %d", limit)
+	}
+	if cursor == "" {
+		return 0, nil
+	}
+	after, err := strconv.ParseUint(cursor, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return after, nil
 }
 
-func (ur *SubscriptionRepo) CreateTable(ctx context.Context) (err error) {
-	defer wrap.Err("create table", &err)
-	return ur.DB.Table().Do(
-		ctx,
-		func(ctx context.Context, s table.Session) (err error) {
-			return s.CreateTable(ctx, path.Join(ur.DB.Name(), "subscriptions"),
-				options.WithColumn("user_id", types.Optional(types.TypeUint64)),
-				options.WithColumn("topic", types.Optional(types.TypeUTF8)),
-				options.WithColumn("active", types.Optional(types.TypeBool)),
-				options.WithColumn("created_at", types.Optional(types.TypeDatetime)),
-				options.WithColumn("last_action", types.Optional(types.TypeDatetime)),
-				options.WithPrimaryKeyColumn("user_id", "topic"),
-			)
-		},
-	)
+// subscriptionsPage cuts ss fetched with limit+1 to limit and returns cursor of the next page.
+func subscriptionsPage(ss []*Subscription, limit int) ([]*Subscription, string) {
+	if len(ss) <= limit {
+		return ss, ""
+	}
+	ss = ss[:limit]
+	return ss, strconv.FormatUint(ss[limit-1].UserID, 10)
 }
@@ -8,25 +8,34 @@ import (
 	"time"
 )
 
-var sr *SubscriptionRepo
+var sr SubscriptionStorage
 
 const topic = "topic"
 const topic2 = "topic2"
 const topic3 = "topic3"
+const listedTopic = "listed"
 
 func TestSubscription(t *testing.T) {
-	sr = &SubscriptionRepo{DB: db}
-	t.Run("create", testSubscriptionCreateTable)
-	t.Run("insert", testSubscriptionInsert)
-	t.Run("get", testSubscriptionGet)
-	t.Run("getByUserID", testSubscriptionGetByUserID)
-	t.Run("update", testSubscriptionUpdate)
-	t.Run("delete", testSubscriptionDelete)
-	t.Run("deleteByUserID", testSubscriptionDeleteByUserID)
+	for name, s := range storages() {
+		sr = s.Subscriptions
+		t.Run(name, func(t *testing.T) {
+			if err := createTopics(context.Background(), s, topic, topic2, topic3, listedTopic); err != nil {
+				t.Fatal(err)
+			}
+			t.Run("create", testSubscriptionCreateTable)
+			t.Run("insert", testSubscriptionInsert)
+			t.Run("get", testSubscriptionGet)
+			t.Run("getByUserID", testSubscriptionGetByUserID)
+			t.Run("update", testSubscriptionUpdate)
+			t.Run("delete", testSubscriptionDelete)
+			t.Run("deleteByUserID", testSubscriptionDeleteByUserID)
+			t.Run("listActiveByTopic", testSubscriptionListActiveByTopic)
+		})
+	}
 }
 
 func testSubscriptionCreateTable(t *testing.T) {
-	if err := sr.CreateTable(context.Background()); err != nil {
+	if err := createTable(context.Background(), sr); err != nil {
 		t.Error(err)
 	}
 }
@@ -114,6 +123,17 @@ func testSubscriptionUpdate(t *testing.T) {
 	if u.CreatedAt.After(u.LastAction) {
 		t.Error("subscription from future", u)
 	}
+
+	if err = sr.Upsert(context.Background(), &Subscription{UserID: userID, Topic: topic3, Active: true}); err != nil {
+		t.Error("upsert: ", err)
+	}
+	u, err = sr.Get(context.Background(), userID, topic3)
+	if err != nil {
+		t.Fatal("get: ", err)
+	}
+	if u.CreatedAt.IsZero() {
+		t.Error("upsert of a new subscription has no CreatedAt", u)
+	}
 }
 
 func testSubscriptionDelete(t *testing.T) {
@@ -144,3 +164,68 @@ func testSubscriptionDeleteByUserID(t *testing.T) {
 		t.Error("user topics must by erased", len(ss))
 	}
 }
+
+func testSubscriptionListActiveByTopic(t *testing.T) {
+	ctx := context.Background()
+	var users []uint64
+	for i := uint64(0); i < 5; i++ {
+		u := &Subscription{
+			UserID: userID + 100 + i,
+			Topic:  listedTopic,
+			Active: i != 2,
+		}
+		if err := sr.Insert(ctx, u); err != nil {
+			t.Error(err)
+		}
+		users = append(users, u.UserID)
+	}
+	defer func() {
+		for _, u := range users {
+			if err := sr.DeleteByUserID(ctx, u); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	var got []uint64
+	cursor := ""
+	pages := 0
+	for {
+		ss, next, err := sr.ListActiveByTopic(ctx, listedTopic, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		for _, s := range ss {
+			got = append(got, s.UserID)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	want := []uint64{users[0], users[1], users[3], users[4]}
+	if len(got) != len(want) {
+		t.Fatal("wrong subscribers", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Error("wrong subscribers order", got)
+		}
+	}
+	if pages != 2 {
+		t.Error("wrong pages count", pages)
+	}
+
+	if _, _, err := sr.ListActiveByTopic(ctx, listedTopic, "garbage", 2); err == nil {
+		t.Error("invalid cursor is accepted")
+	}
+
+	count, err := sr.CountActive(ctx, listedTopic)
+	if err != nil {
+		t.Error(err)
+	}
+	if count != 4 {
+		t.Error("wrong count", count)
+	}
+}
@@ -0,0 +1,349 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"github.com/failoverbar/bot/wrap"
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/options"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/result/named"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/types"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+var writeTx = table.TxControl(
+	table.BeginTx(
+		table.WithSerializableReadWrite(),
+	),
+	table.CommitTx(),
+)
+
+// YDBTable implements storage of T in a YDB table described by `ydb:"column[,primary]"` struct tags.
+// Primary key consists of columns tagged as primary in order of fields.
+// Fields are stored as optional columns, pointer fields are NULL when nil.
+// T may implement BeforeInsert and BeforeUpdate hooks, which are called by Insert and Upsert.
+type YDBTable[T any] struct {
+	DB     ydb.Connection
+	Name   string
+	schema *schema
+}
+
+func NewYDBTable[T any](db ydb.Connection, name string) *YDBTable[T] {
+	return &YDBTable[T]{
+		DB:     db,
+		Name:   name,
+		schema: schemaOf[T](),
+	}
+}
+
+type beforeInserter interface {
+	BeforeInsert()
+}
+
+type beforeUpdater interface {
+	BeforeUpdate()
+}
+
+// Get returns the row with the primary key or wrap.NotFoundError.
+func (t *YDBTable[T]) Get(ctx context.Context, key ...interface{}) (v *T, err error) {
+	if len(key) != len(t.schema.primary) {
+		return nil, fmt.Errorf("%s: got %d key values for %d primary columns", t.Name, len(key), len(t.schema.primary))
+	}
+	vs, err := t.Select(ctx, key...)
+	if err != nil {
+		return nil, err
+	}
+	if len(vs) == 0 {
+		return nil, wrap.NotFoundError{}
+	}
+	return vs[0], nil
+}
+
+// Select returns rows which primary key starts with keyPrefix ordered by primary key.
+func (t *YDBTable[T]) Select(ctx context.Context, keyPrefix ...interface{}) (vs []*T, err error) {
+	cols, params, err := t.keyParams(keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	query := t.declare(cols) + `SELECT ` + t.fields(t.schema.columns) + ` FROM ` + t.Name +
+		t.where(cols) + ` ORDER BY ` + t.fields(t.schema.primary)
+	return t.Query(ctx, query, params)
+}
+
+// Query runs arbitrary SELECT returning all columns of the table and scans its rows.
+func (t *YDBTable[T]) Query(ctx context.Context, query string, params *table.QueryParameters) (vs []*T, err error) {
+	res, err := execute(ctx, t.DB, table.DefaultTxControl(), query, params,
+		options.WithCollectStatsModeBasic(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = res.Close()
+	}()
+	for res.NextResultSet(ctx) {
+		for res.NextRow() {
+			v := new(T)
+			if err = res.ScanNamed(t.schema.scanValues(v)...); err != nil {
+				return nil, err
+			}
+			vs = append(vs, v)
+		}
+	}
+	return vs, res.Err()
+}
+
+// Insert adds a row failing with wrap.AlreadyExistsError if the primary key is taken.
+func (t *YDBTable[T]) Insert(ctx context.Context, v *T) (err error) {
+	if h, ok := interface{}(v).(beforeInserter); ok {
+		h.BeforeInsert()
+	}
+	return insertErr(t.write(ctx, `INSERT`, v))
+}
+
+// Upsert adds a row or replaces the existing one.
+func (t *YDBTable[T]) Upsert(ctx context.Context, v *T) (err error) {
+	if h, ok := interface{}(v).(beforeUpdater); ok {
+		h.BeforeUpdate()
+	}
+	return t.write(ctx, `UPSERT`, v)
+}
+
+// Delete removes rows which primary key starts with keyPrefix.
+func (t *YDBTable[T]) Delete(ctx context.Context, keyPrefix ...interface{}) (err error) {
+	cols, params, err := t.keyParams(keyPrefix)
+	if err != nil {
+		return err
+	}
+	query := t.declare(cols) + `DELETE FROM ` + t.Name + t.where(cols)
+	_, err = execute(ctx, t.DB, writeTx, query, params,
+		options.WithCollectStatsModeBasic(),
+	)
+	return err
+}
+
+func (t *YDBTable[T]) CreateTable(ctx context.Context, opts ...options.CreateTableOption) (err error) {
+	defer wrap.Err("create table", &err)
+	for _, c := range t.schema.columns {
+		opts = append(opts, options.WithColumn(c.name, types.Optional(c.kind.typ)))
+	}
+	opts = append(opts, options.WithPrimaryKeyColumn(columnNames(t.schema.primary)...))
+	return t.DB.Table().Do(
+		ctx,
+		func(ctx context.Context, s table.Session) (err error) {
+			return s.CreateTable(ctx, path.Join(t.DB.Name(), t.Name), opts...)
+		},
+	)
+}
+
+func (t *YDBTable[T]) write(ctx context.Context, op string, v *T) (err error) {
+	query := t.declare(t.schema.columns) + op + ` INTO ` + t.Name +
+		` (` + t.fields(t.schema.columns) + `) VALUES (` + t.values(t.schema.columns) + `)`
+	_, err = execute(ctx, t.DB, writeTx, query,
+		table.NewQueryParameters(t.schema.setValues(v)...),
+		options.WithCollectStatsModeBasic(),
+	)
+	return err
+}
+
+func (t *YDBTable[T]) keyParams(keyPrefix []interface{}) ([]column, *table.QueryParameters, error) {
+	if len(keyPrefix) > len(t.schema.primary) {
+		return nil, nil, fmt.Errorf("%s: got %d key values for %d primary columns", t.Name, len(keyPrefix), len(t.schema.primary))
+	}
+	cols := t.schema.primary[:len(keyPrefix)]
+	params := make([]table.ParameterOption, 0, len(cols))
+	for i, c := range cols {
+		v := reflect.ValueOf(keyPrefix[i])
+		if v.Type() != c.goType {
+			return nil, nil, fmt.Errorf("%s: key %s must be %s, got %s", t.Name, c.name, c.goType, v.Type())
+		}
+		params = append(params, table.ValueParam(c.param, c.value(v)))
+	}
+	return cols, table.NewQueryParameters(params...), nil
+}
+
+// declare returns DECLARE statements for parameters of cols.
+func (t *YDBTable[T]) declare(cols []column) string {
+	var b strings.Builder
+	b.WriteString("\n")
+	for _, c := range cols {
+		b.WriteString("\t\tDECLARE " + c.param + " AS " + c.kind.yql)
+		if c.nullable {
+			b.WriteString("?")
+		}
+		b.WriteString(";\n")
+	}
+	return b.String()
+}
+
+func (t *YDBTable[T]) fields(cols []column) string {
+	return ` ` + strings.Join(columnNames(cols), `, `) + ` `
+}
+
+func (t *YDBTable[T]) values(cols []column) string {
+	params := make([]string, 0, len(cols))
+	for _, c := range cols {
+		params = append(params, c.param)
+	}
+	return strings.Join(params, `, `)
+}
+
+func (t *YDBTable[T]) where(cols []column) string {
+	if len(cols) == 0 {
+		return ``
+	}
+	conds := make([]string, 0, len(cols))
+	for _, c := range cols {
+		conds = append(conds, c.name+` = `+c.param)
+	}
+	return ` WHERE ` + strings.Join(conds, ` AND `) + ` `
+}
+
+// schema is a table layout derived from struct tags.
+type schema struct {
+	columns []column
+	primary []column
+}
+
+type column struct {
+	name     string
+	param    string
+	field    int
+	primary  bool
+	nullable bool
+	// noHistory columns are not recorded in history, they are tagged with `history:"-"`.
+	noHistory bool
+	goType    reflect.Type
+	kind      kind
+}
+
+// kind maps Go type to YDB type.
+type kind struct {
+	yql   string
+	typ   types.Type
+	value func(v reflect.Value) types.Value
+}
+
+var kinds = map[reflect.Type]kind{
+	reflect.TypeOf(uint8(0)): {"Uint8", types.TypeUint8, func(v reflect.Value) types.Value {
+		return types.Uint8Value(uint8(v.Uint()))
+	}},
+	reflect.TypeOf(uint32(0)): {"Uint32", types.TypeUint32, func(v reflect.Value) types.Value {
+		return types.Uint32Value(uint32(v.Uint()))
+	}},
+	reflect.TypeOf(uint64(0)): {"Uint64", types.TypeUint64, func(v reflect.Value) types.Value {
+		return types.Uint64Value(v.Uint())
+	}},
+	reflect.TypeOf(int64(0)): {"Int64", types.TypeInt64, func(v reflect.Value) types.Value {
+		return types.Int64Value(v.Int())
+	}},
+	reflect.TypeOf(""): {"Utf8", types.TypeUTF8, func(v reflect.Value) types.Value {
+		return types.UTF8Value(v.String())
+	}},
+	reflect.TypeOf(false): {"Bool", types.TypeBool, func(v reflect.Value) types.Value {
+		return types.BoolValue(v.Bool())
+	}},
+	reflect.TypeOf(time.Time{}): {"Datetime", types.TypeDatetime, func(v reflect.Value) types.Value {
+		return types.DatetimeValueFromTime(v.Interface().(time.Time))
+	}},
+}
+
+// value converts field value of the column to YDB value.
+func (c column) value(v reflect.Value) types.Value {
+	if !c.nullable {
+		return c.kind.value(v)
+	}
+	if v.IsNil() {
+		return types.NullValue(c.kind.typ)
+	}
+	return types.OptionalValue(c.kind.value(v.Elem()))
+}
+
+func columnNames(cols []column) []string {
+	res := make([]string, 0, len(cols))
+	for _, c := range cols {
+		res = append(res, c.name)
+	}
+	return res
+}
+
+var schemas = struct {
+	sync.Mutex
+	m map[reflect.Type]*schema
+}{m: map[reflect.Type]*schema{}}
+
+// schemaOf parses `ydb` tags of T. It panics on unsupported field types
+// because it is a programming error found on the first use of the table.
+func schemaOf[T any]() *schema {
+	rt := reflect.TypeOf((*T)(nil)).Elem()
+	schemas.Lock()
+	defer schemas.Unlock()
+	if s, ok := schemas.m[rt]; ok {
+		return s
+	}
+	s := &schema{}
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		tag, ok := f.Tag.Lookup("ydb")
+		if !ok || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		c := column{
+			name:      name,
+			param:     "$" + f.Name,
+			field:     i,
+			primary:   opts == "primary",
+			noHistory: f.Tag.Get("history") == "-",
+			goType:    f.Type,
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			c.nullable = true
+			ft = ft.Elem()
+		}
+		if c.kind, ok = kinds[ft]; !ok {
+			panic(fmt.Sprintf("model: unsupported type %s of %s.%s", f.Type, rt.Name(), f.Name))
+		}
+		if c.primary && c.nullable {
+			panic(fmt.Sprintf("model: primary key %s.%s can't be nullable", rt.Name(), f.Name))
+		}
+		s.columns = append(s.columns, c)
+		if c.primary {
+			s.primary = append(s.primary, c)
+		}
+	}
+	if len(s.primary) == 0 {
+		panic(fmt.Sprintf("model: %s has no primary key", rt.Name()))
+	}
+	schemas.m[rt] = s
+	return s
+}
+
+func (s *schema) scanValues(v interface{}) []named.Value {
+	rv := reflect.ValueOf(v).Elem()
+	res := make([]named.Value, 0, len(s.columns))
+	for _, c := range s.columns {
+		ptr := rv.Field(c.field).Addr().Interface()
+		if c.nullable {
+			res = append(res, named.Optional(c.name, ptr))
+		} else {
+			res = append(res, named.OptionalWithDefault(c.name, ptr))
+		}
+	}
+	return res
+}
+
+func (s *schema) setValues(v interface{}) []table.ParameterOption {
+	rv := reflect.ValueOf(v).Elem()
+	res := make([]table.ParameterOption, 0, len(s.columns))
+	for _, c := range s.columns {
+		res = append(res, table.ValueParam(c.param, c.value(rv.Field(c.field))))
+	}
+	return res
+}
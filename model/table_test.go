@@ -0,0 +1,58 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	st := NewYDBTable[Subscription](nil, "subscriptions")
+	if got := columnNames(st.schema.primary); strings.Join(got, ",") != "user_id,topic" {
+		t.Error("wrong primary key", got)
+	}
+	if got := st.fields(st.schema.columns); got != " user_id, topic, active, created_at, last_action " {
+		t.Error("wrong fields", got)
+	}
+	if got := st.values(st.schema.columns); got != "$UserID, $Topic, $Active, $CreatedAt, $LastAction" {
+		t.Error("wrong values", got)
+	}
+	if got := st.where(st.schema.primary[:1]); got != " WHERE user_id = $UserID " {
+		t.Error("wrong where", got)
+	}
+
+	pt := NewYDBTable[Profile](nil, "profiles")
+	declare := pt.declare(pt.schema.columns)
+	for _, d := range []string{"DECLARE $UserID AS Uint64;", "DECLARE $Name AS Utf8?;", "DECLARE $Source AS Utf8;"} {
+		if !strings.Contains(declare, d) {
+			t.Error("no declaration", d, "in", declare)
+		}
+	}
+
+	if _, _, err := st.keyParams([]interface{}{userID, 1}); err == nil {
+		t.Error("wrong key type is accepted")
+	}
+	if _, _, err := st.keyParams([]interface{}{userID, topic, topic}); err == nil {
+		t.Error("too long key is accepted")
+	}
+}
+
+func TestSchemaPanics(t *testing.T) {
+	type noPrimary struct {
+		ID uint64 `ydb:"id"`
+	}
+	type unsupported struct {
+		ID    uint64    `ydb:"id,primary"`
+		Value complex64 `ydb:"value"`
+	}
+	assertPanics(t, "no primary", func() { schemaOf[noPrimary]() })
+	assertPanics(t, "unsupported", func() { schemaOf[unsupported]() })
+}
+
+func assertPanics(t *testing.T, name string, f func()) {
+	defer func() {
+		if recover() == nil {
+			t.Error("panic expected:", name)
+		}
+	}()
+	f()
+}
@@ -7,19 +7,23 @@ import (
 	"testing"
 )
 
-var tpr *TelegramProfileRepo
+var tpr TelegramProfileStorage
 
 func TestTelegramProfile(t *testing.T) {
-	tpr = &TelegramProfileRepo{DB: db}
-	t.Run("create", testTelegramProfileCreateTable)
-	t.Run("insert", testTelegramProfileInsert)
-	t.Run("get", testTelegramProfileGet)
-	t.Run("update", testTelegramProfileUpdate)
-	t.Run("delete", testTelegramProfileDelete)
+	for name, s := range storages() {
+		tpr = s.TelegramProfiles
+		t.Run(name, func(t *testing.T) {
+			t.Run("create", testTelegramProfileCreateTable)
+			t.Run("insert", testTelegramProfileInsert)
+			t.Run("get", testTelegramProfileGet)
+			t.Run("update", testTelegramProfileUpdate)
+			t.Run("delete", testTelegramProfileDelete)
+		})
+	}
 }
 
 func testTelegramProfileCreateTable(t *testing.T) {
-	if err := tpr.CreateTable(context.Background()); err != nil {
+	if err := createTable(context.Background(), tpr); err != nil {
 		t.Error(err)
 	}
 }
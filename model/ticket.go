@@ -0,0 +1,266 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"github.com/failoverbar/bot/wrap"
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"sort"
+	"time"
+)
+
+// Statuses of Ticket.
+const (
+	TicketOpen   = "open"
+	TicketClosed = "closed"
+)
+
+// Ticket is a conversation of the user with staff in the support chat.
+// Messages the user sends outside dialogs go to the open ticket, the next message after closing opens a new one.
+type Ticket struct {
+	UserID uint64 `ydb:"user_id,primary"`
+	// ID grows with time, so tickets of a user are ordered chronologically.
+	ID uint64 `ydb:"id,primary"`
+
+	Status string `ydb:"status"`
+	// ThreadID is the message in the support chat which messages of the ticket reply to.
+	ThreadID uint64 `ydb:"thread_id"`
+	// ClosedBy is the staff member who closed the ticket.
+	ClosedBy uint64 `ydb:"closed_by"`
+
+	CreatedAt time.Time `ydb:"created_at"`
+	UpdatedAt time.Time `ydb:"updated_at"`
+}
+
+func (t *Ticket) BeforeInsert() {
+	if t.ID == 0 {
+		t.ID = nextID()
+	}
+	t.CreatedAt = time.Now()
+	t.BeforeUpdate()
+}
+
+func (t *Ticket) BeforeUpdate() {
+	t.UpdatedAt = time.Now()
+}
+
+// TicketMessage links a message in the support chat to the ticket, so that replies to it reach the user.
+type TicketMessage struct {
+	MessageID uint64 `ydb:"message_id,primary"`
+
+	UserID   uint64 `ydb:"user_id"`
+	TicketID uint64 `ydb:"ticket_id"`
+}
+
+type TicketStorage interface {
+	Get(ctx context.Context, userID, id uint64) (*Ticket, error)
+	// GetByUserID returns tickets of the user ordered by ID.
+	GetByUserID(ctx context.Context, userID uint64) ([]*Ticket, error)
+	Insert(ctx context.Context, t *Ticket) error
+	Upsert(ctx context.Context, t *Ticket) error
+	// DeleteByUserID deletes tickets of the user. Their messages are left, they only refer to the support chat.
+	DeleteByUserID(ctx context.Context, userID uint64) error
+
+	AddMessage(ctx context.Context, m *TicketMessage) error
+	GetMessage(ctx context.Context, messageID uint64) (*TicketMessage, error)
+}
+
+var (
+	_ TicketStorage = (*TicketRepo)(nil)
+	_ TicketStorage = (*MemoryTicketRepo)(nil)
+	_ TicketStorage = (*PgTicketRepo)(nil)
+)
+
+type TicketRepo struct {
+	DB ydb.Connection
+}
+
+func (ur *TicketRepo) table() *YDBTable[Ticket] {
+	return NewYDBTable[Ticket](ur.DB, "tickets")
+}
+
+func (ur *TicketRepo) messages() *YDBTable[TicketMessage] {
+	return NewYDBTable[TicketMessage](ur.DB, "ticket_messages")
+}
+
+func (ur *TicketRepo) Get(ctx context.Context, userID, id uint64) (t *Ticket, err error) {
+	defer wrap.Errf("get ticket %d of %d", &err, id, userID)
+	return ur.table().Get(ctx, userID, id)
+}
+
+func (ur *TicketRepo) GetByUserID(ctx context.Context, userID uint64) (ts []*Ticket, err error) {
+	defer wrap.Errf("get tickets by userID %d", &err, userID)
+	return ur.table().Select(ctx, userID)
+}
+
+func (ur *TicketRepo) Insert(ctx context.Context, t *Ticket) (err error) {
+	defer wrap.Errf("insert ticket of %d", &err, t.UserID)
+	return ur.table().Insert(ctx, t)
+}
+
+func (ur *TicketRepo) Upsert(ctx context.Context, t *Ticket) (err error) {
+	defer wrap.Errf("upsert ticket %d of %d", &err, t.ID, t.UserID)
+	return ur.table().Upsert(ctx, t)
+}
+
+func (ur *TicketRepo) DeleteByUserID(ctx context.Context, userID uint64) (err error) {
+	defer wrap.Errf("delete tickets by userID %d", &err, userID)
+	return ur.table().Delete(ctx, userID)
+}
+
+func (ur *TicketRepo) AddMessage(ctx context.Context, m *TicketMessage) (err error) {
+	defer wrap.Errf("add message %d to ticket %d", &err, m.MessageID, m.TicketID)
+	return ur.messages().Upsert(ctx, m)
+}
+
+func (ur *TicketRepo) GetMessage(ctx context.Context, messageID uint64) (m *TicketMessage, err error) {
+	defer wrap.Errf("get ticket message %d", &err, messageID)
+	return ur.messages().Get(ctx, messageID)
+}
+
+func (ur *TicketRepo) CreateTable(ctx context.Context) (err error) {
+	if err = ur.table().CreateTable(ctx); err != nil {
+		return err
+	}
+	return ur.messages().CreateTable(ctx)
+}
+
+type MemoryTicketRepo struct {
+	DB *MemoryDB
+}
+
+type ticketKey struct {
+	userID uint64
+	id     uint64
+}
+
+func (ur *MemoryTicketRepo) Get(_ context.Context, userID, id uint64) (t *Ticket, err error) {
+	defer wrap.Errf("get ticket %d of %d", &err, id, userID)
+	ur.DB.mu.RLock()
+	defer ur.DB.mu.RUnlock()
+	stored, ok := ur.DB.tickets[ticketKey{userID, id}]
+	if !ok {
+		return nil, wrap.NotFoundError{}
+	}
+	return &stored, nil
+}
+
+func (ur *MemoryTicketRepo) GetByUserID(_ context.Context, userID uint64) ([]*Ticket, error) {
+	ur.DB.mu.RLock()
+	defer ur.DB.mu.RUnlock()
+	var ts []*Ticket
+	for k, v := range ur.DB.tickets {
+		if k.userID == userID {
+			t := v
+			ts = append(ts, &t)
+		}
+	}
+	sort.Slice(ts, func(i, j int) bool {
+		return ts[i].ID < ts[j].ID
+	})
+	return ts, nil
+}
+
+func (ur *MemoryTicketRepo) Insert(ctx context.Context, t *Ticket) (err error) {
+	defer wrap.Errf("insert ticket of %d", &err, t.UserID)
+	t.BeforeInsert()
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	key := ticketKey{t.UserID, t.ID}
+	if _, ok := ur.DB.tickets[key]; ok {
+		return wrap.AlreadyExistsError{}
+	}
+	ur.DB.tickets[key] = ur.stored(t)
+	return nil
+}
+
+func (ur *MemoryTicketRepo) Upsert(ctx context.Context, t *Ticket) error {
+	t.BeforeUpdate()
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	ur.DB.tickets[ticketKey{t.UserID, t.ID}] = ur.stored(t)
+	return nil
+}
+
+func (ur *MemoryTicketRepo) stored(t *Ticket) Ticket {
+	stored := *t
+	stored.CreatedAt = datetime(t.CreatedAt)
+	stored.UpdatedAt = datetime(t.UpdatedAt)
+	return stored
+}
+
+func (ur *MemoryTicketRepo) DeleteByUserID(ctx context.Context, userID uint64) error {
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	for k := range ur.DB.tickets {
+		if k.userID == userID {
+			delete(ur.DB.tickets, k)
+		}
+	}
+	return nil
+}
+
+func (ur *MemoryTicketRepo) AddMessage(ctx context.Context, m *TicketMessage) error {
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	ur.DB.ticketMessages[m.MessageID] = *m
+	return nil
+}
+
+func (ur *MemoryTicketRepo) GetMessage(_ context.Context, messageID uint64) (m *TicketMessage, err error) {
+	defer wrap.Errf("get ticket message %d", &err, messageID)
+	ur.DB.mu.RLock()
+	defer ur.DB.mu.RUnlock()
+	stored, ok := ur.DB.ticketMessages[messageID]
+	if !ok {
+		return nil, wrap.NotFoundError{}
+	}
+	return &stored, nil
+}
+
+type PgTicketRepo struct {
+	DB *sql.DB
+}
+
+func (ur *PgTicketRepo) table() *PgTable[Ticket] {
+	return NewPgTable[Ticket](ur.DB, "tickets")
+}
+
+func (ur *PgTicketRepo) messages() *PgTable[TicketMessage] {
+	return NewPgTable[TicketMessage](ur.DB, "ticket_messages")
+}
+
+func (ur *PgTicketRepo) Get(ctx context.Context, userID, id uint64) (t *Ticket, err error) {
+	defer wrap.Errf("get ticket %d of %d", &err, id, userID)
+	return ur.table().Get(ctx, userID, id)
+}
+
+func (ur *PgTicketRepo) GetByUserID(ctx context.Context, userID uint64) (ts []*Ticket, err error) {
+	defer wrap.Errf("get tickets by userID %d", &err, userID)
+	return ur.table().Select(ctx, userID)
+}
+
+func (ur *PgTicketRepo) Insert(ctx context.Context, t *Ticket) (err error) {
+	defer wrap.Errf("insert ticket of %d", &err, t.UserID)
+	return ur.table().Insert(ctx, t)
+}
+
+func (ur *PgTicketRepo) Upsert(ctx context.Context, t *Ticket) (err error) {
+	defer wrap.Errf("upsert ticket %d of %d", &err, t.ID, t.UserID)
+	return ur.table().Upsert(ctx, t)
+}
+
+func (ur *PgTicketRepo) DeleteByUserID(ctx context.Context, userID uint64) (err error) {
+	defer wrap.Errf("delete tickets by userID %d", &err, userID)
+	return ur.table().Delete(ctx, userID)
+}
+
+func (ur *PgTicketRepo) AddMessage(ctx context.Context, m *TicketMessage) (err error) {
+	defer wrap.Errf("add message %d to ticket %d", &err, m.MessageID, m.TicketID)
+	return ur.messages().Upsert(ctx, m)
+}
+
+func (ur *PgTicketRepo) GetMessage(ctx context.Context, messageID uint64) (m *TicketMessage, err error) {
+	defer wrap.Errf("get ticket message %d", &err, messageID)
+	return ur.messages().Get(ctx, messageID)
+}
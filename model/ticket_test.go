@@ -0,0 +1,64 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"github.com/failoverbar/bot/wrap"
+	"testing"
+)
+
+const ticketUserID = userID + 9
+
+func TestTicket(t *testing.T) {
+	for name, s := range storages() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := createTable(ctx, s.Tickets); err != nil {
+				t.Fatal(err)
+			}
+			first := &Ticket{UserID: ticketUserID, Status: TicketOpen, ThreadID: 100}
+			if err := s.Tickets.Insert(ctx, first); err != nil {
+				t.Fatal(err)
+			}
+			first.Status, first.ClosedBy = TicketClosed, userID
+			if err := s.Tickets.Upsert(ctx, first); err != nil {
+				t.Fatal(err)
+			}
+			second := &Ticket{UserID: ticketUserID, Status: TicketOpen, ThreadID: 200}
+			if err := s.Tickets.Insert(ctx, second); err != nil {
+				t.Fatal(err)
+			}
+
+			ts, err := s.Tickets.GetByUserID(ctx, ticketUserID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ts) != 2 || ts[0].ID != first.ID || ts[0].Status != TicketClosed || ts[0].ClosedBy != userID ||
+				ts[1].ID != second.ID || ts[1].ThreadID != 200 || ts[1].CreatedAt.IsZero() {
+				t.Errorf("wrong tickets %+v", ts)
+			}
+			got, err := s.Tickets.Get(ctx, ticketUserID, second.ID)
+			if err != nil || got.Status != TicketOpen {
+				t.Error("wrong ticket", got, err)
+			}
+
+			if err = s.Tickets.AddMessage(ctx, &TicketMessage{MessageID: 201, UserID: ticketUserID, TicketID: second.ID}); err != nil {
+				t.Fatal(err)
+			}
+			m, err := s.Tickets.GetMessage(ctx, 201)
+			if err != nil || m.UserID != ticketUserID || m.TicketID != second.ID {
+				t.Error("wrong message", m, err)
+			}
+			if _, err = s.Tickets.GetMessage(ctx, 202); !errors.Is(err, wrap.NotFoundError{}) {
+				t.Error("not not_found error", err)
+			}
+
+			if err = s.Tickets.DeleteByUserID(ctx, ticketUserID); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = s.Tickets.Get(ctx, ticketUserID, second.ID); !errors.Is(err, wrap.NotFoundError{}) {
+				t.Error("ticket is not deleted", err)
+			}
+		})
+	}
+}
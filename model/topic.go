@@ -0,0 +1,242 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/failoverbar/bot/wrap"
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"sort"
+	"time"
+)
+
+// Topic is an entry of the catalog users subscribe to.
+type Topic struct {
+	Slug string `ydb:"slug,primary"`
+
+	Title       string `ydb:"title"`
+	Description string `ydb:"description"`
+	// DefaultOn topics are preselected when a guest chooses topics on registration.
+	DefaultOn bool `ydb:"default_on"`
+	// Hidden topics are not listed, but can be subscribed to by a deep link.
+	Hidden bool `ydb:"hidden"`
+	// Archived topics can't be subscribed to anymore.
+	Archived  bool   `ydb:"archived"`
+	SortOrder uint32 `ydb:"sort_order"`
+
+	CreatedAt time.Time `ydb:"created_at" history:"-"`
+	UpdatedAt time.Time `ydb:"updated_at" history:"-"`
+}
+
+func (t *Topic) BeforeInsert() {
+	t.CreatedAt = time.Now()
+	t.BeforeUpdate()
+}
+
+// BeforeUpdate also sets CreatedAt of topics upserted without being read, as seeded ones are.
+func (t *Topic) BeforeUpdate() {
+	t.UpdatedAt = time.Now()
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = t.UpdatedAt
+	}
+}
+
+// Listed tells whether the topic is shown to users.
+func (t *Topic) Listed() bool {
+	return !t.Hidden && !t.Archived
+}
+
+type TopicStorage interface {
+	Get(ctx context.Context, slug string) (*Topic, error)
+	// List returns all topics ordered by SortOrder and Slug.
+	List(ctx context.Context) ([]*Topic, error)
+	Insert(ctx context.Context, t *Topic) error
+	Upsert(ctx context.Context, t *Topic) error
+}
+
+var (
+	_ TopicStorage = (*TopicRepo)(nil)
+	_ TopicStorage = (*MemoryTopicRepo)(nil)
+	_ TopicStorage = (*PgTopicRepo)(nil)
+)
+
+func sortTopics(ts []*Topic) {
+	sort.SliceStable(ts, func(i, j int) bool {
+		if ts[i].SortOrder != ts[j].SortOrder {
+			return ts[i].SortOrder < ts[j].SortOrder
+		}
+		return ts[i].Slug < ts[j].Slug
+	})
+}
+
+// withTopics makes subscriptions of s check their topics in s.Topics.
+func withTopics(s Storage) Storage {
+	s.Subscriptions = &knownTopics{SubscriptionStorage: s.Subscriptions, topics: s.Topics}
+	return s
+}
+
+// topicsDecorator is implemented by repositories referring to the topic catalog.
+type topicsDecorator interface {
+	unwrap() (repo interface{}, topics TopicStorage)
+}
+
+// knownTopics makes subscriptions refer only to topics of the catalog which are not archived.
+// Subscriptions may still be deactivated after their topic is archived.
+type knownTopics struct {
+	SubscriptionStorage
+	topics TopicStorage
+}
+
+func (s *knownTopics) unwrap() (repo interface{}, topics TopicStorage) {
+	return s.SubscriptionStorage, s.topics
+}
+
+func (s *knownTopics) Insert(ctx context.Context, u *Subscription) error {
+	if err := s.check(ctx, u); err != nil {
+		return err
+	}
+	return s.SubscriptionStorage.Insert(ctx, u)
+}
+
+func (s *knownTopics) Upsert(ctx context.Context, u *Subscription) error {
+	if err := s.check(ctx, u); err != nil {
+		return err
+	}
+	return s.SubscriptionStorage.Upsert(ctx, u)
+}
+
+func (s *knownTopics) check(ctx context.Context, u *Subscription) (err error) {
+	if !u.Active {
+		return nil
+	}
+	defer wrap.Errf("subscribe %d to %s", &err, u.UserID, u.Topic)
+	t, err := s.topics.Get(ctx, u.Topic)
+	if errors.Is(err, wrap.NotFoundError{}) {
+		return wrap.UnknownReferenceError{}
+	}
+	if err != nil {
+		return err
+	}
+	if t.Archived {
+		return wrap.UnknownReferenceError{}
+	}
+	return nil
+}
+
+type TopicRepo struct {
+	DB ydb.Connection
+}
+
+func (ur *TopicRepo) table() *YDBTable[Topic] {
+	return NewYDBTable[Topic](ur.DB, "topics")
+}
+
+func (ur *TopicRepo) Get(ctx context.Context, slug string) (t *Topic, err error) {
+	defer wrap.Errf("get topic %s", &err, slug)
+	return ur.table().Get(ctx, slug)
+}
+
+func (ur *TopicRepo) List(ctx context.Context) (ts []*Topic, err error) {
+	defer wrap.Err("list topics", &err)
+	ts, err = ur.table().Select(ctx)
+	sortTopics(ts)
+	return ts, err
+}
+
+func (ur *TopicRepo) Insert(ctx context.Context, t *Topic) (err error) {
+	defer wrap.Errf("insert topic %s", &err, t.Slug)
+	return ur.table().Insert(ctx, t)
+}
+
+func (ur *TopicRepo) Upsert(ctx context.Context, t *Topic) (err error) {
+	defer wrap.Errf("upsert topic %s", &err, t.Slug)
+	return ur.table().Upsert(ctx, t)
+}
+
+func (ur *TopicRepo) CreateTable(ctx context.Context) (err error) {
+	return ur.table().CreateTable(ctx)
+}
+
+type MemoryTopicRepo struct {
+	DB *MemoryDB
+}
+
+func (ur *MemoryTopicRepo) Get(_ context.Context, slug string) (t *Topic, err error) {
+	defer wrap.Errf("get topic %s", &err, slug)
+	ur.DB.mu.RLock()
+	defer ur.DB.mu.RUnlock()
+	stored, ok := ur.DB.topics[slug]
+	if !ok {
+		return nil, wrap.NotFoundError{}
+	}
+	return &stored, nil
+}
+
+func (ur *MemoryTopicRepo) List(_ context.Context) ([]*Topic, error) {
+	ur.DB.mu.RLock()
+	defer ur.DB.mu.RUnlock()
+	ts := make([]*Topic, 0, len(ur.DB.topics))
+	for _, stored := range ur.DB.topics {
+		t := stored
+		ts = append(ts, &t)
+	}
+	sortTopics(ts)
+	return ts, nil
+}
+
+func (ur *MemoryTopicRepo) Insert(ctx context.Context, t *Topic) (err error) {
+	defer wrap.Errf("insert topic %s", &err, t.Slug)
+	t.BeforeInsert()
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	if _, ok := ur.DB.topics[t.Slug]; ok {
+		return wrap.AlreadyExistsError{}
+	}
+	ur.DB.topics[t.Slug] = ur.stored(t)
+	return nil
+}
+
+func (ur *MemoryTopicRepo) Upsert(ctx context.Context, t *Topic) error {
+	t.BeforeUpdate()
+	ur.DB.lock(ctx)
+	defer ur.DB.unlock(ctx)
+	ur.DB.topics[t.Slug] = ur.stored(t)
+	return nil
+}
+
+func (ur *MemoryTopicRepo) stored(t *Topic) Topic {
+	res := *t
+	res.CreatedAt = datetime(t.CreatedAt)
+	res.UpdatedAt = datetime(t.UpdatedAt)
+	return res
+}
+
+type PgTopicRepo struct {
+	DB *sql.DB
+}
+
+func (ur *PgTopicRepo) table() *PgTable[Topic] {
+	return NewPgTable[Topic](ur.DB, "topics")
+}
+
+func (ur *PgTopicRepo) Get(ctx context.Context, slug string) (t *Topic, err error) {
+	defer wrap.Errf("get topic %s", &err, slug)
+	return ur.table().Get(ctx, slug)
+}
+
+func (ur *PgTopicRepo) List(ctx context.Context) (ts []*Topic, err error) {
+	defer wrap.Err("list topics", &err)
+	ts, err = ur.table().Select(ctx)
+	sortTopics(ts)
+	return ts, err
+}
+
+func (ur *PgTopicRepo) Insert(ctx context.Context, t *Topic) (err error) {
+	defer wrap.Errf("insert topic %s", &err, t.Slug)
+	return ur.table().Insert(ctx, t)
+}
+
+func (ur *PgTopicRepo) Upsert(ctx context.Context, t *Topic) (err error) {
+	defer wrap.Errf("upsert topic %s", &err, t.Slug)
+	return ur.table().Upsert(ctx, t)
+}
@@ -0,0 +1,91 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"github.com/failoverbar/bot/wrap"
+	"strings"
+	"testing"
+)
+
+const topicUserID = userID + 5
+
+func TestTopic(t *testing.T) {
+	for name, s := range storages() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := createTable(ctx, s.Subscriptions); err != nil {
+				t.Fatal(err)
+			}
+			topics := []*Topic{
+				{Slug: "catalog_b", Title: "B", SortOrder: 1},
+				{Slug: "catalog_a", Title: "A", SortOrder: 1, DefaultOn: true},
+				{Slug: "catalog_first", Title: "First"},
+				{Slug: "catalog_hidden", Title: "Hidden", Hidden: true, SortOrder: 2},
+				{Slug: "catalog_archived", Title: "Archived", Archived: true, SortOrder: 2},
+			}
+			for _, topic := range topics {
+				if err := s.Topics.Upsert(ctx, topic); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := s.Topics.Insert(ctx, &Topic{Slug: "catalog_a"}); !errors.Is(err, wrap.AlreadyExistsError{}) {
+				t.Error("duplicate topic is inserted", err)
+			}
+
+			got, err := s.Topics.Get(ctx, "catalog_a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Title != "A" || !got.DefaultOn || got.CreatedAt.IsZero() || !got.Listed() {
+				t.Error("wrong topic", got)
+			}
+			if _, err = s.Topics.Get(ctx, "catalog_missing"); !errors.Is(err, wrap.NotFoundError{}) {
+				t.Error("not not_found error", err)
+			}
+
+			list, err := s.Topics.List(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var order []string
+			for _, topic := range list {
+				if strings.HasPrefix(topic.Slug, "catalog_") {
+					order = append(order, topic.Slug)
+				}
+			}
+			want := []string{"catalog_first", "catalog_a", "catalog_b", "catalog_archived", "catalog_hidden"}
+			if len(order) != len(want) {
+				t.Fatal("wrong topics", order)
+			}
+			for i := range want {
+				if order[i] != want[i] {
+					t.Error("wrong topics order", order)
+					break
+				}
+			}
+
+			if err = s.Subscriptions.DeleteByUserID(ctx, topicUserID); err != nil {
+				t.Fatal(err)
+			}
+			for _, slug := range []string{"catalog_a", "catalog_hidden"} {
+				if err = s.Subscriptions.Upsert(ctx, &Subscription{UserID: topicUserID, Topic: slug, Active: true}); err != nil {
+					t.Error("can't subscribe to", slug, err)
+				}
+			}
+			for _, slug := range []string{"catalog_missing", "catalog_archived"} {
+				err = s.Subscriptions.Upsert(ctx, &Subscription{UserID: topicUserID, Topic: slug, Active: true})
+				if !errors.Is(err, wrap.UnknownReferenceError{}) {
+					t.Error("subscribed to", slug, err)
+				}
+			}
+			// Subscriptions to archived topics can still be paused.
+			if err = s.Subscriptions.Upsert(ctx, &Subscription{UserID: topicUserID, Topic: "catalog_archived"}); err != nil {
+				t.Error(err)
+			}
+			if err = s.Subscriptions.DeleteByUserID(ctx, topicUserID); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package model
+
+import (
+	"context"
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/options"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/result"
+)
+
+// Transactor runs several repository operations as one unit of work.
+type Transactor interface {
+	// InTx runs f in a serializable transaction. Repository calls made with the ctx passed to f
+	// take part in the transaction, which is committed if f returns nil and rolled back otherwise.
+	// f may be called several times if the transaction is retried, so it must not have side effects
+	// besides repository calls. Nested InTx calls join the outer transaction.
+	InTx(ctx context.Context, f func(ctx context.Context) error) error
+}
+
+var (
+	_ Transactor = (*YDBTransactor)(nil)
+	_ Transactor = (*MemoryTransactor)(nil)
+	_ Transactor = (*PgTransactor)(nil)
+)
+
+type txKey struct{}
+
+// YDBTransactor runs transactions in YDB.
+// YDB forbids reading a table after it was modified in the same transaction,
+// so reads have to go before writes of the same table.
+type YDBTransactor struct {
+	DB ydb.Connection
+}
+
+func (t *YDBTransactor) InTx(ctx context.Context, f func(ctx context.Context) error) error {
+	return inTx(ctx, t.DB, f)
+}
+
+func inTx(ctx context.Context, db ydb.Connection, f func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(table.TransactionActor); ok {
+		return f(ctx)
+	}
+	return db.Table().DoTx(
+		ctx,
+		func(ctx context.Context, tx table.TransactionActor) error {
+			return f(context.WithValue(ctx, txKey{}, tx))
+		},
+		table.WithIdempotent(),
+	)
+}
+
+// execute runs query in the transaction bound to ctx, or with txc in a new session if there is none.
+func execute(
+	ctx context.Context,
+	db ydb.Connection,
+	txc *table.TransactionControl,
+	query string,
+	params *table.QueryParameters,
+	opts ...options.ExecuteDataQueryOption,
+) (res result.Result, err error) {
+	if tx, ok := ctx.Value(txKey{}).(table.TransactionActor); ok {
+		return tx.Execute(ctx, query, params, opts...)
+	}
+	err = db.Table().Do(ctx, func(ctx context.Context, s table.Session) (err error) {
+		_, res, err = s.Execute(ctx, txc, query, params, opts...)
+		return err
+	})
+	return res, err
+}
+
+type memoryTxKey struct{}
+
+// MemoryTransactor runs transactions over MemoryDB. Transactions are executed one at a time
+// and rolled back by restoring a snapshot of all tables. Writes outside of transactions wait
+// for the running one, so the rollback never discards them, while reads don't and may see
+// uncommitted changes. It is good enough for tests and local runs.
+type MemoryTransactor struct {
+	DB *MemoryDB
+}
+
+func (t *MemoryTransactor) InTx(ctx context.Context, f func(ctx context.Context) error) error {
+	if ctx.Value(memoryTxKey{}) != nil {
+		return f(ctx)
+	}
+	t.DB.txMu.Lock()
+	defer t.DB.txMu.Unlock()
+	snapshot := t.DB.snapshot()
+	if err := f(context.WithValue(ctx, memoryTxKey{}, true)); err != nil {
+		t.DB.restore(snapshot)
+		return err
+	}
+	return nil
+}
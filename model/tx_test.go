@@ -0,0 +1,98 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"github.com/failoverbar/bot/wrap"
+	"testing"
+	"time"
+)
+
+const txUserID = userID + 1
+
+var errRollback = errors.New("rollback")
+
+func TestTx(t *testing.T) {
+	for name, s := range storages() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := createTable(ctx, s.Users); err != nil {
+				t.Fatal(err)
+			}
+			if err := createTable(ctx, s.Profiles); err != nil {
+				t.Fatal(err)
+			}
+
+			err := s.Tx.InTx(ctx, func(ctx context.Context) error {
+				if err := s.Users.Insert(ctx, &User{UserID: txUserID}); err != nil {
+					return err
+				}
+				if err := s.Profiles.Upsert(ctx, &Profile{UserID: txUserID}); err != nil {
+					return err
+				}
+				return errRollback
+			})
+			if !errors.Is(err, errRollback) {
+				t.Error("not rollback error", err)
+			}
+			if _, err = s.Users.Get(ctx, txUserID); !errors.Is(err, wrap.NotFoundError{}) {
+				t.Error("user is not rolled back", err)
+			}
+			if _, err = s.Profiles.Get(ctx, txUserID); !errors.Is(err, wrap.NotFoundError{}) {
+				t.Error("profile is not rolled back", err)
+			}
+
+			err = s.Tx.InTx(ctx, func(ctx context.Context) error {
+				if err := s.Users.Insert(ctx, &User{UserID: txUserID}); err != nil {
+					return err
+				}
+				return s.Profiles.Upsert(ctx, &Profile{UserID: txUserID})
+			})
+			if err != nil {
+				t.Error("commit: ", err)
+			}
+			if _, err = s.Users.Get(ctx, txUserID); err != nil {
+				t.Error("user is not committed", err)
+			}
+			if _, err = s.Profiles.Get(ctx, txUserID); err != nil {
+				t.Error("profile is not committed", err)
+			}
+
+			if err = s.Users.Delete(ctx, txUserID); err != nil {
+				t.Error(err)
+			}
+			if err = s.Profiles.Delete(ctx, txUserID); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestMemoryTxRollbackKeepsOtherWrites(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStorage()
+	written := make(chan error)
+	err := s.Tx.InTx(ctx, func(txCtx context.Context) error {
+		if err := s.Users.Insert(txCtx, &User{UserID: txUserID}); err != nil {
+			return err
+		}
+		go func() {
+			written <- s.Bans.Upsert(ctx, &Ban{UserID: txUserID})
+		}()
+		// Give the write a chance to run before the rollback.
+		time.Sleep(10 * time.Millisecond)
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Error("not rollback error", err)
+	}
+	if err = <-written; err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Users.Get(ctx, txUserID); !errors.Is(err, wrap.NotFoundError{}) {
+		t.Error("user is not rolled back", err)
+	}
+	if _, err = s.Bans.Get(ctx, txUserID); err != nil {
+		t.Error("write outside of the transaction is lost", err)
+	}
+}
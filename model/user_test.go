@@ -8,21 +8,27 @@ import (
 	"time"
 )
 
-var ur *UserRepo
+var ur UserStorage
 
 const userID = uint64(123)
 
 func TestUser(t *testing.T) {
-	ur = &UserRepo{DB: db}
-	t.Run("create", testUserCreateTable)
-	t.Run("insert", testUserInsert)
-	t.Run("get", testUserGet)
-	t.Run("update", testUserUpdate)
-	t.Run("delete", testUserDelete)
+	for name, s := range storages() {
+		ur = s.Users
+		t.Run(name, func(t *testing.T) {
+			t.Run("create", testUserCreateTable)
+			t.Run("insert", testUserInsert)
+			t.Run("insertDuplicate", testUserInsertDuplicate)
+			t.Run("get", testUserGet)
+			t.Run("update", testUserUpdate)
+			t.Run("updateVersion", testUserUpdateVersion)
+			t.Run("delete", testUserDelete)
+		})
+	}
 }
 
 func testUserCreateTable(t *testing.T) {
-	if err := ur.CreateTable(context.Background()); err != nil {
+	if err := createTable(context.Background(), ur); err != nil {
 		t.Error(err)
 	}
 }
@@ -38,6 +44,13 @@ func testUserInsert(t *testing.T) {
 	}
 }
 
+func testUserInsertDuplicate(t *testing.T) {
+	err := ur.Insert(context.Background(), &User{UserID: userID})
+	if !errors.Is(err, wrap.AlreadyExistsError{}) {
+		t.Error("not already_exists error", err)
+	}
+}
+
 func testUserGet(t *testing.T) {
 	u, err := ur.Get(context.Background(), userID)
 	if err != nil {
@@ -61,6 +74,8 @@ func testUserUpdate(t *testing.T) {
 		t.Error("get:", err)
 	}
 	u.State = "updated"
+	blockedAt := time.Now()
+	u.BlockedAt, u.BlockReason = &blockedAt, "blocked"
 	u.CreatedAt = u.CreatedAt.Add(-time.Minute)
 	err = ur.Upsert(context.Background(), u)
 	if err != nil {
@@ -73,6 +88,9 @@ func testUserUpdate(t *testing.T) {
 	if u.State != "updated" {
 		t.Error("nothing changed", u)
 	}
+	if !u.Blocked() || u.BlockedAt.Unix() != blockedAt.Unix() || u.BlockReason != "blocked" {
+		t.Error("block is not stored", u)
+	}
 	if u.CreatedAt.Equal(u.LastAction) {
 		t.Error("onUpdate failed", u)
 	}
@@ -81,6 +99,38 @@ func testUserUpdate(t *testing.T) {
 	}
 }
 
+func testUserUpdateVersion(t *testing.T) {
+	u, err := ur.Get(context.Background(), userID)
+	if err != nil {
+		t.Fatal("get: ", err)
+	}
+	stale := *u
+	version := u.Version
+	u.State = "versioned"
+	if err = ur.Update(context.Background(), u); err != nil {
+		t.Error("update: ", err)
+	}
+	if u.Version != version+1 {
+		t.Error("version is not incremented", u)
+	}
+	stale.State = "stale"
+	err = ur.Update(context.Background(), &stale)
+	if !errors.Is(err, wrap.ConflictError{}) {
+		t.Error("not conflict error", err)
+	}
+	u, err = ur.Get(context.Background(), userID)
+	if err != nil {
+		t.Error("get: ", err)
+	}
+	if u.State != "versioned" || u.Version != version+1 {
+		t.Error("stale update is applied", u)
+	}
+	err = ur.Update(context.Background(), &User{UserID: userID + 1})
+	if !errors.Is(err, wrap.NotFoundError{}) {
+		t.Error("not not_found error", err)
+	}
+}
+
 func testUserDelete(t *testing.T) {
 	err := ur.Delete(context.Background(), userID)
 	if err != nil {
@@ -0,0 +1,92 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"github.com/failoverbar/bot/wrap"
+)
+
+// UserData is everything stored about a user, it is given to the user on request.
+type UserData struct {
+	User            *User            `json:"user"`
+	Profile         *Profile         `json:"profile,omitempty"`
+	TelegramProfile *TelegramProfile `json:"telegram_profile,omitempty"`
+	Subscriptions   []*Subscription  `json:"subscriptions"`
+	History         []*Change        `json:"history"`
+	Attributions    []*Attribution   `json:"attributions"`
+	Perks           []*Perk          `json:"perks"`
+	Tickets         []*Ticket        `json:"tickets"`
+}
+
+// exportHistoryLimit bounds history included into UserData.
+const exportHistoryLimit = 1000
+
+// Export collects UserData of the user. wrap.NotFoundError is returned for unknown users.
+func (s Storage) Export(ctx context.Context, userID uint64) (d *UserData, err error) {
+	defer wrap.Errf("export user %d", &err, userID)
+	d = &UserData{}
+	err = s.Tx.InTx(ctx, func(ctx context.Context) error {
+		var err error
+		if d.User, err = s.Users.Get(ctx, userID); err != nil {
+			return err
+		}
+		if d.Profile, err = s.Profiles.Get(ctx, userID); err != nil && !errors.Is(err, wrap.NotFoundError{}) {
+			return err
+		}
+		if d.TelegramProfile, err = s.TelegramProfiles.Get(ctx, userID); err != nil && !errors.Is(err, wrap.NotFoundError{}) {
+			return err
+		}
+		if d.Subscriptions, err = s.Subscriptions.GetByUserID(ctx, userID); err != nil {
+			return err
+		}
+		if d.History, err = s.History.Timeline(ctx, userID, exportHistoryLimit); err != nil {
+			return err
+		}
+		if d.Attributions, err = s.Attributions.GetByUserID(ctx, userID); err != nil {
+			return err
+		}
+		if d.Perks, err = s.Perks.GetByUserID(ctx, userID); err != nil {
+			return err
+		}
+		d.Tickets, err = s.Tickets.GetByUserID(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Forget deletes everything stored about the user in one transaction. The deletions are not recorded
+// in history, which is deleted last and so must not be written before, see YDBTransactor.
+//
+// Bans are kept, so that banned users can't come back by forgetting themselves. Ids of the user left in
+// broadcasts, perks and tickets of others, which the user sent, issued or closed as staff, and in ticket
+// messages of the support chat are kept too: without the profile they tell nothing about the user.
+func (s Storage) Forget(ctx context.Context, userID uint64) (err error) {
+	defer wrap.Errf("forget user %d", &err, userID)
+	return s.Tx.InTx(ctx, func(ctx context.Context) error {
+		if err := undecorated(s.Subscriptions).DeleteByUserID(ctx, userID); err != nil {
+			return err
+		}
+		if err := undecorated(s.TelegramProfiles).Delete(ctx, userID); err != nil {
+			return err
+		}
+		if err := undecorated(s.Profiles).Delete(ctx, userID); err != nil {
+			return err
+		}
+		if err := undecorated(s.Users).Delete(ctx, userID); err != nil {
+			return err
+		}
+		if err := s.Attributions.DeleteByUserID(ctx, userID); err != nil {
+			return err
+		}
+		if err := s.Perks.DeleteByUserID(ctx, userID); err != nil {
+			return err
+		}
+		if err := s.Tickets.DeleteByUserID(ctx, userID); err != nil {
+			return err
+		}
+		return s.History.DeleteByUserID(ctx, userID)
+	})
+}
@@ -0,0 +1,89 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"github.com/AlekSi/pointer"
+	"github.com/failoverbar/bot/wrap"
+	"testing"
+)
+
+const forgetUserID = userID + 3
+
+func TestUserData(t *testing.T) {
+	for name, s := range storages() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := createTopics(ctx, s, topic); err != nil {
+				t.Fatal(err)
+			}
+			for _, repo := range []interface{}{s.Users, s.Profiles, s.TelegramProfiles, s.Subscriptions, s.Attributions, s.Perks, s.Tickets} {
+				if err := createTable(ctx, repo); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := s.Tx.InTx(ctx, func(ctx context.Context) error {
+				if err := s.Users.Insert(ctx, &User{UserID: forgetUserID}); err != nil {
+					return err
+				}
+				if err := s.Profiles.Upsert(ctx, &Profile{UserID: forgetUserID, Phone: pointer.ToString("+79990000000")}); err != nil {
+					return err
+				}
+				if err := s.TelegramProfiles.Upsert(ctx, &TelegramProfile{UserID: forgetUserID, Username: "forget"}); err != nil {
+					return err
+				}
+				if err := s.Attributions.Insert(ctx, &Attribution{UserID: forgetUserID, Payload: "spring"}); err != nil {
+					return err
+				}
+				if err := s.Perks.Insert(ctx, &Perk{UserID: forgetUserID, Kind: PerkWelcomeDrink}); err != nil {
+					return err
+				}
+				if err := s.Tickets.Insert(ctx, &Ticket{UserID: forgetUserID, Status: TicketOpen}); err != nil {
+					return err
+				}
+				return s.Subscriptions.Upsert(ctx, &Subscription{UserID: forgetUserID, Topic: topic, Active: true})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d, err := s.Export(ctx, forgetUserID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.User.UserID != forgetUserID || pointer.GetString(d.Profile.Phone) != "+79990000000" ||
+				d.TelegramProfile.Username != "forget" || len(d.Subscriptions) != 1 || len(d.History) == 0 || len(d.Attributions) != 1 || len(d.Perks) != 1 || len(d.Tickets) != 1 {
+				t.Errorf("incomplete export %+v", d)
+			}
+
+			if err = s.Forget(ctx, forgetUserID); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = s.Export(ctx, forgetUserID); !errors.Is(err, wrap.NotFoundError{}) {
+				t.Error("user is not forgotten", err)
+			}
+			if _, err = s.Profiles.Get(ctx, forgetUserID); !errors.Is(err, wrap.NotFoundError{}) {
+				t.Error("profile is not forgotten", err)
+			}
+			if _, err = s.TelegramProfiles.Get(ctx, forgetUserID); !errors.Is(err, wrap.NotFoundError{}) {
+				t.Error("telegram profile is not forgotten", err)
+			}
+			if ss, err := s.Subscriptions.GetByUserID(ctx, forgetUserID); err != nil || len(ss) != 0 {
+				t.Error("subscriptions are not forgotten", ss, err)
+			}
+			if as, err := s.Attributions.GetByUserID(ctx, forgetUserID); err != nil || len(as) != 0 {
+				t.Error("attributions are not forgotten", as, err)
+			}
+			if ps, err := s.Perks.GetByUserID(ctx, forgetUserID); err != nil || len(ps) != 0 {
+				t.Error("perks are not forgotten", ps, err)
+			}
+			if ts, err := s.Tickets.GetByUserID(ctx, forgetUserID); err != nil || len(ts) != 0 {
+				t.Error("tickets are not forgotten", ts, err)
+			}
+			if cs, err := s.History.Timeline(ctx, forgetUserID, 10); err != nil || len(cs) != 0 {
+				t.Error("history is not forgotten", cs, err)
+			}
+		})
+	}
+}
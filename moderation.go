@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/failoverbar/bot/fsm"
+	"github.com/failoverbar/bot/model"
+	"github.com/failoverbar/bot/phone"
+	"github.com/failoverbar/bot/wrap"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// findLimit bounds users listed by /find.
+const findLimit = 10
+
+// onFind looks users up by @username, phone or a name fragment.
+func (h *handler) onFind(c tele.Context) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	text := strings.TrimSpace(c.Message().Payload)
+	if text == "" {
+		return c.Send("Формат: /find @username, телефон или часть имени")
+	}
+	q := model.UserQuery{Username: strings.TrimPrefix(text, "@")}
+	if number, err := phone.Normalize(text); err == nil {
+		q.Phone = number
+	}
+	if !strings.HasPrefix(text, "@") {
+		q.Name = text
+	}
+	ids, err := h.storage.Directory.FindUsers(ctx, q, findLimit+1)
+	if err != nil {
+		return err
+	}
+	switch len(ids) {
+	case 0:
+		return c.Send("Никого не нашёл.")
+	case 1:
+		card, err := h.userCard(ctx, ids[0])
+		if err != nil {
+			return err
+		}
+		return c.Send(card)
+	}
+	lines := make([]string, 0, len(ids)+1)
+	for i, id := range ids {
+		if i == findLimit {
+			lines = append(lines, "…и другие, уточни запрос.")
+			break
+		}
+		line, err := h.userLine(ctx, id)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, line)
+	}
+	return c.Send(strings.Join(lines, "\n") + "\n\nПодробнее: /user id")
+}
+
+func (h *handler) onUser(c tele.Context) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	user, ok, err := h.targetUser(ctx, c, c.Message().Payload)
+	if !ok || err != nil {
+		return err
+	}
+	card, err := h.userCard(ctx, user.UserID)
+	if err != nil {
+		return err
+	}
+	return c.Send(card)
+}
+
+// onSetRole changes role of the user. Only the owner may grant roles as high as their own.
+func (h *handler) onSetRole(c tele.Context) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	args := c.Args()
+	if len(args) != 2 {
+		return c.Send("Формат: /setrole id роль\nРоли: guest, regular, staff, admin, owner")
+	}
+	role, err := model.ParseRole(args[1])
+	if err != nil {
+		return c.Send("Нет такой роли. Роли: guest, regular, staff, admin, owner")
+	}
+	target, ok, err := h.targetUser(ctx, c, args[0])
+	if !ok || err != nil {
+		return err
+	}
+	actor, err := h.userRepo.Get(ctx, uint64(c.Sender().ID))
+	if err != nil {
+		return err
+	}
+	if !canManage(actor, target) || (role >= actor.AccessRole() && actor.AccessRole() != model.RoleOwner) {
+		return c.Send("Недостаточно прав, чтобы дать эту роль.")
+	}
+	if _, err = h.updateUser(ctx, target.UserID, func(user *model.User) {
+		user.Role = uint8(role)
+	}); err != nil {
+		return err
+	}
+	return c.Send(fmt.Sprintf("Пользователь %d теперь %s.", target.UserID, role))
+}
+
+// onResetState ends the dialog the user is stuck in.
+func (h *handler) onResetState(c tele.Context) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	target, ok, err := h.targetUser(ctx, c, c.Message().Payload)
+	if !ok || err != nil {
+		return err
+	}
+	if _, err = h.updateUser(ctx, target.UserID, func(user *model.User) {
+		user.State = fsm.Idle
+		user.ClearConversation()
+	}); err != nil {
+		return err
+	}
+	return c.Send(fmt.Sprintf("Сбросил диалог пользователя %d, был %q.", target.UserID, target.State))
+}
+
+// onBan bans the user: /ban id [срок] [причина]. The term is like 30m, 12h or 7d, bans without it are permanent.
+func (h *handler) onBan(c tele.Context) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	args := strings.Fields(c.Message().Payload)
+	if len(args) == 0 {
+		return c.Send("Формат: /ban id [срок, например 12h или 7d] [причина]")
+	}
+	target, ok, err := h.targetUser(ctx, c, args[0])
+	if !ok || err != nil {
+		return err
+	}
+	actor, err := h.userRepo.Get(ctx, uint64(c.Sender().ID))
+	if err != nil {
+		return err
+	}
+	if target.UserID == actor.UserID {
+		return c.Send("Нельзя заблокировать самого себя.")
+	}
+	if !canManage(actor, target) {
+		return c.Send("Недостаточно прав, чтобы заблокировать этого пользователя.")
+	}
+	ban := &model.Ban{UserID: target.UserID, BannedBy: actor.UserID}
+	args = args[1:]
+	if len(args) > 0 {
+		if d, err := parseTerm(args[0]); err == nil {
+			until := time.Now().Add(d)
+			ban.Until = &until
+			args = args[1:]
+		}
+	}
+	ban.Reason = strings.Join(args, " ")
+	if err := h.storage.Bans.Upsert(ctx, ban); err != nil {
+		return err
+	}
+	h.guard.Remember(int64(target.UserID), banEnd(ban))
+	return c.Send(fmt.Sprintf("Пользователь %d заблокирован %s.", target.UserID, banTerm(ban)))
+}
+
+// onUnban lifts the ban of the user. Users who were forgotten keep their bans, so the user may be unknown.
+func (h *handler) onUnban(c tele.Context) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	id, err := strconv.ParseUint(strings.TrimSpace(c.Message().Payload), 10, 64)
+	if err != nil {
+		return c.Send("Формат: /unban id")
+	}
+	ban, err := h.storage.Bans.Get(ctx, id)
+	if errors.Is(err, wrap.NotFoundError{}) {
+		h.guard.Forgive(int64(id))
+		return c.Send(fmt.Sprintf("Пользователь %d не заблокирован.", id))
+	}
+	if err != nil {
+		return err
+	}
+	actor, err := h.userRepo.Get(ctx, uint64(c.Sender().ID))
+	if err != nil {
+		return err
+	}
+	target, err := h.knownUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	var bannedBy *model.User
+	if ban.BannedBy != 0 {
+		if bannedBy, err = h.knownUser(ctx, ban.BannedBy); err != nil {
+			return err
+		}
+	}
+	if !canUnban(actor, target, bannedBy) {
+		return c.Send("Недостаточно прав, чтобы снять эту блокировку.")
+	}
+	if err := h.storage.Bans.Delete(ctx, id); err != nil {
+		return err
+	}
+	h.guard.Forgive(int64(id))
+	return c.Send(fmt.Sprintf("Пользователь %d разблокирован.", id))
+}
+
+// knownUser returns the user or a guest with the id if the user is not stored.
+func (h *handler) knownUser(ctx context.Context, id uint64) (*model.User, error) {
+	user, err := h.userRepo.Get(ctx, id)
+	if errors.Is(err, wrap.NotFoundError{}) {
+		return &model.User{UserID: id}, nil
+	}
+	return user, err
+}
+
+// canManage tells whether actor may change role or ban target. The owner manages everyone,
+// the others only users with lower roles.
+func canManage(actor, target *model.User) bool {
+	return actor.AccessRole() == model.RoleOwner || target.AccessRole() < actor.AccessRole()
+}
+
+// canUnban tells whether actor may lift the ban of target made by bannedBy, nil for bans made by the bot.
+// Besides managing target, actor must have a role not lower than the one of who banned.
+func canUnban(actor, target, bannedBy *model.User) bool {
+	return canManage(actor, target) && (bannedBy == nil || bannedBy.AccessRole() <= actor.AccessRole())
+}
+
+// targetUser resolves an id or @username argument. ok is false if the admin is already told the user is not found.
+func (h *handler) targetUser(ctx context.Context, c tele.Context, arg string) (user *model.User, ok bool, err error) {
+	arg = strings.TrimSpace(arg)
+	id, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil && strings.HasPrefix(arg, "@") {
+		ids, err := h.storage.Directory.FindUsers(ctx, model.UserQuery{Username: strings.TrimPrefix(arg, "@")}, 1)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(ids) == 1 {
+			id = ids[0]
+		}
+	}
+	if id == 0 {
+		return nil, false, c.Send("Укажи id пользователя или @username, найти его можно командой /find.")
+	}
+	user, err = h.userRepo.Get(ctx, id)
+	if errors.Is(err, wrap.NotFoundError{}) {
+		return nil, false, c.Send(fmt.Sprintf("Пользователя %d нет.", id))
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return user, true, nil
+}
+
+// userLine is a short description of the user for lists.
+func (h *handler) userLine(ctx context.Context, userID uint64) (string, error) {
+	line := strconv.FormatUint(userID, 10)
+	tg, err := h.telegramProfileRepo.Get(ctx, userID)
+	if err != nil && !errors.Is(err, wrap.NotFoundError{}) {
+		return "", err
+	}
+	if err == nil {
+		if tg.Username != "" {
+			line += " @" + tg.Username
+		}
+		line += " " + strings.TrimSpace(tg.FirstName+" "+tg.LastName)
+	}
+	profile, err := h.profileRepo.Get(ctx, userID)
+	if err != nil && !errors.Is(err, wrap.NotFoundError{}) {
+		return "", err
+	}
+	if err == nil && profile.Name != nil {
+		line += " (" + *profile.Name + ")"
+	}
+	return line, nil
+}
+
+// userCard describes everything staff need to know about the user.
+func (h *handler) userCard(ctx context.Context, userID uint64) (string, error) {
+	user, err := h.userRepo.Get(ctx, userID)
+	if errors.Is(err, wrap.NotFoundError{}) {
+		user = &model.User{UserID: userID}
+	} else if err != nil {
+		return "", err
+	}
+	head, err := h.userLine(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	lines := []string{head}
+	profile, err := h.profileRepo.Get(ctx, userID)
+	if err != nil && !errors.Is(err, wrap.NotFoundError{}) {
+		return "", err
+	}
+	if err == nil {
+		if profile.Phone != nil {
+			lines = append(lines, "Телефон: "+*profile.Phone)
+		}
+		if profile.Email != nil {
+			email := *profile.Email
+			if !profile.EmailVerified {
+				email += " (не подтверждён)"
+			}
+			lines = append(lines, "Почта: "+email)
+		}
+		if profile.Source != "" {
+			lines = append(lines, "Пришёл по ссылке: "+profile.Source)
+		}
+	}
+	state := user.State
+	if state == fsm.Idle {
+		state = "нет диалога"
+	}
+	lines = append(lines, "Роль: "+user.AccessRole().String(), "Диалог: "+state)
+
+	ss, err := h.subscriptionsRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	var active, paused []string
+	for _, s := range ss {
+		if s.Active {
+			active = append(active, s.Topic)
+		} else {
+			paused = append(paused, s.Topic)
+		}
+	}
+	subs := "нет"
+	if len(active) > 0 {
+		subs = strings.Join(active, ", ")
+	}
+	if len(paused) > 0 {
+		subs += ", на паузе: " + strings.Join(paused, ", ")
+	}
+	lines = append(lines, "Подписки: "+subs)
+
+	ps, err := h.storage.Perks.GetByUserID(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range ps {
+		if a, ok := findPerkAction(p.Kind); ok {
+			lines = append(lines, a.Done+" "+formatTime(p.IssuedAt))
+		}
+	}
+
+	if !user.CreatedAt.IsZero() {
+		lines = append(lines, "Зарегистрирован: "+formatTime(user.CreatedAt), "Последнее действие: "+formatTime(user.LastAction))
+	}
+	if user.Blocked() {
+		lines = append(lines, "Недоступен с "+formatTime(*user.BlockedAt)+": "+user.BlockReason)
+	}
+	ban, err := h.storage.Bans.Get(ctx, userID)
+	if err != nil && !errors.Is(err, wrap.NotFoundError{}) {
+		return "", err
+	}
+	if err == nil && ban.Active(time.Now()) {
+		line := "Заблокирован " + banTerm(ban)
+		if ban.Reason != "" {
+			line += ": " + ban.Reason
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func banTerm(ban *model.Ban) string {
+	if ban.Until == nil {
+		return "навсегда"
+	}
+	return "до " + formatTime(*ban.Until)
+}
+
+func formatTime(t time.Time) string {
+	return t.Local().Format("02.01.2006 15:04")
+}
+
+// parseTerm parses durations of time.ParseDuration and days like 7d.
+func parseTerm(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.ParseUint(days, 10, 16)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err == nil && d <= 0 {
+		return 0, fmt.Errorf("term must be positive, got %s", s)
+	}
+	return d, err
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/failoverbar/bot/model"
+	"testing"
+)
+
+func TestCanUnban(t *testing.T) {
+	user := func(role model.Role) *model.User {
+		return &model.User{Role: uint8(role)}
+	}
+	for _, tt := range []struct {
+		name                    string
+		actor, target, bannedBy *model.User
+		want                    bool
+	}{
+		{"flood ban", user(model.RoleStaff), user(model.RoleRegular), nil, true},
+		{"ban of staff", user(model.RoleStaff), user(model.RoleRegular), user(model.RoleStaff), true},
+		{"ban of admin", user(model.RoleStaff), user(model.RoleRegular), user(model.RoleAdmin), false},
+		{"ban of owner", user(model.RoleAdmin), user(model.RoleGuest), user(model.RoleOwner), false},
+		{"owner", user(model.RoleOwner), user(model.RoleOwner), user(model.RoleOwner), true},
+		{"equal target", user(model.RoleStaff), user(model.RoleStaff), nil, false},
+		{"higher target", user(model.RoleStaff), user(model.RoleAdmin), user(model.RoleStaff), false},
+	} {
+		if got := canUnban(tt.actor, tt.target, tt.bannedBy); got != tt.want {
+			t.Errorf("%s: got %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Package phone validates phone numbers and normalises them to E.164.
+package phone
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalid is returned for strings which are not phone numbers.
+var ErrInvalid = errors.New("invalid phone number")
+
+// E.164 limits the number to 15 digits. The shortest national numbers have 8 digits with the country code.
+const (
+	minDigits = 8
+	maxDigits = 15
+)
+
+// Normalize converts a typed or shared phone number to E.164, e.g. "+79161234567".
+// Numbers without a country code are treated as Russian: "8 (916) 123-45-67" and "916 123 45 67".
+// Telegram shares contacts without "+", so their numbers should be prefixed with it, see International.
+func Normalize(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	international := strings.HasPrefix(s, "+")
+	var digits strings.Builder
+	for i, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '.':
+		default:
+			return "", fmt.Errorf("%w %q: unexpected %q", ErrInvalid, s, r)
+		}
+	}
+	d := digits.String()
+	if !international {
+		switch {
+		case len(d) == 11 && d[0] == '8':
+			d = "7" + d[1:]
+		case len(d) == 10 && d[0] == '9':
+			d = "7" + d
+		case len(d) > 0 && d[0] == '8':
+			return "", fmt.Errorf("%w %q: numbers starting with 8 have 11 digits", ErrInvalid, s)
+		}
+	}
+	if len(d) < minDigits || len(d) > maxDigits {
+		return "", fmt.Errorf("%w %q: %d digits", ErrInvalid, s, len(d))
+	}
+	if d[0] == '0' {
+		return "", fmt.Errorf("%w %q: country code can't start with 0", ErrInvalid, s)
+	}
+	if d[0] == '7' && len(d) != 11 {
+		return "", fmt.Errorf("%w %q: numbers of Russia and Kazakhstan have 11 digits", ErrInvalid, s)
+	}
+	return "+" + d, nil
+}
+
+// International normalises a number known to include the country code, e.g. one of a shared contact.
+func International(s string) (string, error) {
+	return Normalize("+" + strings.TrimPrefix(strings.TrimSpace(s), "+"))
+}
@@ -0,0 +1,49 @@
+package phone
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	cases := map[string]string{
+		"+7 916 123-45-67":   "+79161234567",
+		"8 (916) 123-45-67":  "+79161234567",
+		"89161234567":        "+79161234567",
+		"79161234567":        "+79161234567",
+		"9161234567":         "+79161234567",
+		"+7(495)123.45.67":   "+74951234567",
+		" +44 20 7946 0958 ": "+442079460958",
+		"+1 (202) 555-0143":  "+12025550143",
+		"+380441234567":      "+380441234567",
+	}
+	for s, want := range cases {
+		got, err := Normalize(s)
+		if err != nil {
+			t.Error(s, err)
+		}
+		if got != want {
+			t.Errorf("%q: got %s, want %s", s, got, want)
+		}
+	}
+
+	for _, s := range []string{"", "привет", "123", "+7 916 123", "8 916 123 45 67 89", "+0123456789",
+		"+1234567890123456", "916-123-45-67 доб. 5", "++79161234567", "7+9161234567"} {
+		if got, err := Normalize(s); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%q: got %s, not invalid error %v", s, got, err)
+		}
+	}
+}
+
+func TestInternational(t *testing.T) {
+	for s, want := range map[string]string{
+		"79161234567":  "+79161234567",
+		"+79161234567": "+79161234567",
+		"819012345678": "+819012345678",
+		"442079460958": "+442079460958",
+	} {
+		if got, err := International(s); err != nil || got != want {
+			t.Errorf("%q: got %s, want %s, error %v", s, got, want, err)
+		}
+	}
+}
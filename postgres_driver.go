@@ -0,0 +1,7 @@
+//go:build postgres
+
+package main
+
+import (
+	_ "github.com/jackc/pgx/v4/stdlib"
+)
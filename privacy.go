@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/failoverbar/bot/wrap"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+var (
+	forgetMarkup        = &tele.ReplyMarkup{}
+	forgetConfirmButton = forgetMarkup.Data("Да, удалить всё", "forget_confirm")
+	forgetCancelButton  = forgetMarkup.Data("Отмена", "forget_cancel")
+)
+
+func init() {
+	forgetMarkup.Inline(forgetMarkup.Row(forgetConfirmButton, forgetCancelButton))
+}
+
+// onMyData sends the user a JSON document with everything stored about them.
+func (h *handler) onMyData(c tele.Context) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	d, err := h.storage.Export(ctx, uint64(c.Sender().ID))
+	if errors.Is(err, wrap.NotFoundError{}) {
+		return c.Send("Я ничего о тебе не знаю.")
+	}
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	return c.Send(&tele.Document{
+		File:     tele.FromReader(bytes.NewReader(data)),
+		FileName: "mydata.json",
+		MIME:     "application/json",
+		Caption:  "Всё, что я о тебе знаю.",
+	})
+}
+
+func (h *handler) onForget(c tele.Context) error {
+	return c.Send("Удалить все данные о тебе: имя, телефон, профиль Telegram и подписки? "+
+		"Это нельзя отменить.", forgetMarkup)
+}
+
+func (h *handler) onForgetConfirm(c tele.Context) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	if err := h.storage.Forget(ctx, uint64(c.Sender().ID)); err != nil {
+		return err
+	}
+	return c.Edit("Готово, я всё забыл. Чтобы начать заново, отправь /start.")
+}
+
+func (h *handler) onForgetCancel(c tele.Context) error {
+	return c.Edit("Хорошо, ничего не удаляю.")
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"github.com/failoverbar/bot/fsm"
+	"github.com/failoverbar/bot/model"
+	"github.com/failoverbar/bot/phone"
+	"log"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// States of the registration dialog.
+const (
+	stateRegisterName   = "register.name"
+	stateRegisterPhone  = "register.phone"
+	stateRegisterIT     = "register.it"
+	stateRegisterRole   = "register.role"
+	stateRegisterTopics = "register.topics"
+)
+
+type option struct {
+	Code  string
+	Title string
+}
+
+// itRoles are answers to "Кто ты в айти?" stored in Profile.ITRole.
+var itRoles = []option{
+	{model.ITBackend, "Бэкенд"},
+	{model.ITFrontend, "Фронтенд"},
+	{model.ITMobile, "Мобильная разработка"},
+	{model.ITQA, "QA"},
+	{model.ITDevOps, "DevOps"},
+	{model.ITData, "Data / ML"},
+	{model.ITPM, "PM"},
+	{model.ITDesign, "Дизайн"},
+	{model.ITOther, "Другое"},
+}
+
+// Unique of inline buttons of registration.
+const (
+	itButton     = "register_it"
+	roleButton   = "register_role"
+	topicButton  = "register_topic"
+	topicsDone   = "done"
+	topicsChosen = "topics"
+)
+
+func (h *handler) registerStates() []fsm.State {
+	return []fsm.State{
+		{
+			Name:      fsm.Idle,
+			OnText:    h.onIdleText,
+			OnContact: h.onIdleContact,
+			Next:      []string{stateEmailAddress, stateBroadcastMessage},
+		},
+		{
+			Name:   stateRegisterName,
+			Enter:  h.askName,
+			OnText: h.onRegisterName,
+			Next:   []string{stateRegisterPhone},
+		},
+		{
+			Name:      stateRegisterPhone,
+			Enter:     h.askPhone,
+			OnText:    h.onRegisterPhoneText,
+			OnContact: h.onRegisterPhone,
+			Next:      []string{stateRegisterIT},
+		},
+		{
+			Name:       stateRegisterIT,
+			Enter:      h.askIT,
+			OnCallback: h.onRegisterIT,
+			Fallback:   h.askIT,
+			Next:       []string{stateRegisterRole, stateRegisterTopics},
+		},
+		{
+			Name:       stateRegisterRole,
+			Enter:      h.askRole,
+			OnCallback: h.onRegisterRole,
+			Fallback:   h.askRole,
+			Next:       []string{stateRegisterTopics},
+		},
+		{
+			Name:       stateRegisterTopics,
+			Enter:      h.askTopics,
+			OnCallback: h.onRegisterTopics,
+			Fallback:   h.askTopics,
+			Next:       []string{stateEmailAddress},
+		},
+	}
+}
+
+// onIdleText relays messages outside dialogs to the support chat if it is set.
+func (h *handler) onIdleText(ctx context.Context, c tele.Context) error {
+	if h.supportChatID != 0 && c.Chat().Type == tele.ChatPrivate {
+		return h.relayToSupport(ctx, c)
+	}
+	log.Printf("got text with empty context %d: %s", c.Sender().ID, c.Text())
+	return c.Send("Ничего не понятно, но очень интересно")
+}
+
+func (h *handler) onFallback(_ context.Context, c tele.Context) error {
+	return c.Send("Ничего не понятно, но очень интересно")
+}
+
+func (h *handler) onUnknownState(_ context.Context, c tele.Context, state string) error {
+	log.Printf("got unknown state %s from %d: %s", state, c.Sender().ID, c.Text())
+	return c.Send("А вы интересный человек")
+}
+
+func (h *handler) askName(_ context.Context, c tele.Context) error {
+	return c.Send("Тебя приветствует *бот Фейловер Бара*. 🤗 Давай знакомиться!\n\n*Как тебя зовут?*")
+}
+
+func (h *handler) onRegisterName(ctx context.Context, c tele.Context) error {
+	userID := uint64(c.Sender().ID)
+	name := c.Text()
+	return h.tx.InTx(ctx, func(ctx context.Context) error {
+		profile, err := h.profileRepo.Get(ctx, userID)
+		if err != nil {
+			return err
+		}
+		profile.Name = &name
+		if err := h.profileRepo.Upsert(ctx, profile); err != nil {
+			return err
+		}
+		return h.fsm.Transition(ctx, c, stateRegisterPhone)
+	})
+}
+
+func (h *handler) askPhone(ctx context.Context, c tele.Context) error {
+	profile, err := h.profileRepo.Get(ctx, uint64(c.Sender().ID))
+	if err != nil {
+		return err
+	}
+	m := h.bot.NewMarkup()
+	m.RemoveKeyboard = true
+	m.Reply(m.Row(m.Contact("Отправить номер")))
+	greeting := ""
+	if profile.Name != nil {
+		greeting = "Очень приятно, " + *profile.Name + ". "
+	}
+	return c.Send(greeting+"Для доступа к WiFi и программе лояльности бара мне нужен твой телефон. "+
+		"Нажми кнопку или напиши номер.\n\nОбещаю никому его не раскрывать.", m)
+}
+
+func (h *handler) onRegisterPhone(ctx context.Context, c tele.Context) error {
+	if c.Message().Contact.UserID != c.Sender().ID {
+		return c.Send("Получил контакт. Не знаю, что мне с ним делать, но очень интересно.")
+	}
+	number, err := phone.International(c.Message().Contact.PhoneNumber)
+	if err != nil {
+		log.Printf("got bad contact phone from %d: %s", c.Sender().ID, err)
+		return c.Send("Не получилось разобрать номер из контакта. Напиши его, пожалуйста, текстом.")
+	}
+	return h.registerPhone(ctx, c, number, model.PhoneFromContact)
+}
+
+// onRegisterPhoneText accepts typed numbers from clients which can't share a contact, e.g. desktop ones.
+func (h *handler) onRegisterPhoneText(ctx context.Context, c tele.Context) error {
+	number, err := phone.Normalize(c.Text())
+	if err != nil {
+		return c.Send("Не похоже на номер телефона. Напиши его с кодом страны, например +7 916 123-45-67, " +
+			"или нажми кнопку «Отправить номер».")
+	}
+	return h.registerPhone(ctx, c, number, model.PhoneTyped)
+}
+
+func (h *handler) registerPhone(ctx context.Context, c tele.Context, number, source string) error {
+	err := h.tx.InTx(ctx, func(ctx context.Context) error {
+		if err := h.savePhone(ctx, c, number, source); err != nil {
+			return err
+		}
+		return h.fsm.Transition(ctx, c, stateRegisterIT)
+	})
+	if err != nil {
+		return err
+	}
+
+	m := h.bot.NewMarkup()
+	m.RemoveKeyboard = true
+	return c.Send("Благодарю.", m)
+}
+
+func (h *handler) askIT(_ context.Context, c tele.Context) error {
+	m := h.bot.NewMarkup()
+	m.Inline(m.Row(m.Data("Да", itButton, "yes"), m.Data("Нет", itButton, "no")))
+	return c.Send("Ты из айти?", m)
+}
+
+func (h *handler) onRegisterIT(ctx context.Context, c tele.Context) error {
+	unique, answer := fsm.CallbackData(c)
+	if unique != itButton {
+		return h.askIT(ctx, c)
+	}
+	inIT := answer == "yes"
+	next, reply := stateRegisterTopics, "Ты из айти? Нет"
+	if inIT {
+		next, reply = stateRegisterRole, "Ты из айти? Да"
+	}
+	err := h.tx.InTx(ctx, func(ctx context.Context) error {
+		err := h.updateProfile(ctx, c, func(profile *model.Profile) {
+			profile.InIT = &inIT
+			if !inIT {
+				profile.ITRole = nil
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return h.fsm.Transition(ctx, c, next)
+	})
+	if err != nil {
+		return err
+	}
+	return c.Edit(reply)
+}
+
+func (h *handler) askRole(_ context.Context, c tele.Context) error {
+	m := h.bot.NewMarkup()
+	btns := make([]tele.Btn, 0, len(itRoles))
+	for _, role := range itRoles {
+		btns = append(btns, m.Data(role.Title, roleButton, role.Code))
+	}
+	m.Inline(m.Split(3, btns)...)
+	return c.Send("Кто ты в айти?", m)
+}
+
+func (h *handler) onRegisterRole(ctx context.Context, c tele.Context) error {
+	unique, code := fsm.CallbackData(c)
+	role, ok := findOption(itRoles, code)
+	if unique != roleButton || !ok {
+		return h.askRole(ctx, c)
+	}
+	err := h.tx.InTx(ctx, func(ctx context.Context) error {
+		err := h.updateProfile(ctx, c, func(profile *model.Profile) {
+			profile.ITRole = &role.Code
+		})
+		if err != nil {
+			return err
+		}
+		return h.fsm.Transition(ctx, c, stateRegisterTopics)
+	})
+	if err != nil {
+		return err
+	}
+	return c.Edit("Кто ты в айти? " + role.Title)
+}
+
+func (h *handler) askTopics(ctx context.Context, c tele.Context) error {
+	m, err := h.topicsMarkup(ctx, c)
+	if err != nil {
+		return err
+	}
+	return c.Send("О чём тебе рассказывать? Выбери интересное и нажми «Готово».", m)
+}
+
+// topicsMarkup shows listed topics of the catalog, chosen so far are with a check mark.
+func (h *handler) topicsMarkup(ctx context.Context, c tele.Context) (*tele.ReplyMarkup, error) {
+	topics, err := h.listedTopics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	chosen, err := h.chosenTopics(ctx, c, topics)
+	if err != nil {
+		return nil, err
+	}
+	m := h.bot.NewMarkup()
+	var rows []tele.Row
+	for _, topic := range topics {
+		title := topic.Title
+		if chosen[topic.Slug] {
+			title = "✅ " + title
+		}
+		rows = append(rows, m.Row(m.Data(title, topicButton, topic.Slug)))
+	}
+	rows = append(rows, m.Row(m.Data("Готово", topicButton, topicsDone)))
+	m.Inline(rows...)
+	return m, nil
+}
+
+// chosenTopics returns topics chosen so far. Until the guest touches them DefaultOn ones of topics
+// and the ones the guest is already subscribed to, e.g. with a deep link, are chosen.
+func (h *handler) chosenTopics(ctx context.Context, c tele.Context, topics []*model.Topic) (map[string]bool, error) {
+	conv, err := h.conversation(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	chosen, ok, err := model.ConversationValue[map[string]bool](conv, topicsChosen)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return chosen, nil
+	}
+	subs, err := h.subscriptionsRepo.GetByUserID(ctx, uint64(c.Sender().ID))
+	if err != nil {
+		return nil, err
+	}
+	chosen = map[string]bool{}
+	for _, s := range subs {
+		if s.Active {
+			chosen[s.Topic] = true
+		}
+	}
+	for _, topic := range topics {
+		if topic.DefaultOn {
+			chosen[topic.Slug] = true
+		}
+	}
+	return chosen, nil
+}
+
+func (h *handler) onRegisterTopics(ctx context.Context, c tele.Context) error {
+	unique, code := fsm.CallbackData(c)
+	if unique != topicButton {
+		return h.askTopics(ctx, c)
+	}
+	topics, err := h.listedTopics(ctx)
+	if err != nil {
+		return err
+	}
+	chosen, err := h.chosenTopics(ctx, c, topics)
+	if err != nil {
+		return err
+	}
+	if code == topicsDone {
+		return h.finishRegistration(ctx, c, topics, chosen)
+	}
+	if _, ok := findTopic(topics, code); !ok {
+		return h.askTopics(ctx, c)
+	}
+
+	chosen[code] = !chosen[code]
+	conv, err := h.conversation(ctx, c)
+	if err != nil {
+		return err
+	}
+	if err = model.SetConversationValue(conv, topicsChosen, chosen); err != nil {
+		return err
+	}
+	if err = h.saveConversation(ctx, c); err != nil {
+		return err
+	}
+	m, err := h.topicsMarkup(ctx, c)
+	if err != nil {
+		return err
+	}
+	return c.Edit(m)
+}
+
+// finishRegistration subscribes the user to chosen topics and pauses subscriptions to unchecked ones,
+// makes the guest a regular, tells staff about the new guest and moves to the optional email step.
+func (h *handler) finishRegistration(ctx context.Context, c tele.Context, topics []*model.Topic, chosen map[string]bool) error {
+	var titles []string
+	for _, topic := range topics {
+		if !chosen[topic.Slug] {
+			continue
+		}
+		if err := h.subscribe(ctx, uint64(c.Sender().ID), topic.Slug); err != nil {
+			return err
+		}
+		titles = append(titles, topic.Title)
+	}
+	subs, err := h.subscriptionsRepo.GetByUserID(ctx, uint64(c.Sender().ID))
+	if err != nil {
+		return err
+	}
+	for _, s := range subs {
+		if _, listed := findTopic(topics, s.Topic); !listed || !s.Active || chosen[s.Topic] {
+			continue
+		}
+		s.Active = false
+		if err := h.subscriptionsRepo.Upsert(ctx, s); err != nil {
+			return err
+		}
+	}
+	_, err = h.updateUser(ctx, uint64(c.Sender().ID), func(user *model.User) {
+		if user.AccessRole() == model.RoleGuest {
+			user.Role = uint8(model.RoleRegular)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if err := h.fsm.Transition(ctx, c, stateEmailAddress); err != nil {
+		return err
+	}
+	h.notifyRegistration(ctx, uint64(c.Sender().ID))
+	if len(titles) == 0 {
+		return c.Edit("Хорошо, не буду ни о чём рассказывать.")
+	}
+	return c.Edit("Буду рассказывать: " + strings.Join(titles, ", ") + ".")
+}
+
+func findOption(options []option, code string) (option, bool) {
+	for _, o := range options {
+		if o.Code == code {
+			return o, true
+		}
+	}
+	return option{}, false
+}
+
+func findTopic(topics []*model.Topic, slug string) (*model.Topic, bool) {
+	for _, t := range topics {
+		if t.Slug == slug {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// onIdleContact updates phone of a registered user.
+func (h *handler) onIdleContact(ctx context.Context, c tele.Context) error {
+	if c.Message().Contact.UserID != c.Sender().ID {
+		return c.Send("Получил контакт. Не знаю, что мне с ним делать, но очень интересно.")
+	}
+	number, err := phone.International(c.Message().Contact.PhoneNumber)
+	if err != nil {
+		log.Printf("got bad contact phone from %d: %s", c.Sender().ID, err)
+		return c.Send("Не получилось разобрать номер из контакта.")
+	}
+	if err := h.savePhone(ctx, c, number, model.PhoneFromContact); err != nil {
+		return err
+	}
+	return c.Send("Благодарю, обновил твой телефон.")
+}
+
+func (h *handler) savePhone(ctx context.Context, c tele.Context, number, source string) error {
+	return h.updateProfile(ctx, c, func(profile *model.Profile) {
+		profile.Phone = &number
+		profile.PhoneSource = source
+	})
+}
+
+func (h *handler) updateProfile(ctx context.Context, c tele.Context, change func(profile *model.Profile)) error {
+	profile, err := h.profileRepo.Get(ctx, uint64(c.Sender().ID))
+	if err != nil {
+		return err
+	}
+	change(profile)
+	return h.profileRepo.Upsert(ctx, profile)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/failoverbar/bot/fsm"
+	"github.com/failoverbar/bot/model"
+	"github.com/failoverbar/bot/wrap"
+	"log"
+	"os"
+	"strconv"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// ownerFromEnv reads Telegram id of the owner from OWNER_ID, it is zero if the variable is not set.
+func ownerFromEnv() (uint64, error) {
+	s, ok := os.LookupEnv("OWNER_ID")
+	if !ok || s == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad OWNER_ID %q: %w", s, err)
+	}
+	return id, nil
+}
+
+// bootstrapOwner gives model.RoleOwner to the configured owner if the owner is registered.
+// Otherwise the role is given on /start, see handler.newUserRole.
+func bootstrapOwner(ctx context.Context, users model.UserStorage, ownerID uint64) error {
+	if ownerID == 0 {
+		log.Print("OWNER_ID is not set, roles can be granted only by existing owners")
+		return nil
+	}
+	user, err := users.Get(ctx, ownerID)
+	if errors.Is(err, wrap.NotFoundError{}) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.AccessRole() == model.RoleOwner {
+		return nil
+	}
+	user.Role = uint8(model.RoleOwner)
+	if err := users.Update(ctx, user); err != nil {
+		return err
+	}
+	log.Printf("user %d is the owner now", ownerID)
+	return nil
+}
+
+func (h *handler) newUserRole(userID uint64) model.Role {
+	if userID == h.ownerID {
+		return model.RoleOwner
+	}
+	return model.RoleGuest
+}
+
+// RequireRole passes commands and callbacks only from users having at least role min.
+func RequireRole(users model.UserStorage, min model.Role) tele.MiddlewareFunc {
+	return func(next tele.HandlerFunc) tele.HandlerFunc {
+		return func(c tele.Context) error {
+			ctx, cancel := requestContext(c)
+			defer cancel()
+			ok, err := hasRole(ctx, users, c, min)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return denyRole(c, min)
+			}
+			return next(c)
+		}
+	}
+}
+
+// requireRoleState guards handlers of dialog states the same way RequireRole guards commands.
+func requireRoleState(users model.UserStorage, min model.Role, next fsm.HandlerFunc) fsm.HandlerFunc {
+	return func(ctx context.Context, c tele.Context) error {
+		ok, err := hasRole(ctx, users, c, min)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return denyRole(c, min)
+		}
+		return next(ctx, c)
+	}
+}
+
+// hasRole tells whether the sender has at least role min, unknown users are guests.
+func hasRole(ctx context.Context, users model.UserStorage, c tele.Context, min model.Role) (bool, error) {
+	if min == model.RoleGuest {
+		return true, nil
+	}
+	user, err := users.Get(ctx, uint64(c.Sender().ID))
+	if errors.Is(err, wrap.NotFoundError{}) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return user.Can(min), nil
+}
+
+func denyRole(c tele.Context, min model.Role) error {
+	log.Printf("%d has no role %s for: %s", c.Sender().ID, min, c.Text())
+	if c.Callback() != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "Недостаточно прав"})
+	}
+	return c.Send("Эта команда тебе недоступна. Список доступных команд: /help")
+}
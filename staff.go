@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/failoverbar/bot/model"
+	"github.com/failoverbar/bot/wrap"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// perkAction is a button on registration cards which records model.Perk of the kind.
+type perkAction struct {
+	Kind   string
+	Button string
+	Done   string
+}
+
+var perkActions = []perkAction{
+	{model.PerkWelcomeDrink, "🍹 Выдать welcome drink", "🍹 Welcome drink выдан"},
+}
+
+// perkButton data is the perk kind and the user id.
+var perkButton = tele.Btn{Unique: "perk"}
+
+// chatFromEnv reads id of a chat, e.g. STAFF_CHAT_ID with the chat staff get registration cards in.
+// Zero means the chat is not set.
+func chatFromEnv(name string) (int64, error) {
+	s, ok := os.LookupEnv(name)
+	if !ok || s == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad %s %q: %w", name, s, err)
+	}
+	return id, nil
+}
+
+// notifyRegistration posts the registration card of the user to the staff chat.
+// Failures are only logged, since the guest has nothing to do with them.
+func (h *handler) notifyRegistration(ctx context.Context, userID uint64) {
+	if h.staffChatID == 0 {
+		return
+	}
+	text, m, err := h.registrationCard(ctx, userID)
+	if err == nil {
+		_, err = h.bot.Send(tele.ChatID(h.staffChatID), text, m)
+	}
+	if err != nil {
+		log.Printf("can't notify staff about registration of %d: %s", userID, err)
+	}
+}
+
+// registrationCard describes the new guest for staff with buttons of perks not issued yet.
+func (h *handler) registrationCard(ctx context.Context, userID uint64) (string, *tele.ReplyMarkup, error) {
+	name := "без имени"
+	var lines []string
+	profile, err := h.profileRepo.Get(ctx, userID)
+	if err != nil && !errors.Is(err, wrap.NotFoundError{}) {
+		return "", nil, err
+	}
+	if err == nil && profile.Name != nil {
+		name = *profile.Name
+	}
+	tg, err := h.telegramProfileRepo.Get(ctx, userID)
+	if err != nil && !errors.Is(err, wrap.NotFoundError{}) {
+		return "", nil, err
+	}
+	if err == nil {
+		telegram := strings.TrimSpace(tg.FirstName + " " + tg.LastName)
+		if tg.Username != "" {
+			telegram = "@" + tg.Username + " " + telegram
+		}
+		lines = append(lines, "Telegram: "+telegram)
+	}
+	lines = append(lines, "id: "+strconv.FormatUint(userID, 10))
+	if profile != nil {
+		if profile.Source != "" {
+			lines = append(lines, "Пришёл по ссылке: "+profile.Source)
+		}
+		switch {
+		case profile.ITRole != nil:
+			role, ok := findOption(itRoles, *profile.ITRole)
+			if !ok {
+				role.Title = *profile.ITRole
+			}
+			lines = append(lines, "В айти: "+role.Title)
+		case profile.InIT != nil && !*profile.InIT:
+			lines = append(lines, "Не из айти")
+		}
+	}
+
+	ps, err := h.storage.Perks.GetByUserID(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	issued := map[string]*model.Perk{}
+	for _, p := range ps {
+		issued[p.Kind] = p
+	}
+	m := h.bot.NewMarkup()
+	var rows []tele.Row
+	for _, a := range perkActions {
+		p, ok := issued[a.Kind]
+		if !ok {
+			rows = append(rows, m.Row(m.Data(a.Button, perkButton.Unique, a.Kind, strconv.FormatUint(userID, 10))))
+			continue
+		}
+		by, err := h.userLine(ctx, p.IssuedBy)
+		if err != nil {
+			return "", nil, err
+		}
+		lines = append(lines, a.Done+" "+formatTime(p.IssuedAt)+", выдал "+by)
+	}
+	m.Inline(rows...)
+	return "Новый гость: " + name + "\n" + strings.Join(lines, "\n"), m, nil
+}
+
+// onPerk records the perk of the card's guest issued by the staff member and refreshes the card.
+func (h *handler) onPerk(c tele.Context) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	kind, id, _ := strings.Cut(c.Data(), "|")
+	userID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad perk button data %q: %w", c.Data(), err)
+	}
+	if _, ok := findPerkAction(kind); !ok {
+		return fmt.Errorf("unknown perk %q", kind)
+	}
+	err = h.storage.Perks.Insert(ctx, &model.Perk{UserID: userID, Kind: kind, IssuedBy: uint64(c.Sender().ID)})
+	if errors.Is(err, wrap.AlreadyExistsError{}) {
+		if err := c.Respond(&tele.CallbackResponse{Text: "Уже выдано"}); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	text, m, err := h.registrationCard(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return c.Edit(text, m)
+}
+
+func findPerkAction(kind string) (perkAction, bool) {
+	for _, a := range perkActions {
+		if a.Kind == kind {
+			return a, true
+		}
+	}
+	return perkAction{}, false
+}
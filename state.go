@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/failoverbar/bot/fsm"
+	"github.com/failoverbar/bot/model"
+	"github.com/failoverbar/bot/wrap"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// conversationKey keeps model.Conversation of the sender in tele.Context while the update is handled.
+const conversationKey = "conversation"
+
+// stateStore keeps conversation states in User.State. Changes of the conversation made while
+// handling the update are stored together with the state, and it is cleared when the dialog is over.
+type stateStore struct {
+	h *handler
+}
+
+func (s stateStore) State(ctx context.Context, c tele.Context) (string, error) {
+	user, err := s.h.userRepo.Get(ctx, uint64(c.Sender().ID))
+	if errors.Is(err, wrap.NotFoundError{}) {
+		return "", fsm.ErrNoState
+	}
+	if err != nil {
+		return "", err
+	}
+	return user.State, nil
+}
+
+func (s stateStore) SetState(ctx context.Context, c tele.Context, state string) error {
+	return s.h.updateConversation(ctx, c, func(user *model.User) {
+		user.State = state
+		if state == fsm.Idle {
+			user.ClearConversation()
+		}
+	})
+}
+
+// conversation returns scratch data of the sender's dialog. It is read once per update and
+// stored on transition to another state or by saveConversation.
+func (h *handler) conversation(ctx context.Context, c tele.Context) (*model.Conversation, error) {
+	if conv, ok := c.Get(conversationKey).(*model.Conversation); ok {
+		return conv, nil
+	}
+	user, err := h.userRepo.Get(ctx, uint64(c.Sender().ID))
+	if err != nil {
+		return nil, err
+	}
+	conv, err := user.Conversation()
+	if err != nil {
+		return nil, err
+	}
+	c.Set(conversationKey, conv)
+	return conv, nil
+}
+
+// saveConversation stores changes of the conversation without changing the state.
+func (h *handler) saveConversation(ctx context.Context, c tele.Context) error {
+	return h.updateConversation(ctx, c, func(*model.User) {})
+}
+
+// updateConversation applies change to the user along with the conversation read by h.conversation.
+func (h *handler) updateConversation(ctx context.Context, c tele.Context, change func(user *model.User)) error {
+	conv, _ := c.Get(conversationKey).(*model.Conversation)
+	var encodeErr error
+	_, err := h.updateUser(ctx, uint64(c.Sender().ID), func(user *model.User) {
+		if conv != nil {
+			encodeErr = user.SetConversation(conv)
+		}
+		change(user)
+	})
+	if err != nil {
+		return err
+	}
+	return encodeErr
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/failoverbar/bot/model"
+	"github.com/failoverbar/bot/wrap"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Buttons of the subscription manager. Data of subscriptionToggleButton is the topic.
+var (
+	subscriptionToggleButton = tele.Btn{Unique: "subs_toggle"}
+	subscriptionsOffButton   = tele.Btn{Unique: "subs_off"}
+)
+
+const subscriptionsText = "Твои подписки. Нажми на тему, чтобы подписаться или отписаться."
+
+func (h *handler) onSubscriptions(c tele.Context) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	m, err := h.subscriptionsMarkup(ctx, uint64(c.Sender().ID))
+	if err != nil {
+		return err
+	}
+	return c.Send(subscriptionsText, m)
+}
+
+func (h *handler) onSubscriptionToggle(c tele.Context) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	userID := uint64(c.Sender().ID)
+	topic := c.Data()
+	err := h.tx.InTx(ctx, func(ctx context.Context) error {
+		s, err := h.subscriptionsRepo.Get(ctx, userID, topic)
+		if errors.Is(err, wrap.NotFoundError{}) {
+			s, err = &model.Subscription{UserID: userID, Topic: topic}, nil
+		}
+		if err != nil {
+			return err
+		}
+		s.Active = !s.Active
+		return h.subscriptionsRepo.Upsert(ctx, s)
+	})
+	if errors.Is(err, wrap.UnknownReferenceError{}) {
+		if err := c.Respond(&tele.CallbackResponse{Text: "Такой темы больше нет"}); err != nil {
+			return err
+		}
+		return h.refreshSubscriptions(ctx, c)
+	}
+	if err != nil {
+		return err
+	}
+	return h.refreshSubscriptions(ctx, c)
+}
+
+// onSubscriptionsOff pauses all subscriptions of the user keeping their rows.
+func (h *handler) onSubscriptionsOff(c tele.Context) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	ss, err := h.subscriptionsRepo.GetByUserID(ctx, uint64(c.Sender().ID))
+	if err != nil {
+		return err
+	}
+	for _, s := range ss {
+		if !s.Active {
+			continue
+		}
+		s.Active = false
+		if err := h.subscriptionsRepo.Upsert(ctx, s); err != nil {
+			return err
+		}
+	}
+	return h.refreshSubscriptions(ctx, c)
+}
+
+// refreshSubscriptions updates the manager message in place.
+func (h *handler) refreshSubscriptions(ctx context.Context, c tele.Context) error {
+	m, err := h.subscriptionsMarkup(ctx, uint64(c.Sender().ID))
+	if err != nil {
+		return err
+	}
+	err = c.Edit(subscriptionsText, m)
+	if errors.Is(err, tele.ErrSameMessageContent) || errors.Is(err, tele.ErrMessageNotModified) {
+		return nil
+	}
+	return err
+}
+
+// subscriptionsMarkup lists listed topics of the catalog and the other ones the user is subscribed to,
+// so that subscriptions to hidden or archived topics can be turned off.
+func (h *handler) subscriptionsMarkup(ctx context.Context, userID uint64) (*tele.ReplyMarkup, error) {
+	ss, err := h.subscriptionsRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	active := map[string]bool{}
+	for _, s := range ss {
+		active[s.Topic] = s.Active
+	}
+	topics, err := h.topicRepo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m := h.bot.NewMarkup()
+	rows := make([]tele.Row, 0, len(topics)+1)
+	for _, topic := range topics {
+		if !topic.Listed() && !active[topic.Slug] {
+			continue
+		}
+		title := topic.Title
+		if active[topic.Slug] {
+			title = "✅ " + title
+		}
+		rows = append(rows, m.Row(m.Data(title, subscriptionToggleButton.Unique, topic.Slug)))
+	}
+	rows = append(rows, m.Row(m.Data("Отписаться от всего", subscriptionsOffButton.Unique)))
+	m.Inline(rows...)
+	return m, nil
+}
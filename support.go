@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/failoverbar/bot/delivery"
+	"github.com/failoverbar/bot/model"
+	"github.com/failoverbar/bot/wrap"
+	"log"
+	"strconv"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// ticketCloseButton data is the user id and the ticket id.
+var ticketCloseButton = tele.Btn{Unique: "ticket_close"}
+
+// withSupportChat handles messages of the support chat instead of next, staff don't talk to the bot there.
+func (h *handler) withSupportChat(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if h.supportChatID != 0 && c.Chat() != nil && c.Chat().ID == h.supportChatID {
+			return h.onSupportMessage(c)
+		}
+		return next(c)
+	}
+}
+
+// privateChat passes updates of private chats to next and ignores updates of groups,
+// so that members of staff and support chats don't start dialogs there.
+func privateChat(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if c.Chat() == nil || c.Chat().Type != tele.ChatPrivate {
+			return nil
+		}
+		return next(c)
+	}
+}
+
+// relayToSupport copies the message of the user to the thread of the open ticket, opening a ticket if there is none.
+func (h *handler) relayToSupport(ctx context.Context, c tele.Context) error {
+	h.supportMu.Lock()
+	defer h.supportMu.Unlock()
+	userID := uint64(c.Sender().ID)
+	ticket, opened, err := h.openTicket(ctx, userID)
+	if err != nil {
+		return err
+	}
+	copied, err := h.bot.Copy(tele.ChatID(h.supportChatID), c.Message(), &tele.SendOptions{
+		ReplyTo: &tele.Message{ID: int(ticket.ThreadID)},
+	})
+	if err != nil {
+		return err
+	}
+	err = h.storage.Tickets.AddMessage(ctx, &model.TicketMessage{MessageID: uint64(copied.ID), UserID: userID, TicketID: ticket.ID})
+	if err != nil {
+		return err
+	}
+	if opened {
+		return c.Send("Передал твоё сообщение команде бара, ответ придёт сюда.")
+	}
+	return nil
+}
+
+// openTicket returns the open ticket of the user creating it and its thread in the support chat if needed.
+// opened is true for new tickets.
+func (h *handler) openTicket(ctx context.Context, userID uint64) (ticket *model.Ticket, opened bool, err error) {
+	err = h.tx.InTx(ctx, func(ctx context.Context) error {
+		ticket, opened = nil, false
+		ts, err := h.storage.Tickets.GetByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		for _, t := range ts {
+			if t.Status == model.TicketOpen {
+				ticket = t
+			}
+		}
+		if ticket != nil {
+			return nil
+		}
+		ticket = &model.Ticket{UserID: userID, Status: model.TicketOpen}
+		opened = true
+		return h.storage.Tickets.Insert(ctx, ticket)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if ticket.ThreadID != 0 {
+		return ticket, opened, nil
+	}
+	// The thread is missing if posting it failed after the ticket was created.
+	text, m, err := h.ticketCard(ctx, ticket)
+	if err != nil {
+		return nil, false, err
+	}
+	thread, err := h.bot.Send(tele.ChatID(h.supportChatID), text, m)
+	if err != nil {
+		return nil, false, err
+	}
+	ticket.ThreadID = uint64(thread.ID)
+	if err := h.storage.Tickets.Upsert(ctx, ticket); err != nil {
+		return nil, false, err
+	}
+	err = h.storage.Tickets.AddMessage(ctx, &model.TicketMessage{MessageID: ticket.ThreadID, UserID: userID, TicketID: ticket.ID})
+	if err != nil {
+		return nil, false, err
+	}
+	return ticket, opened, nil
+}
+
+// ticketCard is the first message of the ticket thread with the button closing an open ticket.
+func (h *handler) ticketCard(ctx context.Context, t *model.Ticket) (string, *tele.ReplyMarkup, error) {
+	from, err := h.userLine(ctx, t.UserID)
+	if err != nil {
+		return "", nil, err
+	}
+	lines := []string{
+		"📩 Обращение от " + from,
+		"Открыто " + formatTime(t.CreatedAt),
+		"Отвечайте реплаем на сообщения гостя, он не увидит, кто ответил.",
+	}
+	m := h.bot.NewMarkup()
+	if t.Status == model.TicketClosed {
+		by, err := h.userLine(ctx, t.ClosedBy)
+		if err != nil {
+			return "", nil, err
+		}
+		lines = append(lines, "Закрыто "+formatTime(t.UpdatedAt)+", закрыл "+by)
+		m.Inline()
+	} else {
+		data := strconv.FormatUint(t.UserID, 10) + "|" + strconv.FormatUint(t.ID, 10)
+		m.Inline(m.Row(m.Data("Закрыть", ticketCloseButton.Unique, data)))
+	}
+	return strings.Join(lines, "\n"), m, nil
+}
+
+// onSupportMessage sends replies of staff to messages of tickets back to their users without revealing who answered.
+// Other messages of the support chat are staff talking to each other.
+func (h *handler) onSupportMessage(c tele.Context) error {
+	msg := c.Message()
+	if msg == nil || msg.ReplyTo == nil {
+		return nil
+	}
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	tm, err := h.storage.Tickets.GetMessage(ctx, uint64(msg.ReplyTo.ID))
+	if errors.Is(err, wrap.NotFoundError{}) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ok, err := hasRole(ctx, h.userRepo, c, model.RoleStaff)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		log.Printf("%d has no role %s to answer ticket %d", c.Sender().ID, model.RoleStaff, tm.TicketID)
+		return nil
+	}
+	ticket, err := h.storage.Tickets.Get(ctx, tm.UserID, tm.TicketID)
+	if errors.Is(err, wrap.NotFoundError{}) {
+		_, err = h.bot.Reply(msg, "Гость удалил свои данные, ответить ему нельзя.")
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := h.bot.Copy(&tele.User{ID: int64(tm.UserID)}, msg); err != nil {
+		reason := delivery.Classify(err)
+		if !reason.Unreachable() {
+			return err
+		}
+		if err := h.markUnreachable(ctx, tm.UserID, reason); err != nil {
+			log.Printf("can't flag unreachable user %d: %s", tm.UserID, err)
+		}
+		_, err = h.bot.Reply(msg, fmt.Sprintf("Не доставлено: гость недоступен (%s).", reason))
+		return err
+	}
+	err = h.storage.Tickets.AddMessage(ctx, &model.TicketMessage{MessageID: uint64(msg.ID), UserID: tm.UserID, TicketID: tm.TicketID})
+	if err != nil {
+		return err
+	}
+	if ticket.Status == model.TicketOpen {
+		return nil
+	}
+	// The answer reopens the ticket, so that the user's reply lands in the same thread.
+	ticket.Status, ticket.ClosedBy = model.TicketOpen, 0
+	return h.updateTicket(ctx, ticket)
+}
+
+// onTicketClose closes the ticket and tells the user about it. The next message of the user opens a new ticket.
+func (h *handler) onTicketClose(c tele.Context) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	user, id, _ := strings.Cut(c.Data(), "|")
+	userID, err := strconv.ParseUint(user, 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad ticket button data %q: %w", c.Data(), err)
+	}
+	ticketID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad ticket button data %q: %w", c.Data(), err)
+	}
+	ticket, err := h.storage.Tickets.Get(ctx, userID, ticketID)
+	if errors.Is(err, wrap.NotFoundError{}) {
+		return c.Edit("Гость удалил свои данные вместе с обращением.")
+	}
+	if err != nil {
+		return err
+	}
+	if ticket.Status == model.TicketClosed {
+		return c.Respond(&tele.CallbackResponse{Text: "Обращение уже закрыто"})
+	}
+	ticket.Status, ticket.ClosedBy = model.TicketClosed, uint64(c.Sender().ID)
+	if err := h.updateTicket(ctx, ticket); err != nil {
+		return err
+	}
+	if err := h.sendTo(ctx, userID, "Обращение закрыто. Если появятся вопросы, просто напиши сюда."); err != nil {
+		log.Printf("can't tell %d ticket %d is closed: %s", userID, ticketID, err)
+	}
+	return nil
+}
+
+// updateTicket stores the ticket and refreshes its card in the support chat.
+func (h *handler) updateTicket(ctx context.Context, t *model.Ticket) error {
+	if err := h.storage.Tickets.Upsert(ctx, t); err != nil {
+		return err
+	}
+	text, m, err := h.ticketCard(ctx, t)
+	if err != nil {
+		return err
+	}
+	thread := &tele.StoredMessage{MessageID: strconv.FormatUint(t.ThreadID, 10), ChatID: h.supportChatID}
+	if _, err := h.bot.Edit(thread, text, m); err != nil {
+		log.Printf("can't update card of ticket %d: %s", t.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/failoverbar/bot/model"
+	"github.com/failoverbar/bot/wrap"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// defaultTopics fill an empty catalog on the first start.
+var defaultTopics = []model.Topic{
+	{Slug: eventsTopic, Title: "Мероприятия бара", SortOrder: 10},
+	{Slug: "meetups", Title: "IT-митапы", SortOrder: 20},
+	{Slug: "parties", Title: "Вечеринки", SortOrder: 30},
+}
+
+// topicSlugRx keeps slugs usable in sub_<slug> deep links and callback data.
+var topicSlugRx = regexp.MustCompile(`^[a-z0-9_-]{1,48}$`)
+
+// seedTopics inserts defaultTopics if there are no topics yet.
+func seedTopics(ctx context.Context, topics model.TopicStorage) error {
+	ts, err := topics.List(ctx)
+	if err != nil {
+		return err
+	}
+	if len(ts) > 0 {
+		return nil
+	}
+	for _, t := range defaultTopics {
+		t := t
+		if err := topics.Insert(ctx, &t); err != nil && !errors.Is(err, wrap.AlreadyExistsError{}) {
+			return err
+		}
+	}
+	log.Printf("topic catalog is empty, added %d default topics", len(defaultTopics))
+	return nil
+}
+
+// listedTopics returns topics shown to users in catalog order.
+func (h *handler) listedTopics(ctx context.Context) ([]*model.Topic, error) {
+	ts, err := h.topicRepo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	listed := ts[:0]
+	for _, t := range ts {
+		if t.Listed() {
+			listed = append(listed, t)
+		}
+	}
+	return listed, nil
+}
+
+// onTopics lists the whole catalog for admins.
+func (h *handler) onTopics(c tele.Context) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	ts, err := h.topicRepo.List(ctx)
+	if err != nil {
+		return err
+	}
+	if len(ts) == 0 {
+		return c.Send("Тем пока нет. Добавь первую: /topic_add slug | Название | Описание")
+	}
+	lines := make([]string, 0, len(ts))
+	for _, t := range ts {
+		lines = append(lines, topicLine(t))
+	}
+	return c.Send(strings.Join(lines, "\n")+"\n\n"+topicsHelp, tele.NoPreview)
+}
+
+const topicsHelp = "/topic_add slug | Название | Описание\n" +
+	"/topic_edit slug | Название | Описание\n" +
+	"/topic_hide slug, /topic_show slug\n" +
+	"/topic_archive slug, /topic_restore slug\n" +
+	"/topic_default slug on|off\n" +
+	"/topic_order slug число"
+
+func topicLine(t *model.Topic) string {
+	line := fmt.Sprintf("%d. %s — %s", t.SortOrder, t.Slug, t.Title)
+	var flags []string
+	if t.DefaultOn {
+		flags = append(flags, "по умолчанию")
+	}
+	if t.Hidden {
+		flags = append(flags, "скрыта")
+	}
+	if t.Archived {
+		flags = append(flags, "в архиве")
+	}
+	if len(flags) > 0 {
+		line += " (" + strings.Join(flags, ", ") + ")"
+	}
+	return line
+}
+
+// onTopicAdd creates a topic from "slug | title | description", the description is optional.
+// New topics go to the end of the catalog.
+func (h *handler) onTopicAdd(c tele.Context) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	slug, title, description, ok := parseTopicArgs(c.Message().Payload)
+	if !ok {
+		return c.Send("Формат: /topic_add slug | Название | Описание\nSlug — латиница в нижнем регистре, цифры, _ и -.")
+	}
+	ts, err := h.topicRepo.List(ctx)
+	if err != nil {
+		return err
+	}
+	t := &model.Topic{Slug: slug, Title: title, Description: description}
+	if len(ts) > 0 {
+		t.SortOrder = ts[len(ts)-1].SortOrder + 10
+	}
+	err = h.topicRepo.Insert(ctx, t)
+	if errors.Is(err, wrap.AlreadyExistsError{}) {
+		return c.Send("Тема " + slug + " уже есть, поменять её можно командой /topic_edit.")
+	}
+	if err != nil {
+		return err
+	}
+	return c.Send("Добавил тему: " + topicLine(t))
+}
+
+func (h *handler) onTopicEdit(c tele.Context) error {
+	slug, title, description, ok := parseTopicArgs(c.Message().Payload)
+	if !ok {
+		return c.Send("Формат: /topic_edit slug | Название | Описание")
+	}
+	return h.editTopic(c, slug, func(t *model.Topic) {
+		t.Title = title
+		t.Description = description
+	})
+}
+
+func (h *handler) onTopicHide(c tele.Context) error {
+	return h.editTopic(c, c.Message().Payload, func(t *model.Topic) { t.Hidden = true })
+}
+
+func (h *handler) onTopicShow(c tele.Context) error {
+	return h.editTopic(c, c.Message().Payload, func(t *model.Topic) { t.Hidden = false })
+}
+
+// onTopicArchive forbids new subscriptions to the topic. Existing ones are kept, so users can still pause them.
+func (h *handler) onTopicArchive(c tele.Context) error {
+	return h.editTopic(c, c.Message().Payload, func(t *model.Topic) { t.Archived = true })
+}
+
+func (h *handler) onTopicRestore(c tele.Context) error {
+	return h.editTopic(c, c.Message().Payload, func(t *model.Topic) { t.Archived = false })
+}
+
+func (h *handler) onTopicDefault(c tele.Context) error {
+	args := c.Args()
+	if len(args) != 2 || (args[1] != "on" && args[1] != "off") {
+		return c.Send("Формат: /topic_default slug on|off")
+	}
+	return h.editTopic(c, args[0], func(t *model.Topic) { t.DefaultOn = args[1] == "on" })
+}
+
+func (h *handler) onTopicOrder(c tele.Context) error {
+	args := c.Args()
+	if len(args) != 2 {
+		return c.Send("Формат: /topic_order slug число")
+	}
+	order, err := strconv.ParseUint(args[1], 10, 32)
+	if err != nil {
+		return c.Send("Порядок должен быть неотрицательным числом.")
+	}
+	return h.editTopic(c, args[0], func(t *model.Topic) { t.SortOrder = uint32(order) })
+}
+
+// editTopic applies change to the topic and reports the result.
+func (h *handler) editTopic(c tele.Context, slug string, change func(t *model.Topic)) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	slug = strings.TrimSpace(slug)
+	if slug == "" {
+		return c.Send("Укажи slug темы, список тем: /topics")
+	}
+	t, err := h.topicRepo.Get(ctx, slug)
+	if errors.Is(err, wrap.NotFoundError{}) {
+		return c.Send("Темы " + slug + " нет, список тем: /topics")
+	}
+	if err != nil {
+		return err
+	}
+	change(t)
+	if err := h.topicRepo.Upsert(ctx, t); err != nil {
+		return err
+	}
+	return c.Send("Готово: " + topicLine(t))
+}
+
+// parseTopicArgs splits "slug | title | description".
+func parseTopicArgs(payload string) (slug, title, description string, ok bool) {
+	parts := strings.SplitN(payload, "|", 3)
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	if len(parts) < 2 || !topicSlugRx.MatchString(parts[0]) || parts[1] == "" {
+		return "", "", "", false
+	}
+	if len(parts) == 3 {
+		description = parts[2]
+	}
+	return parts[0], parts[1], description, true
+}
@@ -7,3 +7,29 @@ type NotFoundError struct{}
 func (n NotFoundError) Error() string {
 	return "Entity is not found"
 }
+
+var _ error = AlreadyExistsError{}
+
+type AlreadyExistsError struct{}
+
+func (a AlreadyExistsError) Error() string {
+	return "Entity already exists"
+}
+
+var _ error = ConflictError{}
+
+// ConflictError means entity was changed concurrently and the operation may be retried on a fresh copy.
+type ConflictError struct{}
+
+func (c ConflictError) Error() string {
+	return "Entity was changed concurrently"
+}
+
+var _ error = UnknownReferenceError{}
+
+// UnknownReferenceError means entity refers to another one which doesn't exist or can't be referred.
+type UnknownReferenceError struct{}
+
+func (u UnknownReferenceError) Error() string {
+	return "Entity refers to unknown entity"
+}